@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The bytecode backend translates the analyzed AST into a simple, textual stack machine code. It needs no assembler
+// or linker, so the structure of the generated code can be checked on any system.
+//
+//	push V      push a constant (ints, uints with 'u', floats, bools as true/false, quoted strings)
+//	load x      push the value of variable x
+//	store x     pop the top value into variable x
+//	pop         drop the top value
+//	add, sub, mul, div, mod, pow, eq, ne, lt, le, gt, ge, and, or
+//	            pop the right, then the left operand and push the result
+//	neg, not, bitnot
+//	            replace the top value
+//	call f      call the builtin f with its arguments on top (the last one topmost). Pushes the result, if there is one.
+//	index       pop the index, then the string and push the byte at the index
+//	conv T      convert the top value to type T (int, uint, float, bool, string)
+//	jz L        pop the top value and jump to L, if it is false
+//	jmp L       jump to L
+//
+// Variables are named like in the source. A shadowing variable gets a new name with a number (a#1, a#2, ...). A '#'
+// can not be part of a name in the source, so a variable 'a_1' keeps its own name.
+// The fields of a struct are variables of their own, named like the access in the source (p.x).
+
+type Bytecode struct {
+	program []string
+
+	labelName int
+	// Variable names of all blocks around the current statement, innermost last
+	scopes []map[string]string
+	// Number of variables with the same name in the source
+	names map[string]int
+	// Jump targets of all loops around the current statement, innermost last. [continue label, break label]
+	loops [][2]string
+}
+
+func (bc *Bytecode) nextLabelName() string {
+	bc.labelName += 1
+	return fmt.Sprintf("L%v", bc.labelName-1)
+}
+
+func (bc *Bytecode) emit(instruction string, args ...interface{}) {
+	bc.program = append(bc.program, "  "+strings.TrimSpace(instruction+" "+fmt.Sprint(args...)))
+}
+
+func (bc *Bytecode) label(name string) {
+	bc.program = append(bc.program, name+":")
+}
+
+// variable returns the name of the closest variable
+func (bc *Bytecode) variable(name string) (string, bool) {
+	for i := len(bc.scopes) - 1; i >= 0; i-- {
+		if v, ok := bc.scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// define adds a new variable to the innermost block
+func (bc *Bytecode) define(name string) string {
+	v := name
+	if n := bc.names[name]; n > 0 {
+		v = fmt.Sprintf("%v#%v", name, n)
+	}
+	bc.names[name]++
+	bc.scopes[len(bc.scopes)-1][name] = v
+	return v
+}
+
+func bytecodeOperator(o Operator) string {
+	switch o {
+	case OP_PLUS:
+		return "add"
+	case OP_MINUS:
+		return "sub"
+	case OP_MULT:
+		return "mul"
+	case OP_DIV:
+		return "div"
+	case OP_MOD:
+		return "mod"
+	case OP_POW:
+		return "pow"
+	case OP_NEGATIVE:
+		return "neg"
+	case OP_EQ:
+		return "eq"
+	case OP_NE:
+		return "ne"
+	case OP_LE:
+		return "le"
+	case OP_GE:
+		return "ge"
+	case OP_LESS:
+		return "lt"
+	case OP_GREATER:
+		return "gt"
+	case OP_AND:
+		return "and"
+	case OP_OR:
+		return "or"
+	case OP_NOT:
+		return "not"
+	case OP_BITNOT:
+		return "bitnot"
+	}
+	panic(fmt.Sprintf("Bytecode generation error. Unknown operator: %v", o))
+}
+
+func (bc *Bytecode) expression(expression Expression) {
+	switch e := expression.(type) {
+	case Constant:
+		if e.cType == TYPE_STRING {
+			bc.emit("push", strconv.Quote(constString(e)))
+			return
+		}
+		bc.emit("push", e.cValue)
+	case Variable:
+		v, ok := bc.variable(e.vName)
+		if !ok {
+			panic(fmt.Sprintf("Bytecode generation error. Unknown variable: %v", e.vName))
+		}
+		bc.emit("load", v)
+	case UnaryOp:
+		bc.expression(e.expr)
+		bc.emit(bytecodeOperator(e.operator))
+	case BinaryOp:
+		bc.expression(e.leftExpr)
+		bc.expression(e.rightExpr)
+		bc.emit(bytecodeOperator(e.operator))
+	case FunctionCall:
+		for _, a := range e.args {
+			bc.expression(a)
+		}
+		bc.emit("call", e.name)
+	case Index:
+		bc.expression(e.expr)
+		bc.expression(e.index)
+		bc.emit("index")
+	case FieldAccess:
+		bc.emit("load", bc.field(e))
+	case Conversion:
+		bc.expression(e.expr)
+		bc.emit("conv", e.cType)
+	default:
+		panic(fmt.Sprintf("Bytecode generation error. Unknown expression: %v", expression))
+	}
+}
+
+// field returns the name of the variable, that holds a struct field
+func (bc *Bytecode) field(f FieldAccess) string {
+	v, ok := bc.variable(f.variable.vName)
+	if !ok {
+		panic(fmt.Sprintf("Bytecode generation error. Unknown struct: %v", f.variable.vName))
+	}
+	return v + "." + f.field
+}
+
+// assignment calculates all values before the first variable is stored, just like the x86 code does
+func (bc *Bytecode) assignment(a Assignment) {
+	for _, e := range a.expressions {
+		bc.expression(e)
+	}
+
+	names := make([]string, len(a.variables))
+	for i, v := range a.variables {
+		if v.vName == blankIdentifier {
+			continue
+		}
+		name, ok := bc.variable(v.vName)
+		if !ok || v.vShadow {
+			name = bc.define(v.vName)
+		}
+		names[i] = name
+	}
+	// The value of '_' is dropped
+	for i := len(names) - 1; i >= 0; i-- {
+		if names[i] == "" {
+			bc.emit("pop")
+			continue
+		}
+		bc.emit("store", names[i])
+	}
+}
+
+func (bc *Bytecode) statement(statement Statement) {
+	switch s := statement.(type) {
+	case Assignment:
+		bc.assignment(s)
+	case ConstDeclaration:
+		// Constants are folded into their uses
+	case StructDeclaration:
+		name := bc.define(s.variable.vName)
+		for _, f := range s.fields {
+			bc.expression(zeroConstant(f.fType, f.line, f.column))
+			bc.emit("store", name+"."+f.name)
+		}
+	case FieldAssignment:
+		bc.expression(s.expression)
+		bc.emit("store", bc.field(s.field))
+	case ExprStatement:
+		bc.expression(s.expression)
+		if s.expression.getExpressionType() != TYPE_VOID {
+			bc.emit("pop")
+		}
+	case Condition:
+		elseLabel := bc.nextLabelName()
+		endLabel := bc.nextLabelName()
+		bc.expression(s.expression)
+		bc.emit("jz", elseLabel)
+		bc.block(s.block)
+		bc.emit("jmp", endLabel)
+		bc.label(elseLabel)
+		bc.block(s.elseBlock)
+		bc.label(endLabel)
+	case Loop:
+		startLabel := bc.nextLabelName()
+		incrLabel := bc.nextLabelName()
+		evalLabel := bc.nextLabelName()
+		endLabel := bc.nextLabelName()
+
+		// The variables of the loop header belong to the loop block
+		bc.scopes = append(bc.scopes, make(map[string]string))
+		bc.assignment(s.assignment)
+		bc.emit("jmp", evalLabel)
+		bc.label(startLabel)
+
+		bc.loops = append(bc.loops, [2]string{incrLabel, endLabel})
+		bc.statements(s.block.statements)
+		bc.loops = bc.loops[:len(bc.loops)-1]
+
+		bc.label(incrLabel)
+		bc.assignment(s.incrAssignment)
+		bc.label(evalLabel)
+		for _, e := range s.expressions {
+			bc.expression(e)
+			bc.emit("jz", endLabel)
+		}
+		bc.emit("jmp", startLabel)
+		bc.label(endLabel)
+		bc.scopes = bc.scopes[:len(bc.scopes)-1]
+	case Block:
+		bc.block(s)
+	case Break:
+		bc.emit("jmp", bc.loops[len(bc.loops)-1][1])
+	case Continue:
+		bc.emit("jmp", bc.loops[len(bc.loops)-1][0])
+	case Label:
+		bc.label(labelAsmName(s.name, s.id))
+	case Goto:
+		bc.emit("jmp", labelAsmName(s.label, s.id))
+	default:
+		panic(fmt.Sprintf("Bytecode generation error. Unknown statement: %v", statement))
+	}
+}
+
+func (bc *Bytecode) statements(statements []Statement) {
+	var current Statement
+	defer func() { positionPanic(recover(), current) }()
+
+	for _, s := range statements {
+		current = s
+		bc.statement(s)
+	}
+}
+
+func (bc *Bytecode) block(b Block) {
+	bc.scopes = append(bc.scopes, make(map[string]string))
+	bc.statements(b.statements)
+	bc.scopes = bc.scopes[:len(bc.scopes)-1]
+}
+
+// generate translates the analyzed AST into bytecode. See CodeGenerator.
+func (bc *Bytecode) generate(ast AST, options Options) {
+	// The predefined variables
+	*bc = Bytecode{names: map[string]int{"argc": 1}}
+	bc.scopes = append(bc.scopes, map[string]string{"argc": "argc"})
+	bc.block(ast.block)
+}
+
+// write writes the bytecode to out, one instruction or label per line
+func (bc *Bytecode) write(out io.Writer, options Options) error {
+	_, err := io.WriteString(out, strings.Join(bc.program, "\n")+"\n")
+	return err
+}
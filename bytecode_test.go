@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testBytecode compares the bytecode of the analyzed code with the expected lines
+func testBytecode(code []byte, expected string, t *testing.T) {
+	ast, err := analyzeCode(code)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var bc Bytecode
+	bc.generate(ast, Options{})
+	var bytecode strings.Builder
+	if err := bc.write(&bytecode, Options{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if bytecode.String() != expected {
+		t.Errorf("Expected bytecode:\n%v\ngot:\n%v", expected, bytecode.String())
+	}
+}
+
+func TestBytecodeAssignment(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	b, c = a * 2 + 3, "x"
+	if true {
+		shadow a = a + 1
+		print(a)
+	}
+	`)
+
+	testBytecode(code, `  push 1
+  store a
+  load a
+  push 2
+  mul
+  push 3
+  add
+  push "x"
+  store c
+  store b
+  push true
+  jz L0
+  load a
+  push 1
+  add
+  store a#1
+  load a#1
+  call print
+  jmp L1
+L0:
+L1:
+`, t)
+}
+
+func TestBytecodeShadowName(t *testing.T) {
+
+	// The shadowing 'a' must not become the variable 'a_1' of the source
+	var code []byte = []byte(`
+	a = 1
+	a_1 = 2
+	if a < a_1 {
+		shadow a = 3
+		print(a_1)
+	}
+	`)
+
+	testBytecode(code, `  push 1
+  store a
+  push 2
+  store a_1
+  load a
+  load a_1
+  lt
+  jz L0
+  push 3
+  store a#1
+  load a_1
+  call print
+  jmp L1
+L0:
+L1:
+`, t)
+}
+
+func TestBytecodeTargets(t *testing.T) {
+	for _, target := range []string{"x86", "bytecode"} {
+		if _, ok := codeGenerators[target]; !ok {
+			t.Errorf("Expected a code generator for the target '%v'", target)
+		}
+	}
+}
+
+func TestBytecodeCondition(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 5
+	if a < 3 {
+		a = 1
+	} else {
+		a = -a
+	}
+	`)
+
+	testBytecode(code, `  push 5
+  store a
+  load a
+  push 3
+  lt
+  jz L0
+  push 1
+  store a
+  jmp L1
+L0:
+  load a
+  neg
+  store a
+L1:
+`, t)
+}
+
+func TestBytecodeLoop(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 10; i++ {
+		if i == 5 {
+			break
+		}
+		continue
+	}
+	`)
+
+	testBytecode(code, `  push 0
+  store i
+  jmp L2
+L0:
+  load i
+  push 5
+  eq
+  jz L4
+  jmp L3
+  jmp L5
+L4:
+L5:
+  jmp L1
+L1:
+  load i
+  push 1
+  add
+  store i
+L2:
+  load i
+  push 10
+  lt
+  jz L3
+  jmp L0
+L3:
+`, t)
+}
+
+func TestBytecodeStruct(t *testing.T) {
+
+	var code []byte = []byte(`
+	struct p { x: int; s: string }
+	p.x = p.x + 1
+	print(p.s)
+	`)
+
+	testBytecode(code, `  push 0
+  store p.x
+  push ""
+  store p.s
+  load p.x
+  push 1
+  add
+  store p.x
+  load p.s
+  call print
+`, t)
+}
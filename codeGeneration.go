@@ -2,8 +2,20 @@ package main
 
 import (
 	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Every value is stored in a qword (8 byte), no matter the type. Variables get a qword slot each in the stack frame
+// ([rbp-8], [rbp-16], ...) and expressions push/pop qwords on the stack.
+//
+// Floats are calculated in xmm registers, which can not be pushed or popped directly. pushRegister and popRegister
+// move them through the stack memory instead.
+//
+// Bools are represented as 0 (false) and 1 (true). Everything producing a bool (constants, comparisons, '!', '&&', '||')
+// keeps to this canonical representation. So '&&' and '||' can be implemented with bitwise 'and'/'or' and '!' with 'xor 1'.
 type ASM struct {
 	header    []string
 	constants [][2]string
@@ -12,8 +24,26 @@ type ASM struct {
 
 	// Increasing number to generate unique const variable names
 	constName int
-	varName   int
+	// The variable slots of the stack frame. true, if the slot is in use. A slot is free again after the last use of
+	// its variable, see Block.generateCode. The number of slots is the size of the stack frame.
+	slots     []bool
 	labelName int
+
+	// Jump targets of all loops around the current statement, innermost last. [continue label, break label]
+	loops [][2]string
+
+	// Common subexpressions of the current expression and the stack slot with their value, once it is computed
+	common map[string]string
+
+	// trapv checks signed integer '+', '-' and '*' for overflow
+	trapv bool
+	// Label and message of every run time check (overflow, index out of range). See trapHandlers.
+	traps [][2]string
+
+	// pie generates position independent code. Data is addressed relative to rip and libc is called through the PLT.
+	pie bool
+	// optimize unrolls small loops. See Loop.unrollCount.
+	optimize bool
 }
 
 func (asm *ASM) nextConstName() string {
@@ -21,9 +51,53 @@ func (asm *ASM) nextConstName() string {
 	return fmt.Sprintf("const_%v", asm.constName-1)
 }
 
+// nextVariableName reserves a free slot in the stack frame and returns its address relative to rbp
 func (asm *ASM) nextVariableName() string {
-	asm.varName += 1
-	return fmt.Sprintf("var_%v", asm.varName-1)
+	return asm.reserveSlots(1)
+}
+
+// reserveSlots reserves n consecutive free slots and returns the address of the last one. Slots are numbered
+// downwards from rbp, so it is the lowest address. The stack frame grows, if there is no such gap.
+func (asm *ASM) reserveSlots(n int) string {
+	start := 0
+	for ; start < len(asm.slots); start++ {
+		free := true
+		for i := start; i < start+n && i < len(asm.slots); i++ {
+			free = free && !asm.slots[i]
+		}
+		if free {
+			break
+		}
+	}
+	for len(asm.slots) < start+n {
+		asm.slots = append(asm.slots, false)
+	}
+	for i := start; i < start+n; i++ {
+		asm.slots[i] = true
+	}
+	return fmt.Sprintf("rbp-%v", 8*(start+n))
+}
+
+// variableSlots returns the indices in asm.slots of the variable at the given address. A struct has one per field.
+func variableSlots(address string, entry SymbolEntry) []int {
+	var offset int
+	fmt.Sscanf(address, "rbp-%d", &offset)
+	slots := []int{offset/8 - 1}
+	for i := 1; i < len(entry.fields); i++ {
+		slots = append(slots, offset/8-1-i)
+	}
+	return slots
+}
+
+// stackFrameSize returns the number of bytes to reserve for the given number of variable slots.
+// _start is not called, so the stack is 16 byte aligned on entry and there is no return address on it. After 'push rbp'
+// the stack is off by 8 byte. The frame size makes up for it, so rsp is 16 byte aligned for all calls (e.g. printf).
+func stackFrameSize(slots int) int {
+	size := 8 * slots
+	if size%16 == 0 {
+		size += 8
+	}
+	return size
 }
 
 func (asm *ASM) nextLabelName() string {
@@ -31,6 +105,72 @@ func (asm *ASM) nextLabelName() string {
 	return fmt.Sprintf("label_%v", asm.labelName-1)
 }
 
+// loadAddress loads the address of a label in the data section into the register
+func loadAddress(indent, register, label string, asm *ASM) {
+	if asm.pie {
+		asm.program = append(asm.program, [3]string{indent, "lea", fmt.Sprintf("%v, [rel %v]", register, label)})
+		return
+	}
+	asm.program = append(asm.program, [3]string{indent, "mov", fmt.Sprintf("%v, %v", register, label)})
+}
+
+// dataOperand returns the memory operand for the qword at a label in the data section
+func dataOperand(label string, asm *ASM) string {
+	if asm.pie {
+		return fmt.Sprintf("qword [rel %v]", label)
+	}
+	return fmt.Sprintf("qword [%v]", label)
+}
+
+// callFunction calls a function of libc
+func callFunction(indent, name string, asm *ASM) {
+	if asm.pie {
+		name += " wrt ..plt"
+	}
+	asm.program = append(asm.program, [3]string{indent, "call", name})
+}
+
+// trap jumps to a handler, that aborts the program with the message, if the jump condition is true
+func trap(jump, message string, asm *ASM) {
+	label := asm.nextLabelName()
+	asm.program = append(asm.program, [3]string{"  ", jump, label})
+	asm.traps = append(asm.traps, [2]string{label, message + "\n"})
+}
+
+// overflowCheck traps, if the last signed operation overflowed. Only with trapv.
+func overflowCheck(op Operator, line, column int, asm *ASM) {
+	if asm.trapv {
+		trap("jo", fmt.Sprintf("[%v:%v] - Integer overflow in '%v'", line, column, op), asm)
+	}
+}
+
+// trapHandlers writes the message of every trap to stderr and aborts the program.
+// The output so far is flushed first. The stack might not be aligned at the failed check.
+func trapHandlers(asm *ASM) {
+	if len(asm.traps) == 0 {
+		return
+	}
+	for _, o := range asm.traps {
+		name := asm.nextConstName()
+		asm.variables = append(asm.variables, [3]string{name + "_len", "dq", fmt.Sprintf("%v", len(o[1]))})
+		asm.variables = append(asm.variables, [3]string{name, "db", asmString(o[1])})
+
+		asm.program = append(asm.program, [3]string{"", o[0] + ":", ""})
+		loadAddress("  ", "rbx", name, asm)
+		asm.program = append(asm.program, [3]string{"  ", "jmp", "trap"})
+	}
+
+	asm.program = append(asm.program, [3]string{"", "trap:", ""})
+	asm.program = append(asm.program, [3]string{"  ", "and", "rsp, -16"})
+	flushOutput("  ", asm)
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rsi, rbx"})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rdx, qword [rsi-8]"})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rdi, 2"})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 1"})
+	asm.program = append(asm.program, [3]string{"  ", "syscall", ""})
+	callFunction("  ", "abort", asm)
+}
+
 func getJumpType(op Operator) string {
 	switch op {
 	case OP_GE:
@@ -49,6 +189,44 @@ func getJumpType(op Operator) string {
 	return ""
 }
 
+func getJumpTypeUnsigned(op Operator) string {
+	switch op {
+	case OP_GE:
+		return "jae"
+	case OP_GREATER:
+		return "ja"
+	case OP_LESS:
+		return "jb"
+	case OP_LE:
+		return "jbe"
+	case OP_EQ:
+		return "je"
+	case OP_NE:
+		return "jne"
+	}
+	return ""
+}
+
+// pushRegister pushes the qword in register. This works for general purpose and xmm registers.
+func pushRegister(register string, asm *ASM) {
+	if strings.HasPrefix(register, "xmm") {
+		asm.program = append(asm.program, [3]string{"  ", "sub", "rsp, 8"})
+		asm.program = append(asm.program, [3]string{"  ", "movsd", fmt.Sprintf("qword [rsp], %v", register)})
+		return
+	}
+	asm.program = append(asm.program, [3]string{"  ", "push", register})
+}
+
+// popRegister pops the qword into register. This works for general purpose and xmm registers.
+func popRegister(register string, asm *ASM) {
+	if strings.HasPrefix(register, "xmm") {
+		asm.program = append(asm.program, [3]string{"  ", "movsd", fmt.Sprintf("%v, qword [rsp]", register)})
+		asm.program = append(asm.program, [3]string{"  ", "add", "rsp, 8"})
+		return
+	}
+	asm.program = append(asm.program, [3]string{"  ", "pop", register})
+}
+
 func getCommandFloat(op Operator) string {
 	switch op {
 	case OP_PLUS:
@@ -62,7 +240,6 @@ func getCommandFloat(op Operator) string {
 	default:
 		panic("Code generation error. Unknown operator for Float")
 	}
-	return ""
 }
 
 func getCommandInt(op Operator) string {
@@ -73,12 +250,9 @@ func getCommandInt(op Operator) string {
 		return "sub"
 	case OP_MULT:
 		return "imul"
-	case OP_DIV:
-		return "div"
 	default:
 		panic("Code generation error. Unknown operator for Integer")
 	}
-	return ""
 }
 
 func getCommandBool(op Operator) string {
@@ -90,17 +264,15 @@ func getCommandBool(op Operator) string {
 	default:
 		panic("Code generation error. Unknown operator for bool")
 	}
-	return ""
 }
 
 func getRegister(t Type) (string, string) {
 	switch t {
-	case TYPE_INT, TYPE_BOOL:
+	case TYPE_INT, TYPE_UINT, TYPE_BOOL, TYPE_STRING:
+		// Strings are handled by their address
 		return "rsi", "rcx"
 	case TYPE_FLOAT:
 		return "xmm0", "xmm1"
-	case TYPE_STRING:
-		panic("Code generation error. String register not yet implemented")
 	}
 	return "", ""
 }
@@ -112,7 +284,8 @@ func getCommand(t Type, op Operator) string {
 		return getCommandBool(op)
 	case TYPE_FLOAT:
 		return getCommandFloat(op)
-	case TYPE_INT:
+	case TYPE_INT, TYPE_UINT:
+		// The lower 64 bit of a multiplication are the same for signed and unsigned numbers
 		return getCommandInt(op)
 	case TYPE_STRING:
 		panic("Code generation error. String commands not yet implemented")
@@ -120,20 +293,62 @@ func getCommand(t Type, op Operator) string {
 	return ""
 }
 
+// asmString returns the null terminated string for 'db'. Printable characters stay in quotes,
+// everything else (e.g. '"', newlines, UTF-8) is written as a number: "a", 10, "b", 0
+func asmString(s string) string {
+	// Builders keep long literals linear. Appending to strings copies everything each time.
+	var b, quoted strings.Builder
+	flush := func() {
+		if quoted.Len() > 0 {
+			fmt.Fprintf(&b, "\"%v\", ", quoted.String())
+			quoted.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] >= ' ' && s[i] <= '~' && s[i] != '"' {
+			quoted.WriteByte(s[i])
+			continue
+		}
+		flush()
+		fmt.Fprintf(&b, "%v, ", s[i])
+	}
+	flush()
+	b.WriteString("0")
+	return b.String()
+}
+
 func (c Constant) generateCode(asm *ASM, s *SymbolTable) {
 
 	name := ""
 	switch c.cType {
-	case TYPE_INT:
+	case TYPE_INT, TYPE_UINT:
 		name = asm.nextConstName()
-		asm.constants = append(asm.constants, [2]string{name, c.cValue})
+		value := strings.TrimSuffix(c.cValue, "u")
+		asm.constants = append(asm.constants, [2]string{name, value})
+		// 'push' only takes sign extended 32 bit immediates. Everything else goes through a register.
+		if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+			asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("rax, %v", name)})
+			name = "rax"
+		}
 	case TYPE_FLOAT:
+		// A float can not be an immediate value. It is pushed from the data section instead.
 		name = asm.nextConstName()
-		asm.constants = append(asm.constants, [2]string{name, c.cValue})
+		asm.variables = append(asm.variables, [3]string{name, "dq", c.cValue})
+		name = dataOperand(name, asm)
 	case TYPE_STRING:
+		// Strings are null terminated in the data section. The value is their address.
+		// Their length is stored in the qword right before, so they can contain null bytes as well.
 		name = asm.nextConstName()
-		asm.constants = append(asm.constants, [2]string{name, fmt.Sprintf("\"%v\", 0", c.cValue)})
+		value := constString(c)
+		asm.variables = append(asm.variables, [3]string{name + "_len", "dq", fmt.Sprintf("%v", len(value))})
+		asm.variables = append(asm.variables, [3]string{name, "db", asmString(value)})
+		// An address is no 32 bit immediate in position independent code
+		if asm.pie {
+			loadAddress("  ", "rax", name, asm)
+			name = "rax"
+		}
 	case TYPE_BOOL:
+		// Bools are the immediate values TRUE (1) and FALSE (0), so they work with 'and', 'or' and 'xor' directly
 		name = "FALSE"
 		if c.cValue == "true" {
 			name = "TRUE"
@@ -145,15 +360,88 @@ func (c Constant) generateCode(asm *ASM, s *SymbolTable) {
 	asm.program = append(asm.program, [3]string{"  ", "push", name})
 }
 
+// getAsm looks up the closest variable, that already has a name in the assembly. Variables without, are not assigned yet.
+// They are skipped, so the right side of 'shadow a = a + 1' refers to the outer 'a'.
+func (s *SymbolTable) getAsm(v string) (SymbolEntry, bool) {
+	if s == nil {
+		return SymbolEntry{}, false
+	}
+	if variable, ok := s.table[v]; ok && variable.varName != "" {
+		return variable, true
+	}
+	return s.parent.getAsm(v)
+}
+
 func (v Variable) generateCode(asm *ASM, s *SymbolTable) {
 
-	if symbol, ok := s.get(v.vName); ok {
+	if symbol, ok := s.getAsm(v.vName); ok {
 		asm.program = append(asm.program, [3]string{"  ", "push", fmt.Sprintf("qword [%v]", symbol.varName)})
 		return
 	}
 	panic("Could not generate code for Variable. No symbol known!")
 }
 
+// fieldAddress returns the address of a struct field relative to rbp. A struct gets one slot per field and starts at
+// the lowest address of them.
+func fieldAddress(f FieldAccess, s *SymbolTable) string {
+	if symbol, ok := s.getAsm(f.variable.vName); ok {
+		return fmt.Sprintf("%v+%v", symbol.varName, f.offset)
+	}
+	panic("Could not generate code for FieldAccess. No symbol known!")
+}
+
+func (f FieldAccess) generateCode(asm *ASM, s *SymbolTable) {
+	asm.program = append(asm.program, [3]string{"  ", "push", fmt.Sprintf("qword [%v]", fieldAddress(f, s))})
+}
+
+// Ints, uints and bools are all integers in a qword. Only the conversions from and to float and into bool change the
+// value.
+func (c Conversion) generateCode(asm *ASM, s *SymbolTable) {
+	c.expr.generateCode(asm, s)
+
+	from := c.expr.getExpressionType()
+	switch {
+	case from == c.cType:
+	case from == TYPE_FLOAT:
+		// Truncates toward zero. Out of range values become 0x8000000000000000.
+		popRegister("xmm0", asm)
+		if c.cType == TYPE_UINT {
+			// cvttsd2si only results in a signed value. From 2**63 on, that is subtracted first and its bit set again.
+			labelSigned := asm.nextLabelName()
+			labelDone := asm.nextLabelName()
+			asm.program = append(asm.program, [3]string{"  ", "comisd", "xmm0, " + dataOperand("twoPow63", asm)})
+			asm.program = append(asm.program, [3]string{"  ", "jb", labelSigned})
+			asm.program = append(asm.program, [3]string{"  ", "subsd", "xmm0, " + dataOperand("twoPow63", asm)})
+			asm.program = append(asm.program, [3]string{"  ", "cvttsd2si", "rax, xmm0"})
+			asm.program = append(asm.program, [3]string{"  ", "bts", "rax, 63"})
+			asm.program = append(asm.program, [3]string{"  ", "jmp", labelDone})
+			asm.program = append(asm.program, [3]string{"", labelSigned + ":", ""})
+			asm.program = append(asm.program, [3]string{"  ", "cvttsd2si", "rax, xmm0"})
+			asm.program = append(asm.program, [3]string{"", labelDone + ":", ""})
+		} else {
+			asm.program = append(asm.program, [3]string{"  ", "cvttsd2si", "rax, xmm0"})
+		}
+		pushRegister("rax", asm)
+	case c.cType == TYPE_FLOAT:
+		popRegister("rax", asm)
+		asm.program = append(asm.program, [3]string{"  ", "cvtsi2sd", "xmm0, rax"})
+		if from == TYPE_UINT {
+			// cvtsi2sd reads a signed value. With the highest bit set, the uint is 2**64 too small.
+			label := asm.nextLabelName()
+			asm.program = append(asm.program, [3]string{"  ", "test", "rax, rax"})
+			asm.program = append(asm.program, [3]string{"  ", "jns", label})
+			asm.program = append(asm.program, [3]string{"  ", "addsd", "xmm0, " + dataOperand("twoPow64", asm)})
+			asm.program = append(asm.program, [3]string{"", label + ":", ""})
+		}
+		pushRegister("xmm0", asm)
+	case c.cType == TYPE_BOOL:
+		popRegister("rax", asm)
+		asm.program = append(asm.program, [3]string{"  ", "cmp", "rax, 0"})
+		setFromFlags("jne", "rax", asm)
+		pushRegister("rax", asm)
+	}
+}
+
 func (u UnaryOp) generateCode(asm *ASM, s *SymbolTable) {
 
 	u.expr.generateCode(asm, s)
@@ -163,34 +451,195 @@ func (u UnaryOp) generateCode(asm *ASM, s *SymbolTable) {
 	switch u.getExpressionType() {
 	case TYPE_BOOL:
 		if u.operator == OP_NOT {
-			// 'not' switches between 0 and -1. So False: 0, True: -1
-			asm.program = append(asm.program, [3]string{"  ", "pop", register})
-			asm.program = append(asm.program, [3]string{"  ", "not", register})
+			// Switches between 0 (false) and 1 (true)
+			popRegister(register, asm)
+			asm.program = append(asm.program, [3]string{"  ", "xor", fmt.Sprintf("%v, 1", register)})
 		} else {
 			panic(fmt.Sprintf("Code generation error. Unexpected unary type: %v for %v\n", u.operator, u.opType))
 		}
 	case TYPE_INT:
 		if u.operator == OP_NEGATIVE {
-			asm.program = append(asm.program, [3]string{"  ", "pop", register})
+			popRegister(register, asm)
 			asm.program = append(asm.program, [3]string{"  ", "neg", register})
+			overflowCheck(u.operator, u.line, u.column, asm)
 
+		} else if u.operator == OP_BITNOT {
+			popRegister(register, asm)
+			asm.program = append(asm.program, [3]string{"  ", "not", register})
+		} else {
+			panic(fmt.Sprintf("Code generation error. Unexpected unary type: %v for %v\n", u.operator, u.opType))
+		}
+	case TYPE_UINT:
+		if u.operator == OP_BITNOT {
+			popRegister(register, asm)
+			asm.program = append(asm.program, [3]string{"  ", "not", register})
 		} else {
 			panic(fmt.Sprintf("Code generation error. Unexpected unary type: %v for %v\n", u.operator, u.opType))
 		}
 	case TYPE_FLOAT:
 		if u.operator == OP_NEGATIVE {
-			asm.program = append(asm.program, [3]string{"  ", "pop", register})
-			asm.program = append(asm.program, [3]string{"  ", "mulsd", fmt.Sprintf("%v, qword [negOneF]", register)})
+			popRegister(register, asm)
+			// Flips the sign bit. This is exact for 0.0 (-> -0.0), Inf and NaN as well.
+			asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 0x8000000000000000"})
+			asm.program = append(asm.program, [3]string{"  ", "movq", "xmm1, rax"})
+			asm.program = append(asm.program, [3]string{"  ", "xorpd", fmt.Sprintf("%v, xmm1", register)})
 
 		} else {
 			panic(fmt.Sprintf("Code generation error. Unexpected unary type: %v for %v", u.operator, u.opType))
 		}
 	case TYPE_STRING:
 		panic("Code generation error. No unary expression for Type String")
+	}
+
+	pushRegister(register, asm)
+}
+
+// generateCode pushes the result of the builtin. Functions without result push nothing.
+// Index reads the byte at the index of the string. Strings know their length, so the index is checked.
+// Comparing unsigned catches negative indices as well.
+func (i Index) generateCode(asm *ASM, s *SymbolTable) {
+
+	i.expr.generateCode(asm, s)
+	i.index.generateCode(asm, s)
+
+	popRegister("rcx", asm)
+	popRegister("rsi", asm)
+	asm.program = append(asm.program, [3]string{"  ", "cmp", "rcx, qword [rsi-8]"})
+	trap("jae", fmt.Sprintf("[%v:%v] - Index out of range", i.line, i.column), asm)
+	asm.program = append(asm.program, [3]string{"  ", "movzx", "rsi, byte [rsi+rcx]"})
+	pushRegister("rsi", asm)
+}
+
+// minMax keeps the smaller (min) or larger (max) of the two arguments without a branch
+func minMax(f FunctionCall, asm *ASM, s *SymbolTable) {
+
+	f.args[0].generateCode(asm, s)
+	f.args[1].generateCode(asm, s)
+
+	rLeft, rRight := getRegister(f.fType)
+	popRegister(rRight, asm)
+	popRegister(rLeft, asm)
+
+	switch f.fType {
+	case TYPE_FLOAT:
+		command := "minsd"
+		if f.name == "max" {
+			command = "maxsd"
+		}
+		asm.program = append(asm.program, [3]string{"  ", command, fmt.Sprintf("%v, %v", rLeft, rRight)})
+	case TYPE_INT, TYPE_UINT:
+		// Takes the right one, if the left one is greater (min) or less (max)
+		op := Operator(OP_GREATER)
+		if f.name == "max" {
+			op = OP_LESS
+		}
+		jump := getJumpType(op)
+		if f.fType == TYPE_UINT {
+			jump = getJumpTypeUnsigned(op)
+		}
+		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, %v", rLeft, rRight)})
+		asm.program = append(asm.program, [3]string{"  ", "cmov" + strings.TrimPrefix(jump, "j"), fmt.Sprintf("%v, %v", rLeft, rRight)})
+	default:
+		panic(fmt.Sprintf("Code generation error. Unexpected type for %v: %v", f.name, f.fType))
+	}
+	pushRegister(rLeft, asm)
+}
+
+func (f FunctionCall) generateCode(asm *ASM, s *SymbolTable) {
+
+	if f.name == "min" || f.name == "max" {
+		minMax(f, asm, s)
 		return
 	}
 
-	asm.program = append(asm.program, [3]string{"  ", "push", register})
+	arg := f.args[0]
+	if f.name == "print" && arg.getExpressionType() == TYPE_STRING {
+		// Before the argument, as the call does not keep rsi
+		flushOutput("  ", asm)
+	}
+	arg.generateCode(asm, s)
+	asm.program = append(asm.program, [3]string{"  ", "pop", "rsi"})
+
+	switch f.name {
+	case "print":
+		if arg.getExpressionType() == TYPE_STRING {
+			writeString("  ", asm)
+			return
+		}
+		format := printFormat(arg.getExpressionType())
+		// Calls only happen on statement level, where the stack is 16 byte aligned
+		loadAddress("  ", "rdi", format, asm)
+		asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 0"})
+		callFunction("  ", "printf", asm)
+	case "exit":
+		// Through libc, so the buffered output of printf is flushed
+		asm.program = append(asm.program, [3]string{"  ", "mov", "rdi, rsi"})
+		callFunction("  ", "exit", asm)
+	case "len":
+		// The length is stored right before the string
+		asm.program = append(asm.program, [3]string{"  ", "push", "qword [rsi-8]"})
+	case "assert":
+		asm.program = append(asm.program, [3]string{"  ", "test", "rsi, rsi"})
+		trap("jz", fmt.Sprintf("[%v:%v] - Assertion failed", f.line, f.column), asm)
+	default:
+		panic(fmt.Sprintf("Code generation error. Unknown function: %v", f.name))
+	}
+}
+
+// setFromFlags sets rLeft to 1, if the jump is taken for the current flags. Otherwise to 0.
+func setFromFlags(jump, rLeft string, asm *ASM) {
+	labelTrue := asm.nextLabelName()
+	labelOK := asm.nextLabelName()
+
+	asm.program = append(asm.program, [3]string{"  ", jump, labelTrue})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, 0", rLeft)})
+	asm.program = append(asm.program, [3]string{"  ", "jmp", labelOK})
+	asm.program = append(asm.program, [3]string{"", labelTrue + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, 1", rLeft)})
+	asm.program = append(asm.program, [3]string{"", labelOK + ":", ""})
+}
+
+// floatComparison compares two floats with 'ucomisd' and writes the result (0/1) into rResult.
+// NaN is unordered: Every comparison with NaN is false, except '!=', which is true (IEEE 754).
+func floatComparison(op Operator, rLeft, rRight, rResult string, asm *ASM) {
+	// An unordered result sets CF, ZF and PF. 'above' and 'above or equal' need CF=0, so they are false for NaN
+	// without further checks. '<' and '<=' are calculated as '>' and '>=' with swapped operands.
+	setcc := ""
+	switch op {
+	case OP_GREATER:
+		setcc = "seta"
+	case OP_GE:
+		setcc = "setae"
+	case OP_LESS:
+		setcc = "seta"
+		rLeft, rRight = rRight, rLeft
+	case OP_LE:
+		setcc = "setae"
+		rLeft, rRight = rRight, rLeft
+	case OP_EQ:
+		setcc = "sete"
+	case OP_NE:
+		setcc = "setne"
+	default:
+		panic("Code generation error. Unknown comparison for Float")
+	}
+
+	asm.program = append(asm.program, [3]string{"  ", "ucomisd", fmt.Sprintf("%v, %v", rLeft, rRight)})
+
+	// ZF is set for unordered results as well. So '==' and '!=' check the parity flag first, which is only set for NaN.
+	labelDone := asm.nextLabelName()
+	unordered := "0"
+	if op == OP_NE {
+		unordered = "1"
+	}
+	// 'mov' keeps the flags
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, %v", rResult, unordered)})
+	if op == OP_EQ || op == OP_NE {
+		asm.program = append(asm.program, [3]string{"  ", "jp", labelDone})
+	}
+	asm.program = append(asm.program, [3]string{"  ", setcc, "al"})
+	asm.program = append(asm.program, [3]string{"  ", "movzx", fmt.Sprintf("%v, al", rResult)})
+	asm.program = append(asm.program, [3]string{"", labelDone + ":", ""})
 }
 
 // binaryOperationFloat executes the operation on the two registers and writes the result into rLeft!
@@ -200,17 +649,32 @@ func binaryOperationNumber(op Operator, t Type, rLeft, rRight string, asm *ASM)
 	case OP_GE, OP_GREATER, OP_LESS, OP_LE, OP_EQ, OP_NE:
 
 		// Works for anything that should be compared.
-		labelTrue := asm.nextLabelName()
-		labelOK := asm.nextLabelName()
+		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, %v", rLeft, rRight)})
 		jump := getJumpType(op)
+		if t == TYPE_UINT {
+			jump = getJumpTypeUnsigned(op)
+		}
+		setFromFlags(jump, rLeft, asm)
 
-		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, %v", rLeft, rRight)})
-		asm.program = append(asm.program, [3]string{"  ", jump, labelTrue})
-		asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, 0", rLeft)})
-		asm.program = append(asm.program, [3]string{"  ", "jmp", labelOK})
-		asm.program = append(asm.program, [3]string{"", labelTrue + ":", ""})
-		asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, -1", rLeft)})
-		asm.program = append(asm.program, [3]string{"", labelOK + ":", ""})
+	case OP_POW:
+		if t == TYPE_FLOAT {
+			floatFunction("pow", asm)
+			return
+		}
+		integerPower(t, rLeft, rRight, asm)
+
+	case OP_DIV, OP_MOD:
+		if t == TYPE_INT || t == TYPE_UINT {
+			integerDivision(op, t, rLeft, rRight, asm)
+			return
+		}
+		// There is no instruction for the float remainder. fmod truncates toward zero like idiv.
+		if op == OP_MOD {
+			floatFunction("fmod", asm)
+			return
+		}
+		command := getCommand(t, op)
+		asm.program = append(asm.program, [3]string{"  ", command, fmt.Sprintf("%v, %v", rLeft, rRight)})
 
 	default:
 		// Works for Integer and Float.
@@ -219,19 +683,286 @@ func binaryOperationNumber(op Operator, t Type, rLeft, rRight string, asm *ASM)
 	}
 }
 
+// stringComparison compares two strings byte by byte. rLeft and rRight hold their addresses and rLeft gets the
+// result (0/1). Strings may contain NUL, so only their stored lengths at [reg-8] tell where they end. Bytes are
+// compared unsigned and a shorter prefix is less, just like the constant folding does.
+func stringComparison(op Operator, rLeft, rRight string, asm *ASM) {
+	labelLoop := asm.nextLabelName()
+	labelLength := asm.nextLabelName()
+	labelDone := asm.nextLabelName()
+
+	// rdx and rdi hold the lengths, r8 counts down the bytes both strings have
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("rdx, qword [%v-8]", rLeft)})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("rdi, qword [%v-8]", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "r8, rdx"})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", "r8, rdi"})
+	asm.program = append(asm.program, [3]string{"  ", "cmova", "r8, rdi"})
+
+	asm.program = append(asm.program, [3]string{"", labelLoop + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", "r8, 0"})
+	asm.program = append(asm.program, [3]string{"  ", "je", labelLength})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("al, byte [%v]", rLeft)})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("al, byte [%v]", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "jne", labelDone})
+	asm.program = append(asm.program, [3]string{"  ", "inc", rLeft})
+	asm.program = append(asm.program, [3]string{"  ", "inc", rRight})
+	asm.program = append(asm.program, [3]string{"  ", "dec", "r8"})
+	asm.program = append(asm.program, [3]string{"  ", "jmp", labelLoop})
+	// One string is a prefix of the other. The lengths decide.
+	asm.program = append(asm.program, [3]string{"", labelLength + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", "rdx, rdi"})
+	asm.program = append(asm.program, [3]string{"", labelDone + ":", ""})
+
+	setFromFlags(getJumpTypeUnsigned(op), rLeft, asm)
+}
+
+// integerDivision divides rLeft by rRight with 'idiv' and writes the quotient ('/') or remainder ('%') into rLeft.
+// idiv truncates toward zero, so the remainder has the sign of the left operand: -7 / 2 = -3, -7 % 2 = -1.
+// This must not be replaced by a simple 'sar' for powers of two, which rounds toward negative infinity!
+// Only numbers, that can not be negative, are shifted instead (see powerOfTwoShift).
+// Unsigned numbers use 'div' instead.
+func integerDivision(op Operator, t Type, rLeft, rRight string, asm *ASM) {
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("rax, %v", rLeft)})
+	if t == TYPE_UINT {
+		// Zero extend rax into rdx:rax
+		asm.program = append(asm.program, [3]string{"  ", "mov", "rdx, 0"})
+		asm.program = append(asm.program, [3]string{"  ", "div", rRight})
+	} else {
+		// Sign extend rax into rdx:rax
+		asm.program = append(asm.program, [3]string{"  ", "cqo", ""})
+		asm.program = append(asm.program, [3]string{"  ", "idiv", rRight})
+	}
+
+	result := "rax"
+	if op == OP_MOD {
+		result = "rdx"
+	}
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, %v", rLeft, result)})
+}
+
+// nonNegative is true, if an int expression can never be negative. Additions and multiplications might overflow,
+// so only a few cases are known for sure.
+func nonNegative(e Expression) bool {
+	switch e := e.(type) {
+	case Constant:
+		return !strings.HasPrefix(e.cValue, "-")
+	case FunctionCall:
+		return e.name == "len"
+	case Index:
+		return true
+	case BinaryOp:
+		switch e.operator {
+		case OP_DIV:
+			return nonNegative(e.leftExpr) && nonNegative(e.rightExpr)
+		case OP_MOD:
+			// The remainder has the sign of the left operand
+			return nonNegative(e.leftExpr)
+		}
+	}
+	return false
+}
+
+// powerOfTwoShift returns k, if b divides by the constant 2^k and the left side is unsigned or can not be negative.
+// Only then '/' and '%' are the same as a shift and a mask.
+func powerOfTwoShift(b BinaryOp) (int, bool) {
+	if b.operator != OP_DIV && b.operator != OP_MOD {
+		return 0, false
+	}
+	c, ok := b.rightExpr.(Constant)
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSuffix(c.cValue, "u"), 10, 64)
+	if err != nil || value == 0 || value&(value-1) != 0 {
+		return 0, false
+	}
+	shift := bits.TrailingZeros64(value)
+	// The mask for '%' must fit into a sign extended 32 bit immediate
+	if b.operator == OP_MOD && shift > 31 {
+		return 0, false
+	}
+	if b.opType != TYPE_UINT && !nonNegative(b.leftExpr) {
+		return 0, false
+	}
+	return shift, true
+}
+
+// shiftDivision divides rLeft by 2^shift with a shift ('/') or a mask ('%'). See powerOfTwoShift.
+func shiftDivision(op Operator, t Type, shift int, rLeft string, asm *ASM) {
+	if op == OP_MOD {
+		asm.program = append(asm.program, [3]string{"  ", "and", fmt.Sprintf("%v, %v", rLeft, uint64(1)<<shift-1)})
+		return
+	}
+	command := "sar"
+	if t == TYPE_UINT {
+		command = "shr"
+	}
+	asm.program = append(asm.program, [3]string{"  ", command, fmt.Sprintf("%v, %v", rLeft, shift)})
+}
+
+// powerCheck traps on '0 ** -n', which divides by zero. The constant folding rejects it as well.
+func powerCheck(rLeft, rRight string, line, column int, asm *ASM) {
+	labelOK := asm.nextLabelName()
+	asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 0", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "jge", labelOK})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 0", rLeft)})
+	trap("je", fmt.Sprintf("[%v:%v] - Division by zero in '**'", line, column), asm)
+	asm.program = append(asm.program, [3]string{"", labelOK + ":", ""})
+}
+
+// integerPower calculates rLeft ** rRight by square and multiply and writes the result into rLeft. Overflows wrap around.
+// A negative exponent truncates like a division: the result is 0, except for the bases 1 and -1. A base of 0 is caught
+// by powerCheck before.
+func integerPower(t Type, rLeft, rRight string, asm *ASM) {
+	labelLoop := asm.nextLabelName()
+	labelEven := asm.nextLabelName()
+	labelCheck := asm.nextLabelName()
+	labelDone := asm.nextLabelName()
+
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 1"})
+	if t == TYPE_INT {
+		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 0", rRight)})
+		asm.program = append(asm.program, [3]string{"  ", "jge", labelCheck})
+		asm.program = append(asm.program, [3]string{"  ", "neg", rRight})
+		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 1", rLeft)})
+		asm.program = append(asm.program, [3]string{"  ", "je", labelCheck})
+		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, -1", rLeft)})
+		asm.program = append(asm.program, [3]string{"  ", "je", labelCheck})
+		asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 0"})
+		asm.program = append(asm.program, [3]string{"  ", "jmp", labelDone})
+	}
+	asm.program = append(asm.program, [3]string{"", labelCheck + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 0", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "je", labelDone})
+	asm.program = append(asm.program, [3]string{"", labelLoop + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "test", fmt.Sprintf("%v, 1", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "jz", labelEven})
+	asm.program = append(asm.program, [3]string{"  ", "imul", fmt.Sprintf("rax, %v", rLeft)})
+	asm.program = append(asm.program, [3]string{"", labelEven + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "imul", fmt.Sprintf("%v, %v", rLeft, rLeft)})
+	asm.program = append(asm.program, [3]string{"  ", "shr", fmt.Sprintf("%v, 1", rRight)})
+	asm.program = append(asm.program, [3]string{"  ", "jnz", labelLoop})
+	asm.program = append(asm.program, [3]string{"", labelDone + ":", ""})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("%v, rax", rLeft)})
+}
+
+// floatFunction calls a function from libm ('pow', 'fmod') with the operands in xmm0 and xmm1. The result is in xmm0
+// again. Calls inside an expression can happen with any number of values on the stack. So the stack is aligned for the call.
+func floatFunction(name string, asm *ASM) {
+	// rbx is kept by the called function
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rbx, rsp"})
+	asm.program = append(asm.program, [3]string{"  ", "and", "rsp, -16"})
+	callFunction("  ", name, asm)
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rsp, rbx"})
+}
+
+// expressionKey identifies equal expressions. Parentheses don't matter.
+func expressionKey(e Expression) string {
+	switch e := e.(type) {
+	case BinaryOp:
+		return fmt.Sprintf("(%v %v %v)", expressionKey(e.leftExpr), e.operator, expressionKey(e.rightExpr))
+	case UnaryOp:
+		return fmt.Sprintf("%v(%v)", e.operator, expressionKey(e.expr))
+	case FunctionCall:
+		args := make([]string, 0, len(e.args))
+		for _, a := range e.args {
+			args = append(args, expressionKey(a))
+		}
+		return fmt.Sprintf("%v(%v)", e.name, strings.Join(args, ", "))
+	case Index:
+		return fmt.Sprintf("%v[%v]", expressionKey(e.expr), expressionKey(e.index))
+	case Conversion:
+		return fmt.Sprintf("%v(%v)", e.cType, expressionKey(e.expr))
+	}
+	return fmt.Sprintf("%v", e)
+}
+
+// countSubexpressions counts the binary operations of an expression tree by their key. A repeated operation is not
+// looked into again, as its operands are never computed a second time.
+func countSubexpressions(e Expression, count map[string]int) {
+	switch e := e.(type) {
+	case BinaryOp:
+		key := expressionKey(e)
+		count[key]++
+		if count[key] == 1 {
+			countSubexpressions(e.leftExpr, count)
+			countSubexpressions(e.rightExpr, count)
+		}
+	case UnaryOp:
+		countSubexpressions(e.expr, count)
+	case Index:
+		countSubexpressions(e.expr, count)
+		countSubexpressions(e.index, count)
+	case Conversion:
+		countSubexpressions(e.expr, count)
+	case FunctionCall:
+		for _, a := range e.args {
+			countSubexpressions(a, count)
+		}
+	}
+}
+
+// generateExpression generates an expression on statement level. Binary operations, that occur more than once in it
+// (like a * b in 'a * b + a * b'), are only computed the first time and kept in a stack slot for the others.
+// Expressions have no side effects (print has no value), so this never changes the result.
+func generateExpression(e Expression, asm *ASM, s *SymbolTable) {
+	count := make(map[string]int)
+	countSubexpressions(e, count)
+
+	asm.common = make(map[string]string)
+	for key, n := range count {
+		if n > 1 {
+			asm.common[key] = ""
+		}
+	}
+
+	e.generateCode(asm, s)
+	asm.common = nil
+}
+
 func (b BinaryOp) generateCode(asm *ASM, s *SymbolTable) {
 
+	// Common subexpressions are only computed once (see generateExpression)
+	key := ""
+	if len(asm.common) > 0 {
+		key = expressionKey(b)
+		if slot, ok := asm.common[key]; ok && slot != "" {
+			asm.program = append(asm.program, [3]string{"  ", "push", fmt.Sprintf("qword [%v]", slot)})
+			return
+		}
+	}
+
 	b.leftExpr.generateCode(asm, s)
 	b.rightExpr.generateCode(asm, s)
 
 	rLeft, rRight := getRegister(b.leftExpr.getExpressionType())
+	// The result of a comparison is a bool, even for float operands
+	rResult, _ := getRegister(b.opType)
 
-	asm.program = append(asm.program, [3]string{"  ", "pop", rRight})
-	asm.program = append(asm.program, [3]string{"  ", "pop", rLeft})
+	popRegister(rRight, asm)
+	popRegister(rLeft, asm)
 
 	switch b.leftExpr.getExpressionType() {
-	case TYPE_INT, TYPE_FLOAT:
-		binaryOperationNumber(b.operator, b.opType, rLeft, rRight, asm)
+	case TYPE_FLOAT:
+		if isComparison(b.operator) {
+			floatComparison(b.operator, rLeft, rRight, rResult, asm)
+		} else {
+			binaryOperationNumber(b.operator, b.opType, rLeft, rRight, asm)
+		}
+	case TYPE_INT, TYPE_UINT:
+		if shift, ok := powerOfTwoShift(b); ok {
+			shiftDivision(b.operator, b.opType, shift, rLeft, asm)
+			break
+		}
+		if b.opType == TYPE_INT && b.operator == OP_POW {
+			powerCheck(rLeft, rRight, b.line, b.column, asm)
+		}
+		// The operand type decides about signed or unsigned comparisons
+		binaryOperationNumber(b.operator, b.leftExpr.getExpressionType(), rLeft, rRight, asm)
+		// Unsigned integers always wrap around
+		if b.opType == TYPE_INT && (b.operator == OP_PLUS || b.operator == OP_MINUS || b.operator == OP_MULT) {
+			overflowCheck(b.operator, b.line, b.column, asm)
+		}
 	case TYPE_BOOL:
 		// Equal and unequal are identical for bool or int, as a bool is an integer type.
 		if b.operator == OP_EQ || b.operator == OP_NE {
@@ -242,57 +973,151 @@ func (b BinaryOp) generateCode(asm *ASM, s *SymbolTable) {
 		}
 
 	case TYPE_STRING:
-		panic("Code generation error: Strings not supported yet.")
+		stringComparison(b.operator, rLeft, rRight, asm)
 	default:
 		panic(fmt.Sprintf("Code generation error: Unknown operation type %v\n", int(b.opType)))
 	}
 
-	asm.program = append(asm.program, [3]string{"  ", "push", rLeft})
+	if _, ok := asm.common[key]; ok {
+		slot := asm.nextVariableName()
+		command := "mov"
+		if b.opType == TYPE_FLOAT {
+			command = "movsd"
+		}
+		asm.program = append(asm.program, [3]string{"  ", command, fmt.Sprintf("qword [%v], %v", slot, rResult)})
+		asm.common[key] = slot
+	}
+
+	pushRegister(rResult, asm)
 }
 
-func debugPrint(asm *ASM, vName string) {
-	asm.program = append(asm.program, [3]string{"    ", "mov", fmt.Sprintf("rsi, qword [%v]", vName)})
-	asm.program = append(asm.program, [3]string{"    ", "mov", "rdi, fmti"})
-	asm.program = append(asm.program, [3]string{"    ", "mov", "rax, 0"})
-	asm.program = append(asm.program, [3]string{"    ", "call", "printf"})
+// printFormat returns the printf format for ints, uints and bools. Strings are written by writeString.
+func printFormat(t Type) string {
+	switch t {
+	case TYPE_UINT:
+		return "fmtu"
+	}
+	return "fmti"
 }
 
-func (a Assignment) generateCode(asm *ASM, s *SymbolTable) {
+// flushOutput flushes the buffered output of printf, so it comes before anything written directly
+func flushOutput(indent string, asm *ASM) {
+	asm.program = append(asm.program, [3]string{indent, "mov", "rdi, 0"})
+	callFunction(indent, "fflush", asm)
+}
 
-	for i, v := range a.variables {
-		e := a.expressions[i]
+// writeString writes the string at the address in rsi and a newline to stdout with the write syscall.
+// Unlike printf, the length is explicit. So '%' and null bytes are written as they are.
+// The output of printf has to be flushed before (see flushOutput).
+func writeString(indent string, asm *ASM) {
+	asm.program = append(asm.program, [3]string{indent, "mov", "rdx, qword [rsi-8]"})
+	asm.program = append(asm.program, [3]string{indent, "mov", "rdi, 1"})
+	asm.program = append(asm.program, [3]string{indent, "mov", "rax, 1"})
+	asm.program = append(asm.program, [3]string{indent, "syscall", ""})
+	loadAddress(indent, "rsi", "newline", asm)
+	asm.program = append(asm.program, [3]string{indent, "mov", "rdx, 1"})
+	asm.program = append(asm.program, [3]string{indent, "mov", "rdi, 1"})
+	asm.program = append(asm.program, [3]string{indent, "mov", "rax, 1"})
+	asm.program = append(asm.program, [3]string{indent, "syscall", ""})
+}
 
-		// Calculate expression
-		e.generateCode(asm, s)
+func debugPrint(asm *ASM, vName string, t Type) {
+	if t == TYPE_STRING {
+		flushOutput("    ", asm)
+		asm.program = append(asm.program, [3]string{"    ", "mov", fmt.Sprintf("rsi, qword [%v]", vName)})
+		writeString("    ", asm)
+		return
+	}
+	if t == TYPE_FLOAT {
+		// Variadic functions get floats in xmm registers. rax holds their number.
+		asm.program = append(asm.program, [3]string{"    ", "movsd", fmt.Sprintf("xmm0, qword [%v]", vName)})
+		loadAddress("    ", "rdi", "fmtf", asm)
+		asm.program = append(asm.program, [3]string{"    ", "mov", "rax, 1"})
+		callFunction("    ", "printf", asm)
+		return
+	}
+	format := printFormat(t)
+	asm.program = append(asm.program, [3]string{"    ", "mov", fmt.Sprintf("rsi, qword [%v]", vName)})
+	loadAddress("    ", "rdi", format, asm)
+	asm.program = append(asm.program, [3]string{"    ", "mov", "rax, 0"})
+	callFunction("    ", "printf", asm)
+}
 
-		register, _ := getRegister(e.getExpressionType())
+func (a Assignment) generateCode(asm *ASM, s *SymbolTable) {
 
-		asm.program = append(asm.program, [3]string{"  ", "pop", register})
+	// All values are calculated before the first variable is assigned. So 'a, b = b, a' swaps.
+	for _, e := range a.expressions {
+		generateExpression(e, asm, s)
+	}
 
+	vNames := make([]string, len(a.variables))
+	for i, v := range a.variables {
+		// '_' has no slot. Its value is popped and dropped.
+		if v.vName == blankIdentifier {
+			continue
+		}
 		// Create corresponding variable, if it doesn't exist yet.
 		if entry, ok := s.get(v.vName); !ok || entry.varName == "" {
-
-			vName := asm.nextVariableName()
-			// Variables are initialized with 0. This will be overwritten a few lines later!
-			// TODO: What about float or string variables? Does this really work? Do we care at all because it will be overwritten anyway?
-			asm.variables = append(asm.variables, [3]string{vName, "dq", "0"})
-			s.setAsmName(v.vName, vName)
+			s.setAsmName(v.vName, asm.nextVariableName())
 		}
 		// This can not/should not fail!
 		entry, _ := s.get(v.vName)
-		vName := entry.varName
+		vNames[i] = entry.varName
+	}
+
+	// The value is only moved. So a general purpose register works for all types.
+	register, _ := getRegister(TYPE_INT)
 
+	// The last value is on top of the stack
+	for i := len(vNames) - 1; i >= 0; i-- {
+		asm.program = append(asm.program, [3]string{"  ", "pop", register})
+		if vNames[i] == "" {
+			continue
+		}
 		// Move value from register of expression into variable!
-		asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("qword [%v], %v", vName, register)})
+		asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("qword [%v], %v", vNames[i], register)})
+	}
 
-		debugPrint(asm, vName)
+	for i, vName := range vNames {
+		if vName != "" {
+			debugPrint(asm, vName, a.expressions[i].getExpressionType())
+		}
 	}
+}
+
+// The fields of a struct get consecutive slots in the stack frame and start with their zero value
+func (d StructDeclaration) generateCode(asm *ASM, s *SymbolTable) {
 
+	// Slots are handed out downwards, so the last one is the start of the struct
+	base := asm.reserveSlots(len(d.fields))
+	s.setAsmName(d.variable.vName, base)
+
+	register, _ := getRegister(TYPE_INT)
+	for _, f := range d.fields {
+		zeroConstant(f.fType, f.line, f.column).generateCode(asm, s)
+		asm.program = append(asm.program, [3]string{"  ", "pop", register})
+		asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("qword [%v+%v], %v", base, f.offset, register)})
+	}
+}
+
+func (f FieldAssignment) generateCode(asm *ASM, s *SymbolTable) {
+
+	generateExpression(f.expression, asm, s)
+
+	address := fieldAddress(f.field, s)
+	register, _ := getRegister(TYPE_INT)
+	asm.program = append(asm.program, [3]string{"  ", "pop", register})
+	asm.program = append(asm.program, [3]string{"  ", "mov", fmt.Sprintf("qword [%v], %v", address, register)})
+
+	debugPrint(asm, address, f.field.fType)
 }
 
+// Constants are already substituted at all use sites during the semantic analysis. So there is nothing left to do.
+func (c ConstDeclaration) generateCode(asm *ASM, s *SymbolTable) {}
+
 func (c Condition) generateCode(asm *ASM, s *SymbolTable) {
 
-	c.expression.generateCode(asm, s)
+	generateExpression(c.expression, asm, s)
 
 	register, _ := getRegister(TYPE_BOOL)
 	// For now, we assume an else case. Even if it is just empty!
@@ -313,10 +1138,95 @@ func (c Condition) generateCode(asm *ASM, s *SymbolTable) {
 	asm.program = append(asm.program, [3]string{"", endLabel + ":", ""})
 }
 
+// maxUnroll is the highest number of iterations of an unrolled loop
+const maxUnroll = 8
+
+// unrollCount returns the number of iterations of a loop 'for i = a; i < b; i = i + c' with constant ints a, b and
+// c > 0. The body must not assign i and must not jump, so every iteration runs the whole body once.
+// It must not 'shadow' either: Once the first copy gave the shadowing variable its slot, the name would refer to that
+// slot in the next copy, instead of the outer variable. ok is false for any other loop and for more than maxUnroll
+// iterations.
+func (l Loop) unrollCount() (count int, ok bool) {
+	if len(l.assignment.variables) != 1 || len(l.expressions) != 1 || len(l.incrAssignment.variables) != 1 {
+		return 0, false
+	}
+	name := l.assignment.variables[0].vName
+	if l.incrAssignment.variables[0].vName != name {
+		return 0, false
+	}
+
+	start, ok := l.assignment.expressions[0].(Constant)
+	if !ok || start.cType != TYPE_INT {
+		return 0, false
+	}
+	condition, ok := l.expressions[0].(BinaryOp)
+	if !ok || condition.operator != OP_LESS {
+		return 0, false
+	}
+	end, ok := condition.rightExpr.(Constant)
+	if v, isVar := condition.leftExpr.(Variable); !isVar || v.vName != name || !ok || end.cType != TYPE_INT {
+		return 0, false
+	}
+	incr, ok := l.incrAssignment.expressions[0].(BinaryOp)
+	if !ok || incr.operator != OP_PLUS {
+		return 0, false
+	}
+	step, ok := incr.rightExpr.(Constant)
+	if v, isVar := incr.leftExpr.(Variable); !isVar || v.vName != name || !ok || step.cType != TYPE_INT || constInt(step) <= 0 {
+		return 0, false
+	}
+
+	changes := false
+	walk(l.block, func(n Node) bool {
+		switch st := n.(type) {
+		case Break, Continue, Label, Goto:
+			changes = true
+		case Assignment:
+			for _, v := range st.variables {
+				changes = changes || v.vName == name || v.vShadow
+			}
+		case ConstDeclaration:
+			changes = changes || st.variable.vName == name
+		case StructDeclaration:
+			changes = changes || st.variable.vName == name
+		}
+		return !changes
+	})
+	if changes {
+		return 0, false
+	}
+
+	a, b, c := constInt(start), constInt(end), uint64(constInt(step))
+	if a >= b {
+		return 0, true
+	}
+	// The difference is computed unsigned, so it can not overflow
+	diff := uint64(b) - uint64(a)
+	n := diff / c
+	if diff%c != 0 {
+		n++
+	}
+	if n > maxUnroll {
+		return 0, false
+	}
+	return int(n), true
+}
+
 func (l Loop) generateCode(asm *ASM, s *SymbolTable) {
 
+	// The condition is known to be true for count iterations. So it is never evaluated and there are no jumps.
+	if count, ok := l.unrollCount(); asm.optimize && ok {
+		l.assignment.generateCode(asm, &l.block.symbolTable)
+		for i := 0; i < count; i++ {
+			l.block.generateCode(asm, s)
+			l.incrAssignment.generateCode(asm, &l.block.symbolTable)
+		}
+		return
+	}
+
 	register, _ := getRegister(TYPE_BOOL)
 	startLabel := asm.nextLabelName()
+	incrLabel := asm.nextLabelName()
 	evalLabel := asm.nextLabelName()
 	endLabel := asm.nextLabelName()
 
@@ -326,7 +1236,12 @@ func (l Loop) generateCode(asm *ASM, s *SymbolTable) {
 	asm.program = append(asm.program, [3]string{"  ", "jmp", evalLabel})
 	asm.program = append(asm.program, [3]string{"", startLabel + ":", ""})
 
+	asm.loops = append(asm.loops, [2]string{incrLabel, endLabel})
 	l.block.generateCode(asm, s)
+	asm.loops = asm.loops[:len(asm.loops)-1]
+
+	// 'continue' still runs the increment assignment
+	asm.program = append(asm.program, [3]string{"", incrLabel + ":", ""})
 
 	// The increment assignment is logically moved inside the for-block
 	l.incrAssignment.generateCode(asm, &l.block.symbolTable)
@@ -335,7 +1250,7 @@ func (l Loop) generateCode(asm *ASM, s *SymbolTable) {
 
 	// If any of the expressions result in False (0), we jump to the end!
 	for _, e := range l.expressions {
-		e.generateCode(asm, &l.block.symbolTable)
+		generateExpression(e, asm, &l.block.symbolTable)
 		asm.program = append(asm.program, [3]string{"  ", "pop", register})
 		asm.program = append(asm.program, [3]string{"  ", "cmp", fmt.Sprintf("%v, 0", register)})
 		asm.program = append(asm.program, [3]string{"  ", "je", endLabel})
@@ -345,38 +1260,216 @@ func (l Loop) generateCode(asm *ASM, s *SymbolTable) {
 	asm.program = append(asm.program, [3]string{"", endLabel + ":", ""})
 }
 
+func (b Break) generateCode(asm *ASM, s *SymbolTable) {
+	asm.program = append(asm.program, [3]string{"  ", "jmp", asm.loops[len(asm.loops)-1][1]})
+}
+
+func (c Continue) generateCode(asm *ASM, s *SymbolTable) {
+	asm.program = append(asm.program, [3]string{"  ", "jmp", asm.loops[len(asm.loops)-1][0]})
+}
+
+func (e ExprStatement) generateCode(asm *ASM, s *SymbolTable) {
+	generateExpression(e.expression, asm, s)
+	// Drop the unused value
+	if e.expression.getExpressionType() != TYPE_VOID {
+		asm.program = append(asm.program, [3]string{"  ", "add", "rsp, 8"})
+	}
+}
+
+// labelAsmName makes user defined labels unique and keeps them apart from generated labels
+func labelAsmName(name string, id int) string {
+	return fmt.Sprintf("user_%v_%v", name, id)
+}
+
+func (l Label) generateCode(asm *ASM, s *SymbolTable) {
+	asm.program = append(asm.program, [3]string{"", labelAsmName(l.name, l.id) + ":", ""})
+}
+
+func (g Goto) generateCode(asm *ASM, s *SymbolTable) {
+	asm.program = append(asm.program, [3]string{"  ", "jmp", labelAsmName(g.label, g.id)})
+}
+
+// liveRanges returns the index of the last statement of the block, that uses a name. A backward goto runs the
+// statements after its label again. So a name, that is used after the label, lives until the goto.
+func liveRanges(b Block) map[string]int {
+	last := make(map[string]int)
+	labels := make(map[string]int)
+	jumps := make([][2]int, 0)
+
+	for i, statement := range b.statements {
+		if l, ok := statement.(Label); ok {
+			labels[labelAsmName(l.name, l.id)] = i
+		}
+		walk(statement, func(n Node) bool {
+			switch node := n.(type) {
+			case Variable:
+				last[node.vName] = i
+			case Goto:
+				if target, ok := labels[labelAsmName(node.label, node.id)]; ok {
+					jumps = append(jumps, [2]int{target, i})
+				}
+			}
+			return true
+		})
+	}
+
+	// A goto can extend a name into the range of another goto
+	for changed := true; changed; {
+		changed = false
+		for _, jump := range jumps {
+			for name, i := range last {
+				if i >= jump[0] && i < jump[1] {
+					last[name] = jump[1]
+					changed = true
+				}
+			}
+		}
+	}
+	return last
+}
+
+// The slot of a variable is free after the statement, that uses it last. So variables with lifetimes, that don't
+// overlap, share a slot: 'x = 1; print(x); y = 2' keeps x and y in the same slot. Slots of temporaries (common
+// subexpressions, loop variables) are free after the statement, that needs them.
 func (b Block) generateCode(asm *ASM, s *SymbolTable) {
 
-	for _, statement := range b.statements {
+	last := liveRanges(b)
+
+	// The names, that get their slot in this block. Loop variables already have one, see Loop.generateCode.
+	// An unrolled loop body is generated again. Its names still have the slots of the last copy, which are free.
+	names := make([]string, 0, len(b.symbolTable.table))
+	for name, entry := range b.symbolTable.table {
+		if entry.varName != "" && asm.slots[variableSlots(entry.varName, entry)[0]] {
+			continue
+		}
+		b.symbolTable.setAsmName(name, "")
+		names = append(names, name)
+	}
+
+	var current Statement
+	defer func() { positionPanic(recover(), current) }()
+
+	for i, statement := range b.statements {
+		current = statement
+		// Maps the following instructions back to the source. Blocks of conditions and loops span multiple lines,
+		// only their header is kept.
+		row, col := statement.startPos()
+		source := strings.SplitN(fmt.Sprintf("%v", statement), "\n", 2)[0]
+		asm.program = append(asm.program, [3]string{"  ", fmt.Sprintf("; line %v:%v: %v", row, col, source), ""})
+
+		inUse := append([]bool{}, asm.slots...)
 		statement.generateCode(asm, &b.symbolTable)
+
+		// Dead variables are freed first. One of them may have the slot of a variable, that is still live.
+		live := make([]int, 0)
+		for _, name := range names {
+			entry := b.symbolTable.table[name]
+			if entry.varName == "" {
+				continue
+			}
+			for _, slot := range variableSlots(entry.varName, entry) {
+				if last[name] > i {
+					live = append(live, slot)
+				} else if slot < len(inUse) {
+					inUse[slot] = false
+				}
+			}
+		}
+		copy(asm.slots, inUse)
+		for slot := len(inUse); slot < len(asm.slots); slot++ {
+			asm.slots[slot] = false
+		}
+		for _, slot := range live {
+			asm.slots[slot] = true
+		}
+	}
+}
+
+// debugSymbols defines the offset of every variable in the table relative to rbp as an absolute symbol (var_i equ -8).
+// A debugger can then show a variable with 'print *(long*)($rbp + var_i)'. Constants have no slot and are left out.
+// A name, that already has a symbol, is skipped. The assembler rejects a second definition.
+func debugSymbols(asm *ASM, s SymbolTable) {
+	defined := make(map[string]bool, len(asm.constants))
+	for _, c := range asm.constants {
+		defined[c[0]] = true
+	}
+
+	names := make([]string, 0, len(s.table))
+	for name, entry := range s.table {
+		if !entry.isConst && entry.varName != "" && !defined["var_"+name] {
+			names = append(names, name)
+		}
 	}
+	sort.Strings(names)
 
+	for _, name := range names {
+		offset := strings.TrimPrefix(s.table[name].varName, "rbp")
+		asm.constants = append(asm.constants, [2]string{"var_" + name, strings.TrimPrefix(offset, "+")})
+	}
+}
+
+// generate translates the analyzed AST into x86 assembly. See CodeGenerator.
+func (asm *ASM) generate(ast AST, options Options) {
+	*asm = ast.generateCode(options)
 }
 
-func (ast AST) generateCode() ASM {
+func (ast AST) generateCode(options Options) ASM {
 
-	asm := ASM{}
+	asm := ASM{trapv: options.trapv, pie: options.pie, optimize: options.optimize}
 
 	asm.header = append(asm.header, "extern printf  ; C function we need for debugging")
+	asm.header = append(asm.header, "extern fflush")
+	asm.header = append(asm.header, "extern exit")
+	asm.header = append(asm.header, "extern abort")
+	asm.header = append(asm.header, "extern pow")
+	asm.header = append(asm.header, "extern fmod")
+	// Declares a non-executable stack. Otherwise ld warns about it.
+	asm.header = append(asm.header, "section .note.GNU-stack noalloc noexec nowrite progbits")
 	asm.header = append(asm.header, "section .data")
 
-	asm.constants = append(asm.constants, [2]string{"TRUE", "-1"})
+	asm.constants = append(asm.constants, [2]string{"TRUE", "1"})
 	asm.constants = append(asm.constants, [2]string{"FALSE", "0"})
 
-	asm.variables = append(asm.variables, [3]string{"fmti", "db", "\"%i\", 10, 0"})
-	asm.variables = append(asm.variables, [3]string{"negOneF", "dq", "-1.0"})
+	asm.variables = append(asm.variables, [3]string{"fmti", "db", "\"%ld\", 10, 0"})
+	asm.variables = append(asm.variables, [3]string{"newline", "db", "10"})
+	asm.variables = append(asm.variables, [3]string{"fmtu", "db", "\"%lu\", 10, 0"})
+	asm.variables = append(asm.variables, [3]string{"fmtf", "db", "\"%f\", 10, 0"})
 	asm.variables = append(asm.variables, [3]string{"negOneI", "dq", "-1"})
+	asm.variables = append(asm.variables, [3]string{"twoPow64", "dq", "18446744073709551616.0"})
+	asm.variables = append(asm.variables, [3]string{"twoPow63", "dq", "9223372036854775808.0"})
 
 	asm.program = append(asm.program, [3]string{"", "section .text", ""})
 	asm.program = append(asm.program, [3]string{"", "global _start", ""})
 	asm.program = append(asm.program, [3]string{"", "_start:", ""})
 
+	// Prologue. The size of the stack frame is only known after all variables got their slot.
+	asm.program = append(asm.program, [3]string{"  ", "push", "rbp"})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rbp, rsp"})
+	frameIndex := len(asm.program)
+	asm.program = append(asm.program, [3]string{"  ", "sub", "rsp, 0"})
+
+	// argc is the first thing on the stack at _start, right above the saved rbp
+	if _, ok := ast.globalSymbolTable.table["argc"]; ok {
+		ast.globalSymbolTable.setAsmName("argc", "rbp+8")
+	}
+
 	ast.block.generateCode(&asm, &ast.globalSymbolTable)
+	// A top level 'shadow argc' hides the predefined one, so its symbol comes first
+	debugSymbols(&asm, ast.block.symbolTable)
+	debugSymbols(&asm, ast.globalSymbolTable)
+
+	asm.program[frameIndex][2] = fmt.Sprintf("rsp, %v", stackFrameSize(len(asm.slots)))
 
+	// Epilogue
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rsp, rbp"})
+	asm.program = append(asm.program, [3]string{"  ", "pop", "rbp"})
+
+	// Exit through libc, so the buffered output of printf is flushed
 	asm.program = append(asm.program, [3]string{"  ", "; Exit the program nicely", ""})
-	asm.program = append(asm.program, [3]string{"  ", "mov", "rbx, 0  ; normal exit code"})
-	asm.program = append(asm.program, [3]string{"  ", "mov", "rax, 1  ; process termination service (?)"})
-	asm.program = append(asm.program, [3]string{"  ", "int", "0x80    ; linux kernel service"})
+	asm.program = append(asm.program, [3]string{"  ", "mov", "rdi, 0  ; normal exit code"})
+	callFunction("  ", "exit", &asm)
+
+	trapHandlers(&asm)
 
 	return asm
 }
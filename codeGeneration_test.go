@@ -0,0 +1,1365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func generateCodeFor(code []byte, t *testing.T) ASM {
+	ast, err := analyzeCode(code)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return ast.generateCode(Options{})
+}
+
+// testExecution compiles the code into an executable, runs it and compares the output.
+// The test is skipped, if yasm is not installed.
+func testExecution(code []byte, expected string, t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	asm := generateCodeFor(code, t)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(asm, "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	out, err := exec.Command(executable).Output()
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+	if string(out) != expected {
+		t.Errorf("Expected output:\n%v\ngot:\n%v", expected, string(out))
+	}
+}
+
+// containsInstruction checks if the program contains the exact instruction
+func containsInstruction(asm ASM, command, args string) bool {
+	for _, l := range asm.program {
+		if l[1] == command && l[2] == args {
+			return true
+		}
+	}
+	return false
+}
+
+func containsConstant(asm ASM, name, value string) bool {
+	for _, c := range asm.constants {
+		if c[0] == name && c[1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVariable(asm ASM, name, kind, value string) bool {
+	for _, v := range asm.variables {
+		if v[0] == name && v[1] == kind && v[2] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCodeGenerationShadowUsesOuterVariable(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	if true {
+		shadow a = a + 1
+	}
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsInstruction(asm, "push", "qword [rbp-8]") {
+		t.Errorf("Expected right side to read the outer variable in [rbp-8]")
+	}
+	if !containsInstruction(asm, "mov", "qword [rbp-16], rsi") {
+		t.Errorf("Expected shadowing variable to be stored in [rbp-16]")
+	}
+}
+
+func TestCodeGenerationBool(t *testing.T) {
+
+	var code []byte = []byte(`
+	x = 1
+	a = (x < 2) && (3 > x)
+	b = !(x < 2)
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsConstant(asm, "TRUE", "1") || !containsConstant(asm, "FALSE", "0") {
+		t.Errorf("Expected bool constants TRUE = 1 and FALSE = 0")
+	}
+	if !containsInstruction(asm, "mov", "rsi, 1") {
+		t.Errorf("Expected comparison to produce 1 for true")
+	}
+	if !containsInstruction(asm, "and", "rsi, rcx") {
+		t.Errorf("Expected '&&' to combine the comparisons bitwise")
+	}
+	if !containsInstruction(asm, "xor", "rsi, 1") {
+		t.Errorf("Expected '!' to switch between 0 and 1")
+	}
+
+	testExecution(code, "1\n1\n0\n", t)
+}
+
+func TestCodeGenerationBreakContinue(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 10; i = i+1 {
+		if i == 1 {
+			continue
+		}
+		if i == 3 {
+			break
+		}
+		a = i
+	}
+	`)
+
+	// Every assignment prints its value. 'continue' must still run the increment.
+	testExecution(code, "0\n0\n1\n2\n2\n3\n", t)
+}
+
+// A 'continue' on every iteration must still run the increment. Otherwise the loop never ends, so it gets a deadline.
+func TestCodeGenerationContinueIncrements(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	for i = 0; i < 5; i = i + 1 {
+		if i > 0 {
+			continue
+		}
+		print(i)
+	}
+	print(10)
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, executable).Output()
+	if ctx.Err() != nil {
+		t.Fatalf("The loop did not terminate")
+	}
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+	if expected := "0\n0\n1\n2\n3\n4\n5\n10\n"; string(out) != expected {
+		t.Errorf("Expected output:\n%v\ngot:\n%v", expected, string(out))
+	}
+}
+
+// Integer division and modulo truncate toward zero. This pins the behavior of idiv for negative operands.
+func TestCodeGenerationDivModNegative(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = -7
+	b = 2
+	c = a / b
+	d = a % b
+	a = 7
+	b = -2
+	c = a / b
+	d = a % b
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsInstruction(asm, "idiv", "rcx") {
+		t.Errorf("Expected signed division with idiv")
+	}
+
+	testExecution(code, "-7\n2\n-3\n-1\n7\n-2\n-3\n1\n", t)
+}
+
+// Constant folding has to follow the same rules as the generated code
+func TestCodeGenerationDivModNegativeConstant(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = (-7) / 2
+	b = 7 / (-2)
+	c = (-7) % 2
+	d = 7 % (-2)
+	`)
+
+	testExecution(code, "-3\n-3\n-1\n1\n", t)
+}
+
+func TestCodeGenerationGotoForward(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	if a == 1 {
+		goto end
+	}
+	a = 2
+	end:
+	a = 3
+	`)
+
+	testExecution(code, "1\n3\n", t)
+}
+
+func TestCodeGenerationGotoBackward(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 0
+	again:
+	a = a + 1
+	if a < 3 {
+		goto again
+	}
+	`)
+
+	testExecution(code, "0\n1\n2\n3\n", t)
+}
+
+func TestCodeGenerationForMultipleVariables(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i, j = 0, 4; i < j; i, j = i+1, j-1 {
+		a = j - i
+	}
+	`)
+
+	// Every assignment prints its values. So init and increment print both variables.
+	testExecution(code, "0\n4\n4\n1\n3\n2\n2\n2\n", t)
+}
+
+func TestCodeGenerationStackFrame(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	b = 2
+	if a < b {
+		c = a + b
+		shadow a = c * 2
+	}
+	b = a
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	// 4 slots (a, b, c, shadow a) = 32 byte + 8 byte to align the stack after 'push rbp'
+	prologue := [][3]string{{"  ", "push", "rbp"}, {"  ", "mov", "rbp, rsp"}, {"  ", "sub", "rsp, 40"}}
+	for i, p := range prologue {
+		if asm.program[3+i] != p {
+			t.Errorf("Expected prologue instruction %v, got: %v", p, asm.program[3+i])
+		}
+	}
+	if !containsInstruction(asm, "mov", "rsp, rbp") || !containsInstruction(asm, "pop", "rbp") {
+		t.Errorf("Expected epilogue to restore rsp and rbp")
+	}
+	for _, slot := range []string{"rbp-8", "rbp-16", "rbp-24", "rbp-32"} {
+		if !containsInstruction(asm, "mov", fmt.Sprintf("qword [%v], rsi", slot)) {
+			t.Errorf("Expected a variable in [%v]", slot)
+		}
+	}
+
+	testExecution(code, "1\n2\n3\n6\n1\n", t)
+}
+
+func TestCodeGenerationStackFrameReuse(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = argc
+	if a > 0 {
+		x = a + 10
+		print(x)
+	}
+	if a > 0 {
+		y = a + 20
+		z = y + 1
+		print(z)
+	}
+	for i = 0; i < 1; i++ {
+		w = i + 30
+	}
+	b = a
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	// a, x/y/i/b and z/w: 3 slots = 24 byte, which already aligns the stack
+	if !containsInstruction(asm, "sub", "rsp, 24") {
+		t.Errorf("Expected a stack frame of 24 byte, got: %v", asm.program[3:6])
+	}
+
+	testExecution(code, "1\n11\n11\n21\n22\n22\n0\n30\n1\n1\n", t)
+}
+
+// A variable is dead after its last use. Its slot is free for the next variable in the same block.
+func TestCodeGenerationStackFrameLiveRanges(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = argc
+	b = a + 1
+	print(b)
+	c = argc * 2
+	d = c + 1
+	print(d)
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	// a/c and b/d: 2 slots = 16 byte + 8 byte to align the stack
+	if !containsInstruction(asm, "sub", "rsp, 24") {
+		t.Errorf("Expected a stack frame of 24 byte, got: %v", asm.program[3:6])
+	}
+	if !containsInstruction(asm, "mov", "qword [rbp-8], rsi") || containsInstruction(asm, "mov", "qword [rbp-24], rsi") {
+		t.Errorf("Expected c in the slot of a")
+	}
+	testExecution(code, "1\n2\n2\n2\n3\n3\n", t)
+
+	// x is used last before the goto. But the goto runs that use again, so z must not take the slot of x.
+	code = []byte(`
+	i = 0
+	x = argc
+	again:
+	y = x + i
+	i = i + 1
+	z = 5
+	if i < 2 {
+		goto again
+	}
+	`)
+
+	testExecution(code, "0\n1\n1\n1\n5\n2\n2\n5\n", t)
+}
+
+func TestCodeGenerationStackFrameSize(t *testing.T) {
+
+	for slots, size := range []int{8, 8, 24, 24, 40} {
+		if s := stackFrameSize(slots); s != size {
+			t.Errorf("Expected frame size %v for %v slots, got: %v", size, slots, s)
+		}
+	}
+}
+
+func TestCodeGenerationStringComparison(t *testing.T) {
+
+	var code []byte = []byte(`
+	name = "admin"
+	if name == "admin" {
+		a = 1
+	} else {
+		a = 0
+	}
+	if name != "admin" {
+		a = 2
+	}
+	b = name < "admins"
+	b = name >= "b"
+	b = name == ""
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsInstruction(asm, "mov", "al, byte [rsi]") || !containsInstruction(asm, "cmp", "al, byte [rcx]") {
+		t.Errorf("Expected strings to be compared byte by byte")
+	}
+
+	testExecution(code, "admin\n1\n1\n0\n0\n", t)
+}
+
+func TestCodeGenerationStringComparisonNul(t *testing.T) {
+
+	// Strings end at their length, not at the first NUL. The runtime must agree with the folded comparison.
+	var code []byte = []byte(`
+	s = "a\x00b"
+	u = "a\x00c"
+	b = s == u
+	b = s < u
+	p = "a"
+	b = p < s
+	b = s == p
+	b = "a\x00b" == "a\x00c"
+	`)
+
+	testExecution(code, "a\x00b\na\x00c\n0\n1\na\n1\n0\n0\n", t)
+}
+
+func TestCodeGenerationIncrement(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 3; i++ {
+	}
+	j = 5
+	j--
+	`)
+
+	testExecution(code, "0\n1\n2\n3\n5\n4\n", t)
+}
+
+func TestCodeGenerationGNUStackNote(t *testing.T) {
+
+	asm := generateCodeFor([]byte(`a = 1`), t)
+
+	source := assemblyText(asm)
+
+	if !strings.Contains(source, "section .note.GNU-stack noalloc noexec nowrite progbits\n") {
+		t.Errorf("Expected the assembly to declare a non-executable stack, got:\n%v", source)
+	}
+}
+
+func TestCodeGenerationStringEscapes(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = "\x41\tb\"c"
+	b = "\n"
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsVariable(asm, "const_0", "db", `"A", 9, "b", 34, "c", 0`) {
+		t.Errorf("Expected escaped characters to be written as numbers, got: %v", asm.variables)
+	}
+
+	testExecution(code, "A\tb\"c\n\n\n", t)
+}
+
+func TestCodeGenerationFunctionCall(t *testing.T) {
+
+	var code []byte = []byte(`
+	print("abc")
+	print(len("abc") * 2)
+	print(1 < 2 && true)
+	len("a")
+	`)
+
+	testExecution(code, "abc\n6\n1\n", t)
+}
+
+func TestCodeGenerationFloatComparison(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1.5
+	b = a < 2.0
+	b = a <= 1.5
+	b = a > 1.5
+	b = a >= 2.0
+	b = a == 1.5
+	b = a != 1.5
+	b = (-a) < 0.0
+	`)
+
+	testExecution(code, "1.500000\n1\n1\n0\n0\n1\n0\n1\n", t)
+}
+
+func TestCodeGenerationFloatNaN(t *testing.T) {
+
+	// 0.0 / 0.0 is not folded, as NaN has no literal. Every comparison with NaN is false, except '!='.
+	var code []byte = []byte(`
+	z = 0.0
+	b = z / z < 1.0
+	b = z / z <= 1.0
+	b = z / z > 1.0
+	b = z / z >= 1.0
+	b = z / z == z / z
+	b = z / z != z / z
+	`)
+
+	testExecution(code, "0.000000\n0\n0\n0\n0\n0\n1\n", t)
+}
+
+func TestAssembleRemovesTemporaryFiles(t *testing.T) {
+
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	// Fails in yasm (or even earlier, if yasm is not installed)
+	asm := generateCodeFor([]byte(`a = 1`), t)
+	asm.program = append(asm.program, [3]string{"  ", "not_an_instruction", ""})
+	if err := assemble(asm, "", filepath.Join(tmpDir, "executable")); err == nil {
+		t.Errorf("Expected assembling invalid code to fail")
+	}
+
+	// Fails in ld, as the executable can not be written
+	asm = generateCodeFor([]byte(`a = 1`), t)
+	if err := assemble(asm, "", filepath.Join(tmpDir, "missing", "executable")); err == nil {
+		t.Errorf("Expected linking into a missing directory to fail")
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Reading the temporary directory failed: %v", err)
+	}
+	for _, f := range files {
+		t.Errorf("Stray temporary file after failed assemble: %v", f.Name())
+	}
+}
+
+func TestCodeGenerationAssemblyText(t *testing.T) {
+
+	asm := generateCodeFor([]byte(`a = 5`), t)
+	source := assemblyText(asm)
+
+	for _, line := range []string{
+		"const_0     equ       5              \n",
+		"  push      const_0   \n",
+		"  mov       qword [rbp-8], rsi\n",
+	} {
+		if !strings.Contains(source, line) {
+			t.Errorf("Expected the assembly to contain %q, got:\n%v", line, source)
+		}
+	}
+}
+
+func TestCodeGenerationUnsignedComparison(t *testing.T) {
+
+	// The same bits compare differently for int and uint
+	var code []byte = []byte(`
+	big = 18446744073709551615u
+	small = 1u
+	b = big > small
+	i = -1
+	j = 1
+	b = i > j
+	q = big / 2u
+	`)
+
+	testExecution(code, "18446744073709551615\n1\n1\n-1\n1\n0\n9223372036854775807\n", t)
+}
+
+func TestCodeGenerationPower(t *testing.T) {
+
+	var code []byte = []byte(`
+	x = 2
+	a = x ** 10 == 1024
+	n = -1
+	b = x ** n
+	c = n ** (0 - 3)
+	y = 2.0
+	f = y ** 0.5
+	`)
+
+	testExecution(code, "2\n1\n-1\n0\n-1\n2.000000\n1.414214\n", t)
+}
+
+// The float remainder is calculated by fmod and has the sign of the dividend, just like for ints
+func TestCodeGenerationFloatModulo(t *testing.T) {
+
+	var code []byte = []byte(`
+	x = 5.5
+	y = 2.0
+	a = x % y == 1.5
+	b = -x % y
+	c = 5.5 % 2.0 == 1.5
+	`)
+
+	testExecution(code, "5.500000\n2.000000\n1\n-1.500000\n1\n", t)
+}
+
+func TestCodeGenerationSourceComments(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 2; i++ {
+		a = i
+	}
+	`)
+
+	source := assemblyText(generateCodeFor(code, t))
+
+	for _, comment := range []string{
+		"  ; line 1:1: for int(i) = int(0); int(i) < int(2); int(i) = int(i) + int(1) {",
+		"  ; line 2:2: int(a) = int(i)",
+	} {
+		if !strings.Contains(source, comment) {
+			t.Errorf("Expected the assembly to contain %q, got:\n%v", comment, source)
+		}
+	}
+}
+
+func TestCodeGenerationBoolConstants(t *testing.T) {
+
+	// The first one is folded into a single constant, the others combine the constants at run time
+	var code []byte = []byte(`
+	j = true && false
+	t = true
+	f = false
+	a = t && false
+	b = f || true
+	c = !t || !false
+	d = true == f
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsInstruction(asm, "push", "TRUE") || !containsInstruction(asm, "push", "FALSE") {
+		t.Errorf("Expected bool constants to be pushed as immediate values")
+	}
+
+	testExecution(code, "0\n1\n0\n0\n1\n1\n0\n", t)
+}
+
+func TestCodeGenerationShadowInLoopBody(t *testing.T) {
+
+	// Every iteration starts with the outer 'x' again. The shadowed 'x' of the last iteration is gone.
+	var code []byte = []byte(`
+	x = 10
+	for i = 0; i < 3; i++ {
+		shadow x = x + i
+		x = x * 2
+	}
+	y = x
+	`)
+
+	testExecution(code, "10\n0\n10\n20\n1\n11\n22\n2\n12\n24\n3\n10\n", t)
+}
+
+func TestCodeGenerationPrintStringWrite(t *testing.T) {
+
+	// printf would interpret the '%d' and stop at the null byte. The ints in between check the order of the output.
+	var code []byte = []byte(`
+	x = 1
+	print("100%d")
+	s = "a\x00b"
+	print(len(s))
+	print(s)
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsVariable(asm, "const_1_len", "dq", "5") || !containsVariable(asm, "const_1", "db", `"100%d", 0`) {
+		t.Errorf("Expected the string to be stored with its length")
+	}
+	if !containsInstruction(asm, "syscall", "") {
+		t.Errorf("Expected strings to be written with the write syscall")
+	}
+
+	testExecution(code, "1\n100%d\na\x00b\n3\na\x00b\n", t)
+}
+
+func TestCodeGenerationCommonSubexpressions(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 3
+	b = 4
+	c = a * b + a * b
+	d = (a - b) * (a - b) + ((a - b))
+	f = 1.5
+	g = f * f - f * f
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	count := make(map[string]int)
+	for _, l := range asm.program {
+		count[strings.TrimSpace(l[1]+" "+l[2])]++
+	}
+	for instruction, expected := range map[string]int{"imul rsi, rcx": 2, "sub rsi, rcx": 1, "mulsd xmm0, xmm1": 1} {
+		if count[instruction] != expected {
+			t.Errorf("Expected '%v' %v times, got: %v", instruction, expected, count[instruction])
+		}
+	}
+
+	testExecution(code, "3\n4\n24\n0\n1.500000\n0.000000\n", t)
+}
+
+func TestCodeGenerationPowerOfTwoDivision(t *testing.T) {
+
+	// The length can not be negative, x can
+	var code []byte = []byte(`
+	s = "abcdefghijklmnopq"
+	a = len(s) / 8
+	b = len(s) % 8
+	x = -17
+	c = x / 8
+	d = x % 8
+	u = 17u
+	e = u / 8u
+	f = u % 8u
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	for _, instruction := range [][2]string{{"sar", "rsi, 3"}, {"shr", "rsi, 3"}, {"and", "rsi, 7"}} {
+		if !containsInstruction(asm, instruction[0], instruction[1]) {
+			t.Errorf("Expected '%v %v' for a division by 8", instruction[0], instruction[1])
+		}
+	}
+	count := 0
+	for _, l := range asm.program {
+		if l[1] == "idiv" || l[1] == "div" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("Expected only the possibly negative x to be divided with idiv, got %v divisions", count)
+	}
+
+	testExecution(code, "abcdefghijklmnopq\n2\n1\n-17\n-2\n-1\n17\n2\n1\n", t)
+}
+
+func TestCodeGenerationElseIf(t *testing.T) {
+
+	var code []byte = []byte(`
+	for a = 1; a <= 3; a++ {
+		if a == 1 {
+			print(10)
+		} else if a == 2 {
+			print(20)
+		} else {
+			print(30)
+		}
+	}
+	`)
+
+	testExecution(code, "1\n10\n2\n20\n3\n30\n4\n", t)
+}
+
+func TestCodeGenerationArgcExit(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	if argc > 2 {
+		exit(10)
+	}
+	exit(argc)
+	print(1)
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	for expected, args := range map[int][]string{1: {}, 2: {"a"}, 10: {"a", "b"}} {
+		err := exec.Command(executable, args...).Run()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok || exitErr.ExitCode() != expected {
+			t.Errorf("Expected exit code %v for the arguments %v, got: %v", expected, args, err)
+		}
+	}
+}
+
+func TestCodeGenerationSwap(t *testing.T) {
+
+	var code []byte = []byte(`
+	a, b = 1, 2
+	a, b = b, a
+	c, d, e = "c", 4.5, a
+	c, d, e = c, 1.5 * d, a + b
+	`)
+
+	testExecution(code, "1\n2\n2\n1\nc\n4.500000\n2\nc\n6.750000\n3\n", t)
+}
+
+func TestCodeGenerationTrapv(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	a = 9223372036854775807
+	b = a + 1
+	c = 2u - 3u
+	`)
+
+	for trapv, expected := range map[bool]string{
+		false: "9223372036854775807\n-9223372036854775808\n18446744073709551615\n",
+		true:  "9223372036854775807\n",
+	} {
+		asm, _, err := compile(code, Options{trapv: trapv})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		executable := filepath.Join(t.TempDir(), "executable")
+		if err := assemble(asm, "", executable); err != nil {
+			t.Fatalf("Assembling failed: %v", err)
+		}
+
+		var stderr strings.Builder
+		cmd := exec.Command(executable)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if string(out) != expected {
+			t.Errorf("Expected output with trapv=%v:\n%v\ngot:\n%v", trapv, expected, string(out))
+		}
+
+		if !trapv {
+			if err != nil {
+				t.Errorf("Execution failed: %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected the program to abort on the overflow")
+		}
+		if message := "[2:5] - Integer overflow in '+'\n"; stderr.String() != message {
+			t.Errorf("Expected the message %q on stderr, got %q", message, stderr.String())
+		}
+	}
+}
+
+func TestCodeGenerationComparisonAssignment(t *testing.T) {
+
+	// The first one is folded, the second one is compared at run time
+	var code []byte = []byte(`
+	a = 3 < 5
+	x = 3
+	b = x < 5
+	c = x > 5
+	`)
+
+	testExecution(code, "1\n3\n1\n0\n", t)
+}
+
+func TestCodeGenerationEmptyProgram(t *testing.T) {
+
+	// An empty program still needs a valid _start, that exits with 0
+	for _, code := range []string{"", "// nothing\n// to do", "if argc > 1 {}\nfor ; false; {}"} {
+		testExecution([]byte(code), "", t)
+	}
+}
+
+func TestCodeGenerationExitThroughLibc(t *testing.T) {
+
+	// exit of libc flushes the output of printf. A raw exit syscall would lose it, when stdout is a pipe.
+	var code []byte = []byte(`
+	a = 1
+	print(a + 1)
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	if !containsInstruction(asm, "call", "exit") {
+		t.Errorf("Expected the program to end with a call of libc 'exit'")
+	}
+
+	testExecution(code, "1\n2\n", t)
+}
+
+func TestCodeGenerationExitCode(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	// The output before is flushed, the rest of the program is not executed
+	var code []byte = []byte(`
+	print("bye")
+	exit(40 + 2)
+	print(1)
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	out, err := exec.Command(executable).Output()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 42 {
+		t.Errorf("Expected exit code 42, got: %v", err)
+	}
+	if string(out) != "bye\n" {
+		t.Errorf("Expected output 'bye', got: %q", string(out))
+	}
+}
+
+func TestCodeGenerationIndex(t *testing.T) {
+
+	var code []byte = []byte(`
+	s = "hello"
+	i = 1
+	a = s[i]
+	b = s[i + 3] - s[0]
+	`)
+
+	testExecution(code, "hello\n1\n101\n7\n", t)
+}
+
+func TestCodeGenerationIndexOutOfRange(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	s = "abc"
+	a = s[argc + 2]
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(executable)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		t.Errorf("Expected the program to abort on the index out of range")
+	}
+	if string(out) != "abc\n" {
+		t.Errorf("Expected output 'abc', got: %q", string(out))
+	}
+	if message := "[2:5] - Index out of range\n"; stderr.String() != message {
+		t.Errorf("Expected the message %q on stderr, got %q", message, stderr.String())
+	}
+}
+
+func TestCodeGenerationPowerDivisionByZero(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	// Just like the folded '0 ** -1', which is an error
+	var code []byte = []byte(`
+	z = argc - 1
+	a = 2 ** (z - 1)
+	b = z ** (z - 1)
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(executable)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		t.Errorf("Expected the program to abort on the division by zero")
+	}
+	if string(out) != "0\n0\n" {
+		t.Errorf("Expected output '0\\n0\\n', got: %q", string(out))
+	}
+	if message := "[3:5] - Division by zero in '**'\n"; stderr.String() != message {
+		t.Errorf("Expected the message %q on stderr, got %q", message, stderr.String())
+	}
+}
+
+func TestCodeGenerationFloatNegation(t *testing.T) {
+
+	// The first one is folded, the others are negated at run time
+	var code []byte = []byte(`
+	a = -(1.5) + 2.0
+	x = 1.5
+	b = -x + 2.0
+	z = 0.0
+	c = -z
+	`)
+
+	asm := generateCodeFor(code, t)
+	if !containsInstruction(asm, "xorpd", "xmm0, xmm1") {
+		t.Errorf("Expected float negation to flip the sign bit")
+	}
+
+	testExecution(code, "0.500000\n1.500000\n0.500000\n0.000000\n-0.000000\n", t)
+}
+
+func TestCodeGenerationConditionVariable(t *testing.T) {
+
+	var code []byte = []byte(`
+	flag = argc > 1
+	if flag {
+		print(1)
+	} else {
+		print(2)
+	}
+	`)
+
+	testExecution(code, "0\n2\n", t)
+}
+
+func TestCodeGenerationPIE(t *testing.T) {
+
+	var code []byte = []byte(`
+	s = "abc"
+	f = 1.5 * 2.0
+	print(len(s) + 1)
+	`)
+
+	for pie, expected := range map[bool][][2]string{
+		false: {{"push", "const_0"}, {"mov", "rdi, fmti"}, {"call", "printf"}, {"push", "qword [const_1]"}},
+		true:  {{"lea", "rax, [rel const_0]"}, {"lea", "rdi, [rel fmti]"}, {"call", "printf wrt ..plt"}, {"push", "qword [rel const_1]"}},
+	} {
+		ast, err := analyzeCode(code)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		asm := ast.generateCode(Options{pie: pie})
+		for _, instruction := range expected {
+			if !containsInstruction(asm, instruction[0], instruction[1]) {
+				t.Errorf("Expected '%v %v' with pie=%v", instruction[0], instruction[1], pie)
+			}
+		}
+
+		if _, err := exec.LookPath("yasm"); err != nil {
+			continue
+		}
+		executable := filepath.Join(t.TempDir(), "executable")
+		if err := assemble(asm, "", executable); err != nil {
+			t.Fatalf("Assembling failed: %v", err)
+		}
+		if out, err := exec.Command(executable).Output(); err != nil || string(out) != "abc\n3.000000\n4\n" {
+			t.Errorf("Unexpected output with pie=%v: %q, %v", pie, string(out), err)
+		}
+	}
+}
+
+func TestCodeGenerationMinMax(t *testing.T) {
+
+	var code []byte = []byte(`
+	i, j = -3, 2
+	a, b = min(i, j), max(i, j)
+	u, v = 1u, 18446744073709551615u
+	c, d = min(u, v), max(u, v)
+	f, g = 1.5, -2.5
+	e, h = min(f, g), max(f, g)
+	`)
+
+	asm := generateCodeFor(code, t)
+	for _, command := range []string{"cmovg", "cmovl", "cmova", "cmovb", "minsd", "maxsd"} {
+		if !containsInstruction(asm, command, "rsi, rcx") && !containsInstruction(asm, command, "xmm0, xmm1") {
+			t.Errorf("Expected '%v' without a branch", command)
+		}
+	}
+
+	testExecution(code, "-3\n2\n-3\n2\n1\n18446744073709551615\n1\n18446744073709551615\n1.500000\n-2.500000\n-2.500000\n1.500000\n", t)
+}
+
+func TestCodeGenerationStruct(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 7
+	struct p { x: int; f: float; s: string; b: bool }
+	print(p.x)
+	print(len(p.s))
+	p.x = a * 6
+	p.f = 2.5
+	p.s = "abc"
+	b = p.x + 1
+	c = p.f * 2.0
+	print(p.s[1])
+	a = 3
+	print(p.x)
+	`)
+
+	// Assignments print their value
+	testExecution(code, "7\n0\n0\n42\n2.500000\nabc\n43\n5.000000\n98\n3\n42\n", t)
+}
+
+func TestCodeGenerationLoopIncrement(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 3; i++ {
+		print(i)
+	}
+	for j = 1; j < 9; j += 3 {
+		print(j)
+	}
+	for k = 10; k > 0; {
+		k /= 4
+	}
+	`)
+
+	// The assignments in the loop headers print their value as well
+	testExecution(code, "0\n0\n1\n1\n2\n2\n3\n1\n1\n4\n4\n7\n7\n10\n10\n2\n0\n", t)
+}
+
+func TestCodeGenerationDebugSymbols(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 5
+	struct p { x: int; y: float }
+	if argc > 0 {
+		c = 1
+	}
+	const d = 2
+	b = a + d
+	`)
+
+	asm := generateCodeFor(code, t)
+
+	// The struct starts at its last slot. 'p' is never used again, so 'c' and then 'b' get its first slot.
+	for _, s := range [][2]string{{"var_a", "-8"}, {"var_p", "-24"}, {"var_b", "-16"}, {"var_argc", "8"}} {
+		if !containsConstant(asm, s[0], s[1]) {
+			t.Errorf("Expected symbol '%v equ %v'", s[0], s[1])
+		}
+	}
+	// Only top level variables get a symbol. Constants have no slot.
+	for _, c := range asm.constants {
+		if c[0] == "var_c" || c[0] == "var_d" {
+			t.Errorf("Unexpected symbol '%v'", c[0])
+		}
+	}
+
+	testExecution(code, "5\n1\n7\n", t)
+
+	// The shadowed argc gets no second symbol
+	code = []byte(`
+	shadow argc = 3
+	print(argc)
+	`)
+
+	asm = generateCodeFor(code, t)
+
+	if !containsConstant(asm, "var_argc", "-8") || containsConstant(asm, "var_argc", "8") {
+		t.Errorf("Expected only the symbol 'var_argc equ -8', got: %v", asm.constants)
+	}
+	testExecution(code, "3\n3\n", t)
+}
+
+// A long string is a single 'db' in the data section. Non-printable bytes are written as numbers.
+func TestCodeGenerationLongString(t *testing.T) {
+
+	var code []byte = []byte("a = \"" + strings.Repeat(`ab\x01\x02`, 16*1024) + "\"")
+
+	asm := generateCodeFor(code, t)
+
+	expected := strings.Repeat(`"ab", 1, 2, `, 16*1024) + "0"
+	if !containsVariable(asm, "const_0", "db", expected) {
+		t.Errorf("Expected the string as a single 'db'")
+	}
+	if !containsVariable(asm, "const_0_len", "dq", "65536") {
+		t.Errorf("Expected the length of the string")
+	}
+}
+
+// The value for '_' is calculated, but neither stored nor printed
+func TestCodeGenerationBlankIdentifier(t *testing.T) {
+
+	var code []byte = []byte(`
+	_, b = 1, 2
+	a, _ = b * 3, b
+	_ = 7
+	print(a + b)
+	`)
+
+	testExecution(code, "2\n6\n8\n", t)
+}
+
+// A passing assert continues. A failing one aborts with its position on stderr.
+func TestCodeGenerationAssert(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	a = argc + 1
+	assert(a == 2)
+	print(a)
+	assert(a > 2)
+	print(3)
+	`)
+
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(generateCodeFor(code, t), "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(executable)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		t.Errorf("Expected the program to abort on the failing assert")
+	}
+	if string(out) != "2\n2\n" {
+		t.Errorf("Expected output '2' twice, got: %q", string(out))
+	}
+	if message := "[4:1] - Assertion failed\n"; stderr.String() != message {
+		t.Errorf("Expected the message %q on stderr, got %q", message, stderr.String())
+	}
+}
+
+func TestCodeGenerationConversion(t *testing.T) {
+
+	var code []byte = []byte(`
+	i = argc + 2
+	f = float(i) / 2.0
+	print(int(f * -1.0))
+	b = i > 2
+	print(int(b) + 1)
+	print(bool(i - 3))
+	print(uint(i - 4))
+	u = 18446744073709551615u
+	print(int(float(u / 2u + 1u) / 1000000000000000000.0))
+	print(int(float(u) / 1000000000000000000.0))
+	`)
+
+	testExecution(code, "3\n1.500000\n-1\n1\n2\n0\n18446744073709551615\n18446744073709551615\n9\n18\n", t)
+}
+
+func TestCodeGenerationFloatToUint(t *testing.T) {
+
+	// Floats from 2**63 on are valid uint values, but too large for cvttsd2si. The folded conversion agrees.
+	var code []byte = []byte(`
+	f = 10000000000000000000.0 + float(argc - 1)
+	print(uint(f))
+	print(uint(f / 4.0))
+	print(uint(10000000000000000000.0))
+	`)
+
+	testExecution(code, "10000000000000000000.000000\n10000000000000000000\n2500000000000000000\n10000000000000000000\n", t)
+}
+
+func TestCodeGenerationBitNot(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = ~0
+	print(a)
+	i = argc - 1
+	print(~i)
+	print(~(i + 5))
+	u = uint(i)
+	print(~u)
+	`)
+
+	testExecution(code, "-1\n-1\n0\n-1\n-6\n0\n18446744073709551615\n", t)
+}
+
+func TestCodeGenerationUnroll(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 4; i++ {
+		print(i * 10)
+	}
+	for j = 1; j < 10; j += 4 {
+		print(j)
+	}
+	`)
+
+	// The body is inlined once per iteration and there is no jump back
+	countBodies := func(asm ASM) (bodies, jumps int) {
+		for _, l := range asm.program {
+			if strings.Contains(l[1], "print(") {
+				bodies++
+			}
+			if l[1] == "jmp" {
+				jumps++
+			}
+		}
+		return
+	}
+	if bodies, jumps := countBodies(generateCodeFor(code, t)); bodies != 2 || jumps == 0 {
+		t.Errorf("Expected 2 loop bodies and jumps without -O, got %v bodies and %v jumps", bodies, jumps)
+	}
+	// The code generation sets the stack slots in the symbol tables. So the code is analyzed again.
+	ast, err := analyzeCode(code)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	asm := ast.generateCode(Options{optimize: true})
+	if bodies, jumps := countBodies(asm); bodies != 4+3 || jumps != 0 {
+		t.Errorf("Expected 7 unrolled bodies and no jumps with -O, got %v bodies and %v jumps", bodies, jumps)
+	}
+
+	// Loops, that can leave early, change their variable or do not have constant bounds stay as they are
+	for _, c := range []string{
+		"for i = 0; i < 4; i++ {\n\tif i == 2 {\n\t\tbreak\n\t}\n}",
+		"for i = 0; i < 4; i++ {\n\ti = i + 1\n}",
+		"for i = 0; i < argc; i++ {\n}",
+		"for i = 0; i < 100; i++ {\n}",
+		"o = 0\nfor i = 0; i < 3; i++ {\n\tshadow o = o + 1\n}",
+	} {
+		ast, err := analyzeCode([]byte(c))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		loop, _ := ast.block.statements[len(ast.block.statements)-1].(Loop)
+		if _, ok := loop.unrollCount(); ok {
+			t.Errorf("Expected no unrolling for:\n%v", c)
+		}
+	}
+
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+	executable := filepath.Join(t.TempDir(), "executable")
+	if err := assemble(asm, "", executable); err != nil {
+		t.Fatalf("Assembling failed: %v", err)
+	}
+	out, err := exec.Command(executable).Output()
+	if err != nil {
+		t.Fatalf("Execution failed: %v", err)
+	}
+	// The assignments of the loop variables print their value as well
+	expected := "0\n0\n1\n10\n2\n20\n3\n30\n4\n1\n1\n5\n5\n9\n9\n13\n"
+	if string(out) != expected {
+		t.Errorf("Expected output:\n%v\ngot:\n%v", expected, string(out))
+	}
+}
+
+// A loop, that is not unrolled, gives the same result with -O
+func TestCodeGenerationUnrollShadow(t *testing.T) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("'yasm' not found")
+	}
+
+	var code []byte = []byte(`
+	o = 0
+	for i = 0; i < 3; i = i + 1 {
+		shadow o = o + 1
+		print(o)
+	}
+	`)
+
+	outputs := make([]string, 0, 2)
+	for _, options := range []Options{{}, {optimize: true}} {
+		ast, err := analyzeCode(code)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		executable := filepath.Join(t.TempDir(), "executable")
+		if err := assemble(ast.generateCode(options), "", executable); err != nil {
+			t.Fatalf("Assembling failed: %v", err)
+		}
+		out, err := exec.Command(executable).Output()
+		if err != nil {
+			t.Fatalf("Execution failed: %v", err)
+		}
+		outputs = append(outputs, string(out))
+	}
+
+	// Every iteration starts with the outer 'o' again. The assignments print their value as well.
+	expected := "0\n0\n1\n1\n1\n1\n1\n2\n1\n1\n3\n"
+	if outputs[0] != expected || outputs[1] != expected {
+		t.Errorf("Expected output:\n%v\ngot without -O:\n%v\nwith -O:\n%v", expected, outputs[0], outputs[1])
+	}
+}
+
+// A float above the int range is still a float literal for yasm
+func TestCodeGenerationLargeFloat(t *testing.T) {
+
+	var code []byte = []byte(`
+	f = 100000000000000000000.0
+	g = f + float(argc - 1)
+	print(int(g / 10000000000000000.0))
+	`)
+
+	asm := generateCodeFor(code, t)
+	found := false
+	for _, v := range asm.variables {
+		found = found || v[2] == "100000000000000000000.0"
+	}
+	if !found {
+		t.Errorf("Expected the float 100000000000000000000.0 in the data section, got: %v", asm.variables)
+	}
+
+	// The assignments print their value as well
+	testExecution(code, "100000000000000000000.000000\n100000000000000000000.000000\n10000\n", t)
+}
@@ -2,30 +2,68 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 )
 
-func assemble(asm ASM, source, executable string) (err error) {
+// assemblyText returns the source code for yasm
+func assemblyText(asm ASM) string {
+	var b strings.Builder
+	for _, v := range asm.header {
+		fmt.Fprintf(&b, "%v\n", v)
+	}
+	// Long names (const_10_len) still need a space after them
+	for _, v := range asm.constants {
+		fmt.Fprintf(&b, "%-11v %-9v %-15v\n", v[0], "equ", v[1])
+	}
+	for _, v := range asm.variables {
+		fmt.Fprintf(&b, "%-11v %-9v %-15v\n", v[0], v[1], v[2])
+	}
+	for _, v := range asm.program {
+		fmt.Fprintf(&b, "%v%-10v%-10v\n", v[0], v[1], v[2])
+	}
+	return b.String()
+}
+
+// writeSource writes the whole text at once. It goes into a temporary file next to name first, which is renamed
+// afterwards. So an error never leaves a partial source file behind.
+func writeSource(name, text string) error {
+	tmp := name + ".tmp"
+	if e := ioutil.WriteFile(tmp, []byte(text), 0644); e != nil {
+		os.Remove(tmp)
+		return e
+	}
+	if e := os.Rename(tmp, name); e != nil {
+		os.Remove(tmp)
+		return e
+	}
+	return nil
+}
 
-	var srcFile *os.File
-	var e error
+// assemble writes the assembly into source (a temporary file, if empty) and builds the executable with yasm and ld.
+// Temporary files are removed on every path, including errors of yasm and ld.
+func assemble(asm ASM, source, executable string) (err error) {
 
 	if source == "" {
-		srcFile, e = ioutil.TempFile("", "")
+		srcFile, e := ioutil.TempFile("", "")
 		if e != nil {
 			err = fmt.Errorf("Creating temporary srcFile failed - %w", e)
 			return
 		}
-		defer os.Remove(srcFile.Name())
-	} else {
-		srcFile, e = os.Create(source)
-		if e != nil {
-			err = fmt.Errorf("Creating srcFile failed - %w", e)
-			return
-		}
+		srcFile.Close()
+		source = srcFile.Name()
+		defer os.Remove(source)
+	}
+
+	if e := writeSource(source, assemblyText(asm)); e != nil {
+		err = fmt.Errorf("Writing srcFile failed - %w", e)
+		return
 	}
 
 	objectFile, e := ioutil.TempFile("", "")
@@ -33,24 +71,8 @@ func assemble(asm ASM, source, executable string) (err error) {
 		err = fmt.Errorf("Creating temporary objectFile failed - %w", e)
 		return
 	}
-	objectFile.Close()
-
-	// Write assembly into tmp source file
 	defer os.Remove(objectFile.Name())
-
-	for _, v := range asm.header {
-		fmt.Fprintf(srcFile, "%v\n", v)
-	}
-	for _, v := range asm.constants {
-		fmt.Fprintf(srcFile, "%-12v%-10v%-15v\n", v[0], "equ", v[1])
-	}
-	for _, v := range asm.variables {
-		fmt.Fprintf(srcFile, "%-12v%-10v%-15v\n", v[0], v[1], v[2])
-	}
-	for _, v := range asm.program {
-		fmt.Fprintf(srcFile, "%v%-10v%-10v\n", v[0], v[1], v[2])
-	}
-	srcFile.Close()
+	objectFile.Close()
 
 	// Find yasm
 	yasm, e := exec.LookPath("yasm")
@@ -61,7 +83,7 @@ func assemble(asm ASM, source, executable string) (err error) {
 	// Assemble
 	yasmCmd := &exec.Cmd{
 		Path:   yasm,
-		Args:   []string{yasm, "-Worphan-labels", "-g", "dwarf2", "-f", "elf64", srcFile.Name(), "-o", objectFile.Name()},
+		Args:   []string{yasm, "-Worphan-labels", "-g", "dwarf2", "-f", "elf64", source, "-o", objectFile.Name()},
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 	}
@@ -79,7 +101,7 @@ func assemble(asm ASM, source, executable string) (err error) {
 	// Link
 	ldCmd := &exec.Cmd{
 		Path:   ld,
-		Args:   []string{ld, "-dynamic-linker", "/lib64/ld-linux-x86-64.so.2", "-o", executable, objectFile.Name(), "-lc"},
+		Args:   linkArgs(ld, objectFile.Name(), executable, asm.pie),
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 	}
@@ -90,7 +112,217 @@ func assemble(asm ASM, source, executable string) (err error) {
 	return
 }
 
+// write assembles the code into 'executable'. The source stays in 'source.asm'. See CodeGenerator.
+func (asm *ASM) write(out io.Writer, options Options) error {
+	start := time.Now()
+	err := assemble(*asm, "source.asm", "executable")
+	options.logTiming("assembler", start)
+	return err
+}
+
+// linkArgs returns the command line of ld. The code of a position independent executable must be generated for it.
+func linkArgs(ld, object, executable string, pie bool) []string {
+	mode := "-no-pie"
+	if pie {
+		mode = "-pie"
+	}
+	return []string{ld, mode, "-dynamic-linker", "/lib64/ld-linux-x86-64.so.2", "-o", executable, object, "-lm", "-lc"}
+}
+
+// internalError is a panic of a compiler stage together with the position of the statement, it happened in
+type internalError struct {
+	line, column int
+	reason       interface{}
+}
+
+// positionPanic adds the position of the statement to a recovered panic and panics again. Panics, that already have
+// a position, come from a nested statement and keep theirs.
+func positionPanic(r interface{}, s Statement) {
+	if r == nil {
+		return
+	}
+	if _, ok := r.(internalError); !ok && s != nil {
+		row, col := s.startPos()
+		r = internalError{row, col, r}
+	}
+	panic(r)
+}
+
+// runStage runs one stage of the compiler. A panic is always a bug in the compiler. But the user gets an error with
+// the position instead of a crash with a stack trace.
+func runStage(name string, stage func()) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if e, ok := r.(internalError); ok {
+			err = errorAt(ErrCritical, e.line, e.column, "Internal compiler error in %v: %v", name, e.reason)
+			return
+		}
+		err = fmt.Errorf("%wInternal compiler error in %v: %v", ErrCritical, name, r)
+	}()
+	stage()
+	return
+}
+
+// Options change how a program is compiled. The zero value is the default.
+type Options struct {
+	// werror turns all warnings into errors
+	werror bool
+	// trapv aborts the program on signed integer overflow instead of wrapping around
+	trapv bool
+	// pie builds a position independent executable
+	pie bool
+	// wshadow warns about every 'shadow', that hides another name
+	wshadow bool
+	// wunused warns about every variable, that is never read
+	wunused bool
+	// check stops after the semantic analysis. No code is generated.
+	check bool
+	// optimize unrolls loops with a small constant number of iterations
+	optimize bool
+	// verbose gets the time of every stage, if it is set
+	verbose io.Writer
+}
+
+// logTiming writes the time since start for the stage to options.verbose
+func (options Options) logTiming(stage string, start time.Time) {
+	if options.verbose != nil {
+		fmt.Fprintf(options.verbose, "%-20v %v\n", stage+":", time.Since(start))
+	}
+}
+
+// CodeGenerator is a backend of the compiler. It translates the analyzed AST and writes the result.
+type CodeGenerator interface {
+	// generate translates the analyzed AST. A panic is a bug in the backend, see runStage.
+	generate(ast AST, options Options)
+	// write outputs the generated code. Text goes to out, an executable into a file.
+	write(out io.Writer, options Options) error
+}
+
+// codeGenerators holds all backends by the name, that selects them with -target
+var codeGenerators = map[string]func() CodeGenerator{
+	"x86":      func() CodeGenerator { return &ASM{} },
+	"bytecode": func() CodeGenerator { return &Bytecode{} },
+}
+
+// analyze runs all stages from the source code to the analyzed AST, which is the input of every backend
+func analyze(program []byte, options Options) (ast AST, err error) {
+
+	var diagnostics Diagnostics
+	if options.verbose != nil {
+		// The lexer runs alongside the parser. To time them separately, the whole program is lexed first.
+		start := time.Now()
+		tokens, lexerErr := tokenizeAll(program)
+		options.logTiming("lexer", start)
+		if lexerErr != nil {
+			err = lexerErr
+			return
+		}
+		start = time.Now()
+		err = runStage("parser", func() { ast, diagnostics = parse(tokenChannel(tokens)) })
+		options.logTiming("parser", start)
+	} else {
+		tokenChan, lexerErr, stop := lex(program)
+		err = runStage("parser", func() { ast, diagnostics = parse(tokenChan) })
+		// The parser might have stopped early. The lexer is not needed anymore.
+		stop()
+
+		// As we lex and parse simultaneously, there is most likely a parser error as well. But that should be
+		// ignored as long as we have token errors before!
+		select {
+		case e := <-lexerErr:
+			err = e
+			return
+		default:
+		}
+	}
+	if err != nil {
+		return
+	}
+	if diagnostics != nil {
+		err = diagnostics
+		return
+	}
+
+	start := time.Now()
+	err = runStage("semantic analysis", func() { ast, diagnostics = semanticAnalysis(ast, options) })
+	options.logTiming("semantic analysis", start)
+	if err != nil {
+		return
+	}
+	if diagnostics != nil {
+		err = diagnostics
+		return
+	}
+
+	if options.werror && len(ast.warnings) > 0 {
+		for _, w := range ast.warnings {
+			diagnostics = append(diagnostics, w.asError())
+		}
+		err = diagnostics
+	}
+	return
+}
+
+// compile runs all stages from the source code to the assembly and returns the warnings of the semantic analysis, even
+// if there are errors as well
+func compile(program []byte, options Options) (asm ASM, warnings []Diagnostic, err error) {
+	ast, err := analyze(program, options)
+	warnings = ast.warnings
+	if err != nil || options.check {
+		return
+	}
+
+	start := time.Now()
+	err = runStage("code generation", func() { asm.generate(ast, options) })
+	options.logTiming("code generation", start)
+	return
+}
+
+// check writes all warnings and errors of the program to out and returns the exit code. It is 0, if there is no error.
+func check(program []byte, options Options, out io.Writer) int {
+	options.check = true
+	_, warnings, err := compile(program, options)
+	// With -werror, the warnings are part of the errors
+	for _, w := range warnings {
+		if !options.werror {
+			fmt.Fprintln(out, w)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	dumpTokensFlag := flag.Bool("dump-tokens", false, "Print all tokens of the program and exit")
+	interactiveFlag := flag.Bool("i", false, "Interactive mode. Analyzes statements from stdin line by line")
+	werrorFlag := flag.Bool("werror", false, "Treat all warnings as errors")
+	trapvFlag := flag.Bool("ftrapv", false, "Abort on signed integer overflow in '+', '-' and '*' instead of wrapping around")
+	pieFlag := flag.Bool("pie", false, "Build a position independent executable")
+	noPieFlag := flag.Bool("no-pie", false, "Build a position dependent executable (default)")
+	wshadowFlag := flag.Bool("Wshadow", false, "Warn about every 'shadow', that hides a variable of a surrounding block")
+	wunusedFlag := flag.Bool("Wunused", false, "Warn about every variable, that is never read")
+	optimizeFlag := flag.Bool("O", false, "Optimize the generated code. Unrolls small loops")
+	checkFlag := flag.Bool("check", false, "Only report warnings and errors. No code is generated")
+	verboseFlag := flag.Bool("v", false, "Print the time of every compiler stage to stderr")
+	targetFlag := flag.String("target", "x86", "Backend: 'x86' builds an executable, 'bytecode' prints stack machine code")
+	flag.Parse()
+
+	options := Options{werror: *werrorFlag, trapv: *trapvFlag, pie: *pieFlag && !*noPieFlag, wshadow: *wshadowFlag, wunused: *wunusedFlag, optimize: *optimizeFlag}
+	if *verboseFlag {
+		options.verbose = os.Stderr
+	}
+
+	if *interactiveFlag {
+		repl(os.Stdin, os.Stdout)
+		return
+	}
+
 	var program []byte = []byte(`
 
 //v = (10 + 5 + 3 + 2) * -1 * 3
@@ -104,37 +336,45 @@ for i = 0; i < 5; i = i+1 {
 
 `)
 
-	tokenChan := make(chan Token, 1)
-	lexerErr := make(chan error, 1)
-	go tokenize(program, tokenChan, lexerErr)
-
-	ast, parseErr := parse(tokenChan)
+	if *dumpTokensFlag {
+		tokens, err := tokenizeAll(program)
+		dumpTokens(os.Stdout, tokens)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// check error channel on incoming errors
-	// As we lex and parse simultaneously, there is most likely a parser error as well. But that should be ignored
-	// as long as we have token errors before!
-	select {
-	case e := <-lexerErr:
-		fmt.Println(e)
-		os.Exit(1)
-	default:
+	if *checkFlag {
+		os.Exit(check(program, options, os.Stdout))
 	}
 
-	if parseErr != nil {
-		fmt.Println(parseErr)
-		os.Exit(1)
+	newGenerator, ok := codeGenerators[*targetFlag]
+	if !ok {
+		fmt.Printf("Unknown target '%v'. Use 'x86' or 'bytecode'\n", *targetFlag)
+		os.Exit(2)
 	}
+	generator := newGenerator()
 
-	ast, semanticErr := semanticAnalysis(ast)
-	if semanticErr != nil {
-		fmt.Println(semanticErr)
+	ast, err := analyze(program, options)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	// stdout is reserved for the output of the backend
+	for _, w := range ast.warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
 
-	asm := ast.generateCode()
-
-	if asmErr := assemble(asm, "source.asm", "executable"); asmErr != nil {
-		fmt.Println(asmErr)
+	start := time.Now()
+	err = runStage("code generation", func() { generator.generate(ast, options) })
+	options.logTiming("code generation", start)
+	if err == nil {
+		err = generator.write(os.Stdout, options)
+	}
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 }
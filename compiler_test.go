@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+
+	asm, warnings, err := compile([]byte("a = 1\nb = a + 2"), Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(warnings) != 0 || len(asm.program) == 0 {
+		t.Errorf("Expected a program without warnings, got: %v, %v", asm.program, warnings)
+	}
+
+	if _, _, err := compile([]byte("a = 1 +"), Options{}); err == nil {
+		t.Errorf("Expected a parse error")
+	}
+	if _, _, err := compile([]byte(`a = 1 + "b"`), Options{}); err == nil {
+		t.Errorf("Expected a semantic error")
+	}
+}
+
+func TestCompileWerror(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = argc
+	b = a + 1
+	`)
+
+	if _, warnings, err := compile(code, Options{wunused: true}); err != nil || len(warnings) != 1 {
+		t.Errorf("Expected one warning and no error, got: %v, %v", warnings, err)
+	}
+
+	_, _, err := compile(code, Options{wunused: true, werror: true})
+	expected := "error - [2:1] - 'b' is declared but never used"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error '%v', got: %v", expected, err)
+	}
+	if !errors.Is(err, ErrNormal) {
+		t.Errorf("Expected the warning to be an ErrNormal, got: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	var out strings.Builder
+	if code := check([]byte("a = 1\nif a == 1 {}"), Options{}, &out); code != 0 || out.String() != "" {
+		t.Errorf("Expected exit code 0 without output, got %v: %q", code, out.String())
+	}
+
+	// All independent errors are reported, not just the first one
+	out.Reset()
+	code := check([]byte("a = 1\na = 1 + \"b\"\nprint(1.5)\nif true {}"), Options{}, &out)
+	expected := "[3:3] - warning - condition is always true\n" +
+		"[1:4] - BinaryOp '+' expected same type, got: 'int', 'string'\n" +
+		"[2:6] - Function 'print' can not be called with 'float'\n"
+	if code != 1 || out.String() != expected {
+		t.Errorf("Expected exit code 1 and output %q, got %v: %q", expected, code, out.String())
+	}
+}
+
+func TestCompileVerbose(t *testing.T) {
+
+	// The assembler writes its files into the working directory
+	t.Chdir(t.TempDir())
+
+	var out strings.Builder
+	asm, _, err := compile([]byte("a = 1\nprint(a)"), Options{verbose: &out})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := asm.write(&out, Options{verbose: &out}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, stage := range []string{"lexer:", "parser:", "semantic analysis:", "code generation:", "assembler:"} {
+		if !strings.Contains(out.String(), stage) {
+			t.Errorf("Expected the time of '%v' in the verbose output, got:\n%v", stage, out.String())
+		}
+	}
+
+	// The errors are the same as without the timing
+	out.Reset()
+	if _, _, err := compile([]byte("a = 1 $"), Options{verbose: &out}); err == nil || strings.Contains(out.String(), "parser:") {
+		t.Errorf("Expected a lexer error before the parser, got: %v\n%v", err, out.String())
+	}
+
+	// -check stops before the code generation
+	out.Reset()
+	if _, _, err := compile([]byte("a = 1"), Options{check: true, verbose: &out}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "code generation:") {
+		t.Errorf("Expected no code generation with check, got:\n%v", out.String())
+	}
+}
+
+func TestCompileRecoversInternalErrors(t *testing.T) {
+
+	// An expression statement without expression can not come from the parser
+	var ast AST
+	ast.block.statements = []Statement{
+		Condition{
+			Constant{TYPE_BOOL, "true", 1, 3},
+			Block{[]Statement{ExprStatement{nil, 2, 4}}, SymbolTable{}, 2, 4},
+			Block{},
+			1, 0,
+		},
+	}
+
+	err := runStage("code generation", func() { ast.generateCode(Options{}) })
+	if err == nil {
+		t.Fatalf("Expected an internal compiler error")
+	}
+	expected := "[2:4] - Internal compiler error in code generation: "
+	if !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("Expected error starting with '%v', got: %v", expected, err)
+	}
+}
+
+func TestLinkArgs(t *testing.T) {
+	for pie, mode := range map[bool]string{false: "-no-pie", true: "-pie"} {
+		args := linkArgs("ld", "object.o", "executable", pie)
+		if args[1] != mode || strings.Join(args[2:], " ") != "-dynamic-linker /lib64/ld-linux-x86-64.so.2 -o executable object.o -lm -lc" {
+			t.Errorf("Unexpected link command with pie=%v: %v", pie, args)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Constant folding evaluates operations on constants at compile time and replaces them by the resulting Constant.
+// It runs as part of the semantic analysis, so all expressions are already type checked and annotated.
+// If an operation can not be folded (e.g. a float division results in Inf/NaN), the expression stays unchanged
+// and is calculated at runtime.
+
+// constInt parses the value. The semantic analysis rejects literals out of range, so an error is an internal error.
+func constInt(c Constant) int64 {
+	v, err := strconv.ParseInt(c.cValue, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Constant folding error. Invalid int constant: %v", c.cValue))
+	}
+	return v
+}
+
+// constUint parses the value without the 'u' suffix
+func constUint(c Constant) uint64 {
+	v, err := strconv.ParseUint(strings.TrimSuffix(c.cValue, "u"), 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Constant folding error. Invalid uint constant: %v", c.cValue))
+	}
+	return v
+}
+
+func constFloat(c Constant) float64 {
+	v, _ := strconv.ParseFloat(c.cValue, 64)
+	return v
+}
+
+func constBool(c Constant) bool {
+	return c.cValue == "true"
+}
+
+// constString returns the string without the surrounding quotes
+func constString(c Constant) string {
+	return strings.TrimSuffix(strings.TrimPrefix(c.cValue, "\""), "\"")
+}
+
+func newIntConstant(v int64, line, column int) Constant {
+	return Constant{TYPE_INT, strconv.FormatInt(v, 10), line, column}
+}
+
+func newUintConstant(v uint64, line, column int) Constant {
+	return Constant{TYPE_UINT, strconv.FormatUint(v, 10) + "u", line, column}
+}
+
+// zeroConstant returns the zero value of a type, e.g. for the fields of a new struct
+func zeroConstant(t Type, line, column int) Constant {
+	switch t {
+	case TYPE_UINT:
+		return Constant{TYPE_UINT, "0u", line, column}
+	case TYPE_FLOAT:
+		return Constant{TYPE_FLOAT, "0.0", line, column}
+	case TYPE_BOOL:
+		return Constant{TYPE_BOOL, "false", line, column}
+	case TYPE_STRING:
+		return Constant{TYPE_STRING, `""`, line, column}
+	}
+	return Constant{TYPE_INT, "0", line, column}
+}
+
+func newBoolConstant(v bool, line, column int) Constant {
+	return Constant{TYPE_BOOL, strconv.FormatBool(v), line, column}
+}
+
+// newFloatConstant returns false, if the value can not be represented as a float literal (Inf, NaN).
+func newFloatConstant(v float64, line, column int) (Constant, bool) {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return Constant{}, false
+	}
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	// Float literals always need a '.'. Otherwise they would be an int (or too large for one).
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return Constant{TYPE_FLOAT, s, line, column}, true
+}
+
+func foldUnaryOp(unaryOp UnaryOp) (Expression, error) {
+	c, ok := unaryOp.expr.(Constant)
+	if !ok {
+		return unaryOp, nil
+	}
+
+	switch unaryOp.operator {
+	case OP_NEGATIVE:
+		if c.cType == TYPE_FLOAT {
+			if f, ok := newFloatConstant(-constFloat(c), unaryOp.line, unaryOp.column); ok {
+				return f, nil
+			}
+			return unaryOp, nil
+		}
+		return newIntConstant(-constInt(c), unaryOp.line, unaryOp.column), nil
+	case OP_NOT:
+		return newBoolConstant(!constBool(c), unaryOp.line, unaryOp.column), nil
+	case OP_BITNOT:
+		if c.cType == TYPE_UINT {
+			return newUintConstant(^constUint(c), unaryOp.line, unaryOp.column), nil
+		}
+		return newIntConstant(^constInt(c), unaryOp.line, unaryOp.column), nil
+	}
+	return unaryOp, nil
+}
+
+// foldIndex reads the byte of a constant string. A constant index must be in range, even if the string is not constant.
+func foldIndex(index Index) (Expression, error) {
+	i, ok := index.index.(Constant)
+	if !ok {
+		return index, nil
+	}
+	n := constInt(i)
+	if n < 0 {
+		return index, errorAt(ErrCritical, i.line, i.column, "Index %v is negative", n)
+	}
+
+	c, ok := index.expr.(Constant)
+	if !ok {
+		return index, nil
+	}
+	s := constString(c)
+	if n >= int64(len(s)) {
+		return index, errorAt(ErrCritical, i.line, i.column, "Index %v is out of range for a string of length %v", n, len(s))
+	}
+	return newIntConstant(int64(s[n]), index.line, index.column), nil
+}
+
+// foldFunctionCall folds the builtins without side effects. len counts the bytes of a constant string.
+func foldFunctionCall(call FunctionCall) Expression {
+	c, ok := call.args[0].(Constant)
+	if !ok || call.name != "len" {
+		return call
+	}
+	return newIntConstant(int64(len(constString(c))), call.line, call.column)
+}
+
+// foldConversion converts a constant just like the generated code does. Floats out of the range of an int64 (or uint64
+// for a uint) are left to the runtime.
+func foldConversion(conversion Conversion) Expression {
+	c, ok := conversion.expr.(Constant)
+	if !ok {
+		return conversion
+	}
+	row, col := conversion.line, conversion.column
+
+	if c.cType == TYPE_FLOAT && conversion.cType != TYPE_FLOAT {
+		f := constFloat(c)
+		if conversion.cType == TYPE_UINT && f >= 1<<63 && f < 1<<64 {
+			return newUintConstant(uint64(f), row, col)
+		}
+		if !(f > -1<<63 && f < 1<<63) {
+			return conversion
+		}
+		c = newIntConstant(int64(f), row, col)
+	}
+
+	switch conversion.cType {
+	case TYPE_INT:
+		switch c.cType {
+		case TYPE_UINT:
+			return newIntConstant(int64(constUint(c)), row, col)
+		case TYPE_BOOL:
+			return newIntConstant(int64(zeroOrOne(constBool(c))), row, col)
+		}
+		return newIntConstant(constInt(c), row, col)
+	case TYPE_UINT:
+		switch c.cType {
+		case TYPE_INT:
+			return newUintConstant(uint64(constInt(c)), row, col)
+		case TYPE_BOOL:
+			return newUintConstant(uint64(zeroOrOne(constBool(c))), row, col)
+		}
+		return newUintConstant(constUint(c), row, col)
+	case TYPE_FLOAT:
+		v := constFloat(c)
+		switch c.cType {
+		case TYPE_INT:
+			v = float64(constInt(c))
+		case TYPE_UINT:
+			v = float64(constUint(c))
+		}
+		if f, ok := newFloatConstant(v, row, col); ok {
+			return f
+		}
+	case TYPE_BOOL:
+		switch c.cType {
+		case TYPE_INT:
+			return newBoolConstant(constInt(c) != 0, row, col)
+		case TYPE_UINT:
+			return newBoolConstant(constUint(c) != 0, row, col)
+		}
+		return newBoolConstant(constBool(c), row, col)
+	case TYPE_STRING:
+		c.line, c.column = row, col
+		return c
+	}
+	return conversion
+}
+
+func zeroOrOne(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func foldBinaryOp(binaryOp BinaryOp) (Expression, error) {
+	left, okLeft := binaryOp.leftExpr.(Constant)
+	right, okRight := binaryOp.rightExpr.(Constant)
+	if !okLeft || !okRight {
+		return binaryOp, nil
+	}
+
+	switch left.cType {
+	case TYPE_INT:
+		return foldBinaryOpInt(binaryOp, constInt(left), constInt(right))
+	case TYPE_UINT:
+		return foldBinaryOpUint(binaryOp, constUint(left), constUint(right))
+	case TYPE_FLOAT:
+		return foldBinaryOpFloat(binaryOp, constFloat(left), constFloat(right))
+	case TYPE_BOOL:
+		return foldBinaryOpBool(binaryOp, constBool(left), constBool(right))
+	case TYPE_STRING:
+		return foldBinaryOpString(binaryOp, constString(left), constString(right))
+	}
+	return binaryOp, nil
+}
+
+// checkFoldOverflow reports a signed overflow of a constant '+', '-', '*' or unary '-' with trapv. The generated code
+// would abort there, instead of wrapping around like the folding. For unary '-', left and right are the operand.
+func checkFoldOverflow(op Operator, left, right Expression, line, column int, analysis *Analysis) error {
+	l, okLeft := left.(Constant)
+	r, okRight := right.(Constant)
+	if !analysis.trapv || !okLeft || !okRight || l.cType != TYPE_INT || r.cType != TYPE_INT {
+		return nil
+	}
+	if overflowsInt(op, constInt(l), constInt(r)) {
+		return errorAt(ErrCritical, line, column, "Integer overflow in '%v'", op)
+	}
+	return nil
+}
+
+// overflowsInt checks, if the signed operation does not fit into 64 bit. For OP_NEGATIVE, only l is used.
+func overflowsInt(op Operator, l, r int64) bool {
+	switch op {
+	case OP_PLUS:
+		return (r > 0 && l > math.MaxInt64-r) || (r < 0 && l < math.MinInt64-r)
+	case OP_MINUS:
+		return (r < 0 && l > math.MaxInt64+r) || (r > 0 && l < math.MinInt64+r)
+	case OP_MULT:
+		if l == 0 || r == 0 {
+			return false
+		}
+		if (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return true
+		}
+		return (l*r)/r != l
+	case OP_NEGATIVE:
+		return l == math.MinInt64
+	}
+	return false
+}
+
+// powInt calculates l ** r with wrap around, just like the generated code. A negative exponent truncates the result
+// like an integer division: Only 1 and -1 stay non zero.
+func powInt(l, r int64) int64 {
+	if r < 0 {
+		if l != 1 && l != -1 {
+			return 0
+		}
+		r = -r
+	}
+	// Two's complement: Wrapping multiplication is the same for signed and unsigned numbers
+	return int64(powUint(uint64(l), uint64(r)))
+}
+
+func powUint(l, r uint64) uint64 {
+	result := uint64(1)
+	for ; r != 0; r >>= 1 {
+		if r&1 == 1 {
+			result *= l
+		}
+		l *= l
+	}
+	return result
+}
+
+func foldBinaryOpInt(binaryOp BinaryOp, l, r int64) (Expression, error) {
+	row, col := binaryOp.line, binaryOp.column
+
+	switch binaryOp.operator {
+	case OP_PLUS:
+		return newIntConstant(l+r, row, col), nil
+	case OP_MINUS:
+		return newIntConstant(l-r, row, col), nil
+	case OP_MULT:
+		return newIntConstant(l*r, row, col), nil
+	case OP_DIV:
+		if r == 0 {
+			return binaryOp, errorAt(ErrCritical, row, col, "Division by zero in: %v", binaryOp)
+		}
+		return newIntConstant(l/r, row, col), nil
+	case OP_MOD:
+		if r == 0 {
+			return binaryOp, errorAt(ErrCritical, row, col, "Division by zero in: %v", binaryOp)
+		}
+		// Go truncates toward zero just like idiv at runtime
+		return newIntConstant(l%r, row, col), nil
+	case OP_POW:
+		if l == 0 && r < 0 {
+			return binaryOp, errorAt(ErrCritical, row, col, "Division by zero in: %v", binaryOp)
+		}
+		return newIntConstant(powInt(l, r), row, col), nil
+	}
+
+	cmp := 0
+	if l < r {
+		cmp = -1
+	} else if l > r {
+		cmp = 1
+	}
+	return foldComparison(binaryOp, cmp)
+}
+
+func foldBinaryOpUint(binaryOp BinaryOp, l, r uint64) (Expression, error) {
+	row, col := binaryOp.line, binaryOp.column
+
+	switch binaryOp.operator {
+	case OP_PLUS:
+		return newUintConstant(l+r, row, col), nil
+	case OP_MINUS:
+		return newUintConstant(l-r, row, col), nil
+	case OP_MULT:
+		return newUintConstant(l*r, row, col), nil
+	case OP_DIV, OP_MOD:
+		if r == 0 {
+			return binaryOp, errorAt(ErrCritical, row, col, "Division by zero in: %v", binaryOp)
+		}
+		if binaryOp.operator == OP_MOD {
+			return newUintConstant(l%r, row, col), nil
+		}
+		return newUintConstant(l/r, row, col), nil
+	case OP_POW:
+		return newUintConstant(powUint(l, r), row, col), nil
+	}
+
+	cmp := 0
+	if l < r {
+		cmp = -1
+	} else if l > r {
+		cmp = 1
+	}
+	return foldComparison(binaryOp, cmp)
+}
+
+func foldBinaryOpFloat(binaryOp BinaryOp, l, r float64) (Expression, error) {
+	row, col := binaryOp.line, binaryOp.column
+
+	var v float64
+	switch binaryOp.operator {
+	case OP_PLUS:
+		v = l + r
+	case OP_MINUS:
+		v = l - r
+	case OP_MULT:
+		v = l * r
+	case OP_DIV:
+		v = l / r
+	case OP_MOD:
+		// The same as fmod at runtime
+		v = math.Mod(l, r)
+	case OP_POW:
+		v = math.Pow(l, r)
+	default:
+		cmp := 0
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+		return foldComparison(binaryOp, cmp)
+	}
+	if f, ok := newFloatConstant(v, row, col); ok {
+		return f, nil
+	}
+	return binaryOp, nil
+}
+
+func foldBinaryOpBool(binaryOp BinaryOp, l, r bool) (Expression, error) {
+	row, col := binaryOp.line, binaryOp.column
+
+	switch binaryOp.operator {
+	case OP_AND:
+		return newBoolConstant(l && r, row, col), nil
+	case OP_OR:
+		return newBoolConstant(l || r, row, col), nil
+	case OP_EQ:
+		return newBoolConstant(l == r, row, col), nil
+	case OP_NE:
+		return newBoolConstant(l != r, row, col), nil
+	}
+	return binaryOp, nil
+}
+
+func foldBinaryOpString(binaryOp BinaryOp, l, r string) (Expression, error) {
+	return foldComparison(binaryOp, strings.Compare(l, r))
+}
+
+// foldComparison folds all comparison operators, given the result of comparing left and right side
+func foldComparison(binaryOp BinaryOp, cmp int) (Expression, error) {
+	row, col := binaryOp.line, binaryOp.column
+
+	switch binaryOp.operator {
+	case OP_EQ:
+		return newBoolConstant(cmp == 0, row, col), nil
+	case OP_NE:
+		return newBoolConstant(cmp != 0, row, col), nil
+	case OP_LE:
+		return newBoolConstant(cmp <= 0, row, col), nil
+	case OP_GE:
+		return newBoolConstant(cmp >= 0, row, col), nil
+	case OP_LESS:
+		return newBoolConstant(cmp < 0, row, col), nil
+	case OP_GREATER:
+		return newBoolConstant(cmp > 0, row, col), nil
+	}
+	return binaryOp, nil
+}
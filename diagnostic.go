@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Diagnostics are the machine readable form of all errors and warnings, e.g. for an editor integration.
+// Errors are created with errorAt and carry their position in a positionError. Wrapped into other errors, newDiagnostic
+// still finds it at the end of parse and semanticAnalysis.
+
+type Severity int
+
+const (
+	SEVERITY_ERROR = iota
+	SEVERITY_WARNING
+)
+
+type Diagnostic struct {
+	severity     Severity
+	message      string
+	line, column int
+	// The original error. So errors.Is(diagnostic, ErrCritical) still works.
+	err error
+}
+
+// Diagnostics is returned by parse and semanticAnalysis. It is nil, if there is no error.
+type Diagnostics []Diagnostic
+
+// positionError is an error at a position of the source. It wraps one of the sentinel errors (ErrCritical, ...).
+type positionError struct {
+	err          error
+	line, column int
+	message      string
+}
+
+// errorAt creates an error at the position. Its text is "[line:column] - message" after the text of err. The kinds
+// of critical errors (ErrTypeMismatch, ...) are left out of the text.
+func errorAt(err error, line, column int, format string, args ...interface{}) error {
+	return positionError{err, line, column, fmt.Sprintf(format, args...)}
+}
+
+func (e positionError) position() string {
+	return fmt.Sprintf("[%v:%v] - ", e.line, e.column)
+}
+
+func (e positionError) Error() string {
+	if _, ok := e.err.(errorKind); ok {
+		return ErrCritical.Error() + e.position() + e.message
+	}
+	return e.err.Error() + e.position() + e.message
+}
+
+func (e positionError) Unwrap() error {
+	return e.err
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SEVERITY_ERROR:
+		return "error"
+	case SEVERITY_WARNING:
+		return "warning"
+	}
+	return "?"
+}
+
+// newDiagnostic creates an error diagnostic. The position is -1, -1, if the error has none. The message is the whole
+// text of the error without the position.
+func newDiagnostic(err error) Diagnostic {
+	text := err.Error()
+	d := Diagnostic{SEVERITY_ERROR, text, -1, -1, err}
+
+	var p positionError
+	if errors.As(err, &p) {
+		d.line, d.column = p.line, p.column
+		d.message = strings.TrimSpace(strings.Replace(text, p.position(), "", 1))
+	}
+	return d
+}
+
+func newWarning(line, column int, message string) Diagnostic {
+	return Diagnostic{SEVERITY_WARNING, message, line, column, nil}
+}
+
+// asError promotes a warning to an error (ErrNormal), e.g. for -werror
+func (d Diagnostic) asError() Diagnostic {
+	return newDiagnostic(errorAt(ErrNormal, d.line, d.column, "%v", d.message))
+}
+
+// Error keeps the error messages as they were before: "[line:column] - message"
+func (d Diagnostic) Error() string {
+	if d.err != nil {
+		return d.err.Error()
+	}
+	return fmt.Sprintf("[%v:%v] - %v - %v", d.line, d.column, d.severity, d.message)
+}
+
+func (d Diagnostic) Unwrap() error {
+	return d.err
+}
+
+func (ds Diagnostics) Error() string {
+	s := make([]string, 0, len(ds))
+	for _, d := range ds {
+		s = append(s, d.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+func (ds Diagnostics) Unwrap() []error {
+	errs := make([]error, 0, len(ds))
+	for _, d := range ds {
+		errs = append(errs, d)
+	}
+	return errs
+}
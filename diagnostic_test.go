@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testDiagnostic(d Diagnostic, severity Severity, line, column int, message string, t *testing.T) {
+	if d.severity != severity || d.line != line || d.column != column || d.message != message {
+		t.Errorf(
+			"Expected diagnostic (%v, %v:%v, %q), got: (%v, %v:%v, %q)",
+			severity, line, column, message, d.severity, d.line, d.column, d.message,
+		)
+	}
+}
+
+func TestDiagnosticTypeError(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1 + "s"
+	`)
+
+	tokens, _ := tokenizeAll(code)
+	ast, diagnostics := parse(tokenChannel(tokens))
+	if diagnostics != nil {
+		t.Fatalf("Unexpected parse error: %v", diagnostics)
+	}
+	_, diagnostics = semanticAnalysis(ast, Options{})
+
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic, got: %v", diagnostics)
+	}
+	testDiagnostic(diagnostics[0], SEVERITY_ERROR, 1, 5, "BinaryOp '+' expected same type, got: 'int', 'string'", t)
+
+	if !errors.Is(diagnostics, ErrCritical) {
+		t.Errorf("Expected the diagnostics to keep the critical error")
+	}
+}
+
+func TestDiagnosticSyntaxError(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = (1
+	`)
+
+	tokens, _ := tokenizeAll(code)
+	_, diagnostics := parse(tokenChannel(tokens))
+
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic, got: %v", diagnostics)
+	}
+	testDiagnostic(diagnostics[0], SEVERITY_ERROR, 2, 1, "Expected ')', got EOF - Simple expression expected - Expression list is empty or invalid - Invalid expression list in assignment", t)
+}
+
+func TestDiagnosticWarning(t *testing.T) {
+
+	ast, err := analyzeCode([]byte(`if false {}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(ast.warnings) != 1 {
+		t.Fatalf("Expected one warning, got: %v", ast.warnings)
+	}
+	testDiagnostic(ast.warnings[0], SEVERITY_WARNING, 0, 3, "condition is always false", t)
+}
+
+func TestDiagnosticPosition(t *testing.T) {
+
+	// The position is found in a wrapped error. A position, that is only part of the text, is none.
+	wrapped := fmt.Errorf("%w - Invalid statement", errorAt(ErrNormal, 3, 4, "Expected ')'"))
+	testDiagnostic(newDiagnostic(wrapped), SEVERITY_ERROR, 3, 4, "error - Expected ')' - Invalid statement", t)
+	if !errors.Is(wrapped, ErrNormal) {
+		t.Errorf("Expected the error to wrap its sentinel")
+	}
+
+	text := fmt.Errorf("%wInvalid --> [1:2] - Unexpected", ErrCritical)
+	testDiagnostic(newDiagnostic(text), SEVERITY_ERROR, -1, -1, "Invalid --> [1:2] - Unexpected", t)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////
+// FORMAT
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+// formatSource parses a program and prints it again in a canonical form: One statement per line, blocks indented by
+// one tab and a single space around binary operators. Parentheses are kept where they were written. Comments are lost.
+// Formatting the output again does not change it anymore.
+func formatSource(src []byte) ([]byte, error) {
+	tokens, err := tokenizeAll(src)
+	if err != nil {
+		return nil, err
+	}
+	ast, diagnostics := parse(tokenChannel(tokens))
+	if diagnostics != nil {
+		return nil, diagnostics
+	}
+
+	var sb strings.Builder
+	formatBlock(&sb, ast.block, 0)
+	return []byte(sb.String()), nil
+}
+
+// formatBlock writes every statement of the block on its own line, indented by depth tabs
+func formatBlock(sb *strings.Builder, block Block, depth int) {
+	for _, st := range block.statements {
+		sb.WriteString(strings.Repeat("\t", depth))
+		formatStatement(sb, st, depth)
+		sb.WriteString("\n")
+	}
+}
+
+// formatBody writes ' {', the indented block and the closing '}' on its own line
+func formatBody(sb *strings.Builder, block Block, depth int) {
+	sb.WriteString(" {\n")
+	formatBlock(sb, block, depth+1)
+	sb.WriteString(strings.Repeat("\t", depth) + "}")
+}
+
+func formatStatement(sb *strings.Builder, st Statement, depth int) {
+	switch s := st.(type) {
+	case Assignment:
+		sb.WriteString(formatAssignment(s))
+	case ConstDeclaration:
+		fmt.Fprintf(sb, "const %v = %v", formatVariable(s.variable), formatExpression(s.expression))
+	case StructDeclaration:
+		fields := make([]string, 0, len(s.fields))
+		for _, f := range s.fields {
+			fields = append(fields, fmt.Sprintf("%v: %v", f.name, f.fType))
+		}
+		fmt.Fprintf(sb, "struct %v { %v }", s.variable.vName, strings.Join(fields, "; "))
+	case FieldAssignment:
+		fmt.Fprintf(sb, "%v = %v", formatExpression(s.field), formatExpression(s.expression))
+	case Condition:
+		formatCondition(sb, s, depth)
+	case Loop:
+		header := fmt.Sprintf("for %v; %v; %v", formatAssignment(s.assignment), formatExpressions(s.expressions),
+			formatAssignment(s.incrAssignment))
+		sb.WriteString(strings.TrimRight(header, " "))
+		formatBody(sb, s.block, depth)
+	case ExprStatement:
+		sb.WriteString(formatExpression(s.expression))
+	default:
+		// break, continue, labels and goto print themselves
+		fmt.Fprintf(sb, "%v", st)
+	}
+}
+
+// formatCondition writes an 'if'. An else block with just another condition is written as 'else if'.
+func formatCondition(sb *strings.Builder, c Condition, depth int) {
+	fmt.Fprintf(sb, "if %v", formatExpression(c.expression))
+	formatBody(sb, c.block, depth)
+
+	if c.elseBlock.statements == nil {
+		return
+	}
+	sb.WriteString(" else")
+	if elseIf, ok := c.elseBlock.statements[0].(Condition); ok && len(c.elseBlock.statements) == 1 {
+		sb.WriteString(" ")
+		formatCondition(sb, elseIf, depth)
+		return
+	}
+	formatBody(sb, c.elseBlock, depth)
+}
+
+// formatAssignment writes an assignment the way it was written. 'i++' and 'i += exp' are desugared by the parser,
+// so their shorthand is used again. The empty assignment of a loop header is an empty string.
+func formatAssignment(a Assignment) string {
+	if len(a.variables) == 0 {
+		return ""
+	}
+	switch a.shorthand {
+	case "":
+	case "++", "--":
+		return a.variables[0].vName + a.shorthand
+	default:
+		// The parser wrapped the value in parentheses, as it is the whole right operand
+		value := a.expressions[0].(BinaryOp).rightExpr
+		if b, ok := value.(BinaryOp); ok {
+			b.fixed = false
+			value = b
+		}
+		return fmt.Sprintf("%v %v %v", a.variables[0].vName, a.shorthand, formatExpression(value))
+	}
+
+	variables := make([]string, 0, len(a.variables))
+	for _, v := range a.variables {
+		variables = append(variables, formatVariable(v))
+	}
+	return fmt.Sprintf("%v = %v", strings.Join(variables, ", "), formatExpressions(a.expressions))
+}
+
+// formatVariable writes a variable as it is declared: '[shadow] name [type]'
+func formatVariable(v Variable) string {
+	s := v.vName
+	if v.vShadow {
+		s = "shadow " + s
+	}
+	if v.vType != TYPE_UNKNOWN {
+		s += " " + v.vType.String()
+	}
+	return s
+}
+
+func formatExpressions(expressions []Expression) string {
+	parts := make([]string, 0, len(expressions))
+	for _, e := range expressions {
+		parts = append(parts, formatExpression(e))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatExpression(expression Expression) string {
+	switch e := expression.(type) {
+	case Variable:
+		return e.vName
+	case Constant:
+		if e.cType == TYPE_STRING {
+			return encodeString(e.cValue)
+		}
+		return e.cValue
+	case BinaryOp:
+		left := formatExpression(e.leftExpr)
+		// '(-2) ** 2' is 4, but '-2 ** 2' is -4
+		if c, ok := e.leftExpr.(Constant); ok && e.operator == OP_POW && strings.HasPrefix(c.cValue, "-") {
+			left = "(" + left + ")"
+		}
+		s := fmt.Sprintf("%v %v %v", left, e.operator, formatExpression(e.rightExpr))
+		if e.fixed {
+			return "(" + s + ")"
+		}
+		return s
+	case UnaryOp:
+		operand := formatExpression(e.expr)
+		switch o := e.expr.(type) {
+		case Constant:
+			// '-(2)' must not become the constant '-2'
+			operand = "(" + operand + ")"
+		case BinaryOp:
+			// The constant of '-(2) ** 2' keeps its parentheses as well
+			if _, ok := o.leftExpr.(Constant); ok && !o.fixed {
+				operand = fmt.Sprintf("(%v) %v %v", formatExpression(o.leftExpr), o.operator, formatExpression(o.rightExpr))
+			}
+		}
+		// '- -a' must not become the operator '--'
+		if e.operator == OP_NEGATIVE && strings.HasPrefix(operand, "-") {
+			operand = " " + operand
+		}
+		s := e.operator.String() + operand
+		if e.fixed {
+			return "(" + s + ")"
+		}
+		return s
+	case FunctionCall:
+		return fmt.Sprintf("%v(%v)", e.name, formatExpressions(e.args))
+	case Index:
+		return fmt.Sprintf("%v[%v]", formatExpression(e.expr), formatExpression(e.index))
+	case FieldAccess:
+		return fmt.Sprintf("%v.%v", e.variable.vName, e.field)
+	case Conversion:
+		return fmt.Sprintf("%v(%v)", e.cType, formatExpression(e.expr))
+	}
+	return fmt.Sprintf("%v", expression)
+}
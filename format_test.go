@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+
+	var code []byte = []byte(`
+	a   =1+2*(3-a)
+	s = "x\ty\n"
+	for i=0;i<10  ;i++{
+	if i%2==0{print(i)} else if i>5 {
+		a+= i*2
+		} else { break }
+	}
+	for ; a > 0 ; { a-- ; continue }
+	struct p {x:int;y:float}
+	p.x=-(-a)
+	`)
+
+	expected := "a = 1 + 2 * (3 - a)\n" +
+		"s = \"x\\ty\\n\"\n" +
+		"for i = 0; i < 10; i++ {\n" +
+		"\tif i % 2 == 0 {\n" +
+		"\t\tprint(i)\n" +
+		"\t} else if i > 5 {\n" +
+		"\t\ta += i * 2\n" +
+		"\t} else {\n" +
+		"\t\tbreak\n" +
+		"\t}\n" +
+		"}\n" +
+		"for ; a > 0; {\n" +
+		"\ta--\n" +
+		"\tcontinue\n" +
+		"}\n" +
+		"struct p { x: int; y: float }\n" +
+		"p.x = -(-a)\n"
+
+	formatted, err := formatSource(code)
+	if err != nil {
+		t.Fatalf("Formatting error: %v", err)
+	}
+	if string(formatted) != expected {
+		t.Errorf("Expected\n%v\ngot\n%v", expected, string(formatted))
+	}
+
+	again, err := formatSource(formatted)
+	if err != nil {
+		t.Fatalf("Formatting the formatted program failed: %v", err)
+	}
+	if string(again) != string(formatted) {
+		t.Errorf("Formatting is not idempotent:\n%v\nbecame\n%v", string(formatted), string(again))
+	}
+}
+
+func TestFormatKeepsMeaning(t *testing.T) {
+
+	for code, expected := range map[string]string{
+		"a = -(2) ** 2": "a = -(2) ** 2\n",
+		"a = (-2) ** 2": "a = (-2) ** 2\n",
+		"a = -2 ** 2":   "a = -(2) ** 2\n",
+		"a = -(2) * 3":  "a = -(2) * 3\n",
+	} {
+		formatted, err := formatSource([]byte(code))
+		if err != nil {
+			t.Fatalf("Formatting error: %v", err)
+		}
+		if string(formatted) != expected {
+			t.Errorf("Expected '%v' to be formatted as '%v', got '%v'", code, expected, string(formatted))
+		}
+
+		if again, _ := formatSource(formatted); string(again) != string(formatted) {
+			t.Errorf("Formatting is not idempotent: '%v' became '%v'", string(formatted), string(again))
+		}
+
+		// Both fold to the same constant
+		before := testSemantic([]byte(code), t).block.statements[0].(Assignment).expressions[0]
+		after := testSemantic(formatted, t).block.statements[0].(Assignment).expressions[0]
+		if before.(Constant).cValue != after.(Constant).cValue {
+			t.Errorf("Formatting '%v' changed its value from %v to %v", code, before, after)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	if _, err := formatSource([]byte("if a {")); err == nil {
+		t.Errorf("Expected an error for an unclosed block")
+	}
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
 )
 
 const (
@@ -16,7 +23,12 @@ const (
 	TOKEN_PARENTHESIS_CLOSE
 	TOKEN_CURLY_OPEN
 	TOKEN_CURLY_CLOSE
+	TOKEN_BRACKET_OPEN
+	TOKEN_BRACKET_CLOSE
+	TOKEN_DOT
 	TOKEN_SEMICOLON
+	TOKEN_LABEL
+	TOKEN_INCREMENT
 	TOKEN_EOF
 	TOKEN_UNKNOWN
 )
@@ -52,8 +64,18 @@ func (t TokenType) String() string {
 		return "TOKEN_CURLY_OPEN"
 	case TOKEN_CURLY_CLOSE:
 		return "TOKEN_CURLY_CLOSE"
+	case TOKEN_BRACKET_OPEN:
+		return "TOKEN_BRACKET_OPEN"
+	case TOKEN_BRACKET_CLOSE:
+		return "TOKEN_BRACKET_CLOSE"
+	case TOKEN_DOT:
+		return "TOKEN_DOT"
 	case TOKEN_SEMICOLON:
 		return "TOKEN_SEMICOLON"
+	case TOKEN_LABEL:
+		return "TOKEN_LABEL"
+	case TOKEN_INCREMENT:
+		return "TOKEN_INCREMENT"
 	case TOKEN_EOF:
 		return "TOKEN_EOF"
 	}
@@ -64,6 +86,15 @@ func (t Token) String() string {
 	return fmt.Sprintf("(%v %v)", t.value, t.tokenType)
 }
 
+// errorString describes the token for error messages, e.g.: IDENTIFIER "foo"
+func (t Token) errorString() string {
+	name := strings.TrimPrefix(t.tokenType.String(), "TOKEN_")
+	if t.tokenType == TOKEN_EOF {
+		return name
+	}
+	return fmt.Sprintf("%v %q", name, t.value)
+}
+
 func parseByte(program []byte) (TokenType, bool) {
 
 	switch program[0] {
@@ -79,23 +110,77 @@ func parseByte(program []byte) (TokenType, bool) {
 		return TOKEN_CURLY_OPEN, true
 	case '}':
 		return TOKEN_CURLY_CLOSE, true
+	case '[':
+		return TOKEN_BRACKET_OPEN, true
+	case ']':
+		return TOKEN_BRACKET_CLOSE, true
+	case '.':
+		return TOKEN_DOT, true
 	}
 	return TOKEN_UNKNOWN, false
 }
 
-func tokenize(program []byte, tokens chan Token, err chan error) {
+// Number of tokens the lexer can run ahead of the parser
+const tokenBufferSize = 64
+
+// Names of variables and labels can not be longer
+const maxIdentifierLength = 255
+
+// Assigning to the blank identifier discards the value: 'a, _ = 1, 2'
+const blankIdentifier = "_"
+
+// lex runs the lexer in its own goroutine. stop must be called, once no more tokens are read (e.g. after a parse
+// error). Otherwise the lexer would block forever on sending the next token.
+func lex(program []byte) (tokens chan Token, err chan error, stop func()) {
+	tokens = make(chan Token, tokenBufferSize)
+	err = make(chan error, 1)
+	done := make(chan struct{})
+	go tokenize(program, tokens, err, done)
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() { close(done) })
+	}
+	return
+}
+
+// tokenize sends all tokens of the program, followed by a TOKEN_EOF. Errors are sent to err right before the TOKEN_EOF.
+// Closing done stops the lexer early.
+func tokenize(program []byte, tokens chan Token, err chan error, done <-chan struct{}) {
+	send := func(t Token) bool {
+		select {
+		case tokens <- t:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
 	// Whitespace is just: \s without the \n, so we can track the line count explicitely.
 	whitespace := regexp.MustCompile(`^[\t\f\r ]`)
 	newline := regexp.MustCompile(`^\n`)
-	comment := regexp.MustCompile(`^//.*\n`)
-	keyword := regexp.MustCompile(`^(int|string|float|if|else|for|shadow) `)
-	operator := regexp.MustCompile(`^(\+|\-|\*|/|==|!=|<=|>=|<|>|\|\||&&|!)`)
-	assignment := regexp.MustCompile(`^=`)
-	constant := regexp.MustCompile(`^(((-?\d+(\.\d+)?)|(".*"))|(true|false))`)
-	identifier := regexp.MustCompile(`^[A-Za-z]\w*`)
+	// A comment ends before the newline. So the newline is counted as usual and a comment can end the program as well.
+	comment := regexp.MustCompile(`^//.*`)
+	keyword := regexp.MustCompile(`^(int|string|float|if|else|for|shadow|const|break|continue|goto|struct)\b`)
+	operator := regexp.MustCompile(`^(\*\*|\+|\-|\*|/|%|==|!=|<<|<=|>=|<|>|\|\||&&|!|~)`)
+	// '+=', '-=', ... are assignments as well. '==' is longer as operator
+	assignment := regexp.MustCompile(`^(=|\+=|-=|\*=|/=|%=)`)
+	// '++' and '--' only directly follow a variable and end the statement. Otherwise '5 -- 3' and 'x--3' stay a
+	// subtraction of a negative number.
+	increment := regexp.MustCompile(`^(\+\+|--)[\t\f\r ]*($|[\n;{}]|//)`)
+	// Numbers are matched generously ('_' anywhere, '0x' without digits, ...) and checked in decodeNumber.
+	// This way, an invalid number gets a clear error message.
+	constant := regexp.MustCompile(`^(((-?(0x[0-9A-Fa-f_]*|[\d_]+(\.[\d_]+)?)u?)|("(\\.|[^"\\\n])*"))|(true|false))`)
+	identifier := regexp.MustCompile(`^([A-Za-z]\w*|_\b)`)
+	// '_' on its own is no number with a digit separator
+	blank := regexp.MustCompile(`^_\b`)
+	// A label definition is a name directly followed by ':'
+	label := regexp.MustCompile(`^[A-Za-z]\w*:`)
 
 	lineCnt := 0
 	colCnt := 0
+	// A '-' right after an operand is always the binary operator: 'j-1' is 'j', '-', '1' and not 'j', '-1'
+	lastType := TokenType(TOKEN_UNKNOWN)
 
 	for len(program) > 0 {
 
@@ -114,8 +199,7 @@ func tokenize(program []byte, tokens chan Token, err chan error) {
 		// Comments also have high priority to be ignored :)
 		if s := comment.FindIndex(program); s != nil {
 			program = program[s[1]:]
-			lineCnt++
-			colCnt = 0
+			colCnt += s[1]
 			continue
 		}
 
@@ -128,21 +212,31 @@ func tokenize(program []byte, tokens chan Token, err chan error) {
 		}
 
 		if s := keyword.FindIndex(program); s != nil && s[1] > tokenLength {
-			tokenLength = s[1] - 1
+			tokenLength = s[1]
 			tokenType = TOKEN_KEYWORD
 		}
 		if s := operator.FindIndex(program); s != nil && s[1] > tokenLength {
 			tokenLength = s[1]
 			tokenType = TOKEN_OPERATOR
 		}
+		if s := increment.FindSubmatchIndex(program); s != nil && s[3] > tokenLength && lastType == TOKEN_IDENTIFIER {
+			tokenLength = s[3]
+			tokenType = TOKEN_INCREMENT
+		}
 		if s := assignment.FindIndex(program); s != nil && s[1] > tokenLength {
 			tokenLength = s[1]
 			tokenType = TOKEN_ASSIGNMENT
 		}
-		if s := constant.FindIndex(program); s != nil && s[1] > tokenLength {
+		afterOperand := lastType == TOKEN_IDENTIFIER || lastType == TOKEN_CONSTANT || lastType == TOKEN_PARENTHESIS_CLOSE ||
+			lastType == TOKEN_BRACKET_CLOSE
+		if s := constant.FindIndex(program); s != nil && s[1] > tokenLength && !(afterOperand && program[0] == '-') && !blank.Match(program) {
 			tokenLength = s[1]
 			tokenType = TOKEN_CONSTANT
 		}
+		if s := label.FindIndex(program); s != nil && s[1] > tokenLength {
+			tokenLength = s[1]
+			tokenType = TOKEN_LABEL
+		}
 		// Lowest priority for parsing!
 		if s := identifier.FindIndex(program); s != nil && s[1] > tokenLength {
 			tokenLength = s[1]
@@ -151,14 +245,218 @@ func tokenize(program []byte, tokens chan Token, err chan error) {
 
 		if tokenLength == 0 {
 			err <- fmt.Errorf("[%v:%v] - Unknown string", lineCnt, colCnt)
-			tokens <- Token{TOKEN_EOF, "", lineCnt, colCnt}
+			send(Token{TOKEN_EOF, "", lineCnt, colCnt})
 			return
 		}
 
-		tokens <- Token{tokenType, string(program[:tokenLength]), lineCnt, colCnt}
+		// Labels include the ':'
+		if (tokenType == TOKEN_IDENTIFIER || tokenType == TOKEN_LABEL) && len(strings.TrimSuffix(string(program[:tokenLength]), ":")) > maxIdentifierLength {
+			err <- fmt.Errorf("[%v:%v] - Name is longer than %v characters", lineCnt, colCnt, maxIdentifierLength)
+			send(Token{TOKEN_EOF, "", lineCnt, colCnt})
+			return
+		}
+
+		value := string(program[:tokenLength])
+		if tokenType == TOKEN_CONSTANT && program[0] == '"' {
+			decoded, offset, decodeErr := decodeString(value)
+			if decodeErr != nil {
+				err <- fmt.Errorf("[%v:%v] - %v", lineCnt, colCnt+offset, decodeErr)
+				send(Token{TOKEN_EOF, "", lineCnt, colCnt})
+				return
+			}
+			value = decoded
+		}
+		if tokenType == TOKEN_CONSTANT && (program[0] == '-' || program[0] == '_' || unicode.IsDigit(rune(program[0]))) {
+			decoded, offset, decodeErr := decodeNumber(value)
+			if decodeErr != nil {
+				err <- fmt.Errorf("[%v:%v] - %v", lineCnt, colCnt+offset, decodeErr)
+				send(Token{TOKEN_EOF, "", lineCnt, colCnt})
+				return
+			}
+			value = decoded
+		}
+
+		if !send(Token{tokenType, value, lineCnt, colCnt}) {
+			return
+		}
+		lastType = tokenType
 		program = program[tokenLength:]
 		colCnt += tokenLength
 	}
 
-	tokens <- Token{TOKEN_EOF, "", lineCnt, colCnt}
+	send(Token{TOKEN_EOF, "", lineCnt, colCnt})
+}
+
+// decodeNumber removes the '_' digit separators from a number literal and converts hex numbers (0x...) to decimal.
+// A '_' is only allowed between two digits. For an invalid literal, the offset of the problem within it is returned.
+func decodeNumber(literal string) (string, int, error) {
+	s := literal
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = s[1:]
+	}
+	suffix := ""
+	if strings.HasSuffix(s, "u") {
+		suffix = "u"
+		s = s[:len(s)-1]
+		if sign != "" {
+			return "", 0, fmt.Errorf("Unsigned number '%v' can not be negative", literal)
+		}
+	}
+	offset := len(sign)
+
+	isDigit := func(c byte) bool {
+		return c >= '0' && c <= '9'
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") {
+		base = 16
+		s = s[2:]
+		offset += 2
+		if s == "" {
+			return "", offset, fmt.Errorf("Expected hex digits after '0x' in '%v'", literal)
+		}
+		isDigit = func(c byte) bool {
+			return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && (i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1])) {
+			return "", offset + i, fmt.Errorf("Invalid '_' in number '%v'. It is only allowed between two digits", literal)
+		}
+	}
+	s = strings.ReplaceAll(s, "_", "")
+
+	if suffix != "" && strings.Contains(s, ".") {
+		return "", 0, fmt.Errorf("Unsigned number '%v' must be an integer", literal)
+	}
+
+	if base == 16 {
+		v, parseErr := strconv.ParseUint(s, 16, 64)
+		if parseErr != nil || (suffix == "" && v > math.MaxInt64) {
+			return "", 0, fmt.Errorf("Number '%v' is out of range", literal)
+		}
+		s = strconv.FormatUint(v, 10)
+	}
+
+	return sign + s + suffix, 0, nil
+}
+
+// decodeString replaces all escape sequences in a string literal (including its quotes) by the bytes they stand for:
+// \n, \t, \\, \", \xNN (one byte) and \uNNNN (a unicode code point, UTF-8 encoded).
+// For an invalid escape sequence, the offset of its '\' within the literal is returned.
+func decodeString(literal string) (string, int, error) {
+	var sb strings.Builder
+	sb.WriteByte('"')
+
+	s := literal[1 : len(literal)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		offset := i + 1
+
+		if i+1 >= len(s) {
+			return "", offset, fmt.Errorf("Invalid escape sequence at the end of the string")
+		}
+		switch s[i+1] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case '\\':
+			sb.WriteByte('\\')
+		case '"':
+			sb.WriteByte('"')
+		case 'x', 'u':
+			digits := 2
+			if s[i+1] == 'u' {
+				digits = 4
+			}
+			end := i + 2 + digits
+			if end > len(s) {
+				return "", offset, fmt.Errorf("Invalid escape sequence '%v'. Expected %v hex digits", s[i:], digits)
+			}
+			v, parseErr := strconv.ParseUint(s[i+2:end], 16, 32)
+			if parseErr != nil {
+				return "", offset, fmt.Errorf("Invalid escape sequence '%v'. Expected %v hex digits", s[i:end], digits)
+			}
+			if s[i+1] == 'x' {
+				sb.WriteByte(byte(v))
+			} else {
+				if utf16.IsSurrogate(rune(v)) {
+					return "", offset, fmt.Errorf("Invalid escape sequence '%v'. Surrogates are no valid code points", s[i:end])
+				}
+				sb.WriteRune(rune(v))
+			}
+			i = end - 1
+			continue
+		default:
+			return "", offset, fmt.Errorf("Unknown escape sequence '%v'", s[i:i+2])
+		}
+		i++
+	}
+
+	sb.WriteByte('"')
+	return sb.String(), 0, nil
+}
+
+// encodeString is the inverse of decodeString. Characters, that can not be written in a literal, are escaped.
+func encodeString(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+
+	s := value[1 : len(value)-1]
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '"':
+			sb.WriteString(`\"`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// tokenizeAll runs the lexer on the whole program and collects all tokens including the final TOKEN_EOF
+func tokenizeAll(program []byte) ([]Token, error) {
+	tokenChan, lexerErr, stop := lex(program)
+	defer stop()
+
+	var tokens []Token
+	for {
+		t := <-tokenChan
+		tokens = append(tokens, t)
+		if t.tokenType == TOKEN_EOF {
+			break
+		}
+	}
+
+	// The lexer reports errors before sending the final TOKEN_EOF
+	select {
+	case e := <-lexerErr:
+		return tokens, e
+	default:
+	}
+	return tokens, nil
+}
+
+// dumpTokens prints one token per line with its position, type and value
+func dumpTokens(w io.Writer, tokens []Token) {
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%v:%v\t%v\t%q\n", t.line, t.column, t.tokenType, t.value)
+	}
 }
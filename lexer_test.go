@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testChannelEqualSlice(tokens chan Token, expected []Token) (bool, int, Token, Token) {
@@ -14,9 +18,8 @@ func testChannelEqualSlice(tokens chan Token, expected []Token) (bool, int, Toke
 }
 
 func testTokens(code []byte, expect []Token, t *testing.T) {
-	tokenChan := make(chan Token, 100)
-	lexerErr := make(chan error, 1)
-	go tokenize(code, tokenChan, lexerErr)
+	tokenChan, lexerErr, stop := lex(code)
+	defer stop()
 
 	select {
 	case e := <-lexerErr:
@@ -173,3 +176,341 @@ func TestLexerFor3(t *testing.T) {
 
 	testTokens(code, expect, t)
 }
+
+func TestLexerDumpTokens(t *testing.T) {
+
+	var code []byte = []byte(`a = 1
+if a {}`)
+
+	tokens, err := tokenizeAll(code)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var b bytes.Buffer
+	dumpTokens(&b, tokens)
+
+	expected := `0:0	TOKEN_IDENTIFIER	"a"
+0:2	TOKEN_ASSIGNMENT	"="
+0:4	TOKEN_CONSTANT	"1"
+1:0	TOKEN_KEYWORD	"if"
+1:3	TOKEN_IDENTIFIER	"a"
+1:5	TOKEN_CURLY_OPEN	"{"
+1:6	TOKEN_CURLY_CLOSE	"}"
+1:7	TOKEN_EOF	""
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expected, b.String())
+	}
+}
+
+func TestLexerMinusAfterOperand(t *testing.T) {
+
+	var code []byte = []byte(`j-1 + (2)-3 - -4`)
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "j", 0, 0}, Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "1", 0, 0},
+		Token{TOKEN_OPERATOR, "+", 0, 0}, Token{TOKEN_PARENTHESIS_OPEN, "(", 0, 0}, Token{TOKEN_CONSTANT, "2", 0, 0},
+		Token{TOKEN_PARENTHESIS_CLOSE, ")", 0, 0}, Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "3", 0, 0},
+		Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "-4", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerStringComparison(t *testing.T) {
+
+	var code []byte = []byte(`"a" == "b"`)
+
+	expect := []Token{Token{TOKEN_CONSTANT, "\"a\"", 0, 0}, Token{TOKEN_OPERATOR, "==", 0, 0}, Token{TOKEN_CONSTANT, "\"b\"", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerIncrement(t *testing.T) {
+
+	// '++' and '--' have to end the statement. 'x--3' is a subtraction.
+	var code []byte = []byte("i++; j-- \n5--3\nx--3 {k++}")
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "i", 0, 0}, Token{TOKEN_INCREMENT, "++", 0, 0}, Token{TOKEN_SEMICOLON, ";", 0, 0},
+		Token{TOKEN_IDENTIFIER, "j", 0, 0}, Token{TOKEN_INCREMENT, "--", 0, 0},
+		Token{TOKEN_CONSTANT, "5", 0, 0}, Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "-3", 0, 0},
+		Token{TOKEN_IDENTIFIER, "x", 0, 0}, Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "-3", 0, 0},
+		Token{TOKEN_CURLY_OPEN, "{", 0, 0}, Token{TOKEN_IDENTIFIER, "k", 0, 0}, Token{TOKEN_INCREMENT, "++", 0, 0},
+		Token{TOKEN_CURLY_CLOSE, "}", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+// Two character operators need both characters right next to each other
+func TestLexerTwoCharOperators(t *testing.T) {
+
+	testTokens([]byte(`a <= b`), []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_OPERATOR, "<=", 0, 0}, Token{TOKEN_IDENTIFIER, "b", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0}}, t)
+	testTokens([]byte(`a < = b`), []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_OPERATOR, "<", 0, 0}, Token{TOKEN_ASSIGNMENT, "=", 0, 0},
+		Token{TOKEN_IDENTIFIER, "b", 0, 0}, Token{TOKEN_EOF, "", 0, 0}}, t)
+	testTokens([]byte(`a == b`), []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_OPERATOR, "==", 0, 0}, Token{TOKEN_IDENTIFIER, "b", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0}}, t)
+	testTokens([]byte(`a = = b`), []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_ASSIGNMENT, "=", 0, 0}, Token{TOKEN_ASSIGNMENT, "=", 0, 0},
+		Token{TOKEN_IDENTIFIER, "b", 0, 0}, Token{TOKEN_EOF, "", 0, 0}}, t)
+
+	// There is no single '&'
+	if _, err := tokenizeAll([]byte(`a & & b`)); err == nil || err.Error() != "[0:2] - Unknown string" {
+		t.Errorf("Expected error for separated '&&', got: %v", err)
+	}
+}
+
+func TestLexerStringEscapes(t *testing.T) {
+
+	var code []byte = []byte(`"\x41ä\n\"\\"`)
+
+	expect := []Token{Token{TOKEN_CONSTANT, "\"Aä\n\"\\\"", 0, 0}, Token{TOKEN_EOF, "", 0, 0}}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerStringEscapesInvalid(t *testing.T) {
+
+	for code, expected := range map[string]string{
+		`a = "ab\x4"`:  `[0:7] - Invalid escape sequence '\x4'. Expected 2 hex digits`,
+		`a = "\xg1"`:   `[0:5] - Invalid escape sequence '\xg1'. Expected 2 hex digits`,
+		`a = "\uD800"`: `[0:5] - Invalid escape sequence '\uD800'. Surrogates are no valid code points`,
+		`a = "x\q"`:    `[0:6] - Unknown escape sequence '\q'`,
+	} {
+		if _, err := tokenizeAll([]byte(code)); err == nil || err.Error() != expected {
+			t.Errorf("Expected error '%v' for %v, got: %v", expected, code, err)
+		}
+	}
+}
+
+func TestLexerStopsAfterParseError(t *testing.T) {
+
+	// Much more tokens than the channel buffers. Parsing stops at the first line.
+	code := []byte("a = = 1\n" + strings.Repeat("b = 1\n", 10*tokenBufferSize))
+
+	before := runtime.NumGoroutine()
+
+	tokenChan, _, stop := lex(code)
+	if _, err := parse(tokenChan); err == nil {
+		t.Fatalf("Expected a parse error")
+	}
+	stop()
+
+	testLexerStopped(before, t)
+}
+
+// testLexerStopped waits until the lexer goroutine is gone. before is the number of goroutines before lex was called.
+func testLexerStopped(before int, t *testing.T) {
+	// The lexer goroutine needs a moment to notice
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Lexer goroutine is still running: %v goroutines before, %v after", before, n)
+	}
+}
+
+func TestLexerNumberSeparators(t *testing.T) {
+
+	var code []byte = []byte(`-0x10 1_000_000 0xFF_FF 1_000.5 0x1_0u`)
+
+	expect := []Token{Token{TOKEN_CONSTANT, "-16", 0, 0}, Token{TOKEN_CONSTANT, "1000000", 0, 0}, Token{TOKEN_CONSTANT, "65535", 0, 0},
+		Token{TOKEN_CONSTANT, "1000.5", 0, 0}, Token{TOKEN_CONSTANT, "16u", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerNumberSeparatorsInvalid(t *testing.T) {
+
+	for code, expected := range map[string]string{
+		`a = _1`:     `[0:4] - Invalid '_' in number '_1'. It is only allowed between two digits`,
+		`a = 1_`:     `[0:5] - Invalid '_' in number '1_'. It is only allowed between two digits`,
+		`a = 1__2`:   `[0:5] - Invalid '_' in number '1__2'. It is only allowed between two digits`,
+		`a = 0x_FF`:  `[0:6] - Invalid '_' in number '0x_FF'. It is only allowed between two digits`,
+		`a = 1_.5`:   `[0:5] - Invalid '_' in number '1_.5'. It is only allowed between two digits`,
+		`a = 0x`:     `[0:6] - Expected hex digits after '0x' in '0x'`,
+		`a = -1u`:    `[0:4] - Unsigned number '-1u' can not be negative`,
+		`a = 0x1_0_`: `[0:9] - Invalid '_' in number '0x1_0_'. It is only allowed between two digits`,
+	} {
+		if _, err := tokenizeAll([]byte(code)); err == nil || err.Error() != expected {
+			t.Errorf("Expected error '%v' for %v, got: %v", expected, code, err)
+		}
+	}
+}
+
+func TestLexerCommentAtEnd(t *testing.T) {
+
+	var code []byte = []byte("a = 1 // one\n// the end")
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_ASSIGNMENT, "=", 0, 0}, Token{TOKEN_CONSTANT, "1", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerIndex(t *testing.T) {
+
+	var code []byte = []byte(`s[i]-1`)
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "s", 0, 0}, Token{TOKEN_BRACKET_OPEN, "[", 0, 0}, Token{TOKEN_IDENTIFIER, "i", 0, 0},
+		Token{TOKEN_BRACKET_CLOSE, "]", 0, 0}, Token{TOKEN_OPERATOR, "-", 0, 0}, Token{TOKEN_CONSTANT, "1", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerCompoundAssignment(t *testing.T) {
+
+	var code []byte = []byte(`a+=-1 b%=2 c==d`)
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "a", 0, 0}, Token{TOKEN_ASSIGNMENT, "+=", 0, 0}, Token{TOKEN_CONSTANT, "-1", 0, 0},
+		Token{TOKEN_IDENTIFIER, "b", 0, 0}, Token{TOKEN_ASSIGNMENT, "%=", 0, 0}, Token{TOKEN_CONSTANT, "2", 0, 0},
+		Token{TOKEN_IDENTIFIER, "c", 0, 0}, Token{TOKEN_OPERATOR, "==", 0, 0}, Token{TOKEN_IDENTIFIER, "d", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerBlankIdentifier(t *testing.T) {
+
+	var code []byte = []byte(`_, b = 1_0, _`)
+
+	expect := []Token{Token{TOKEN_IDENTIFIER, "_", 0, 0}, Token{TOKEN_SEPARATOR, ",", 0, 0}, Token{TOKEN_IDENTIFIER, "b", 0, 0},
+		Token{TOKEN_ASSIGNMENT, "=", 0, 0}, Token{TOKEN_CONSTANT, "10", 0, 0}, Token{TOKEN_SEPARATOR, ",", 0, 0},
+		Token{TOKEN_IDENTIFIER, "_", 0, 0}, Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+// Every operator of more than one character is a single token, never split into its characters
+func TestLexerMultiCharOperators(t *testing.T) {
+
+	for code, expected := range map[string]Token{
+		"a <= b": {TOKEN_OPERATOR, "<=", 0, 2},
+		"a >= b": {TOKEN_OPERATOR, ">=", 0, 2},
+		"a == b": {TOKEN_OPERATOR, "==", 0, 2},
+		"a != b": {TOKEN_OPERATOR, "!=", 0, 2},
+		"a && b": {TOKEN_OPERATOR, "&&", 0, 2},
+		"a || b": {TOKEN_OPERATOR, "||", 0, 2},
+		"a ** b": {TOKEN_OPERATOR, "**", 0, 2},
+		"a << b": {TOKEN_OPERATOR, "<<", 0, 2},
+		"a += b": {TOKEN_ASSIGNMENT, "+=", 0, 2},
+		"a %= b": {TOKEN_ASSIGNMENT, "%=", 0, 2},
+		"a ++ ;": {TOKEN_INCREMENT, "++", 0, 2},
+		"a -- }": {TOKEN_INCREMENT, "--", 0, 2},
+	} {
+		tokens, err := tokenizeAll([]byte(code))
+		if err != nil {
+			t.Fatalf("Unexpected error for '%v': %v", code, err)
+		}
+		if len(tokens) != 4 || tokens[1] != expected {
+			t.Errorf("Expected '%v' to be the single token %v, got: %v", code, expected, tokens)
+		}
+	}
+}
+
+func TestLexerStruct(t *testing.T) {
+
+	var code []byte = []byte(`struct p { x: float }; p.x = 1.5`)
+
+	expect := []Token{Token{TOKEN_KEYWORD, "struct", 0, 0}, Token{TOKEN_IDENTIFIER, "p", 0, 0}, Token{TOKEN_CURLY_OPEN, "{", 0, 0},
+		Token{TOKEN_LABEL, "x:", 0, 0}, Token{TOKEN_KEYWORD, "float", 0, 0}, Token{TOKEN_CURLY_CLOSE, "}", 0, 0},
+		Token{TOKEN_SEMICOLON, ";", 0, 0}, Token{TOKEN_IDENTIFIER, "p", 0, 0}, Token{TOKEN_DOT, ".", 0, 0},
+		Token{TOKEN_IDENTIFIER, "x", 0, 0}, Token{TOKEN_ASSIGNMENT, "=", 0, 0}, Token{TOKEN_CONSTANT, "1.5", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerKeywordsAreNoIdentifiers(t *testing.T) {
+
+	var code []byte = []byte(`if iff shadow shadowed true trueish`)
+
+	expect := []Token{Token{TOKEN_KEYWORD, "if", 0, 0}, Token{TOKEN_IDENTIFIER, "iff", 0, 0}, Token{TOKEN_KEYWORD, "shadow", 0, 0},
+		Token{TOKEN_IDENTIFIER, "shadowed", 0, 0}, Token{TOKEN_CONSTANT, "true", 0, 0}, Token{TOKEN_IDENTIFIER, "trueish", 0, 0},
+		Token{TOKEN_EOF, "", 0, 0},
+	}
+
+	testTokens(code, expect, t)
+}
+
+func TestLexerIdentifierLength(t *testing.T) {
+
+	name := strings.Repeat("a", maxIdentifierLength)
+	if _, err := tokenizeAll([]byte(name + " = 1\n" + name + ":")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expected := "[1:2] - Name is longer than 255 characters"
+	for _, code := range []string{"a = 1\nb " + name + "a", "a = 1\nb " + name + "a:"} {
+		if _, err := tokenizeAll([]byte(code)); err == nil || err.Error() != expected {
+			t.Errorf("Expected error '%v', got: %v", expected, err)
+		}
+	}
+}
+
+// fuzzSeeds are programs of the other tests. They cover every token and statement.
+var fuzzSeeds = []string{
+	`6 + 7 * variable / -(5 -- (-8 * - 10000.1234))`,
+	`a && b || (5 < false <= 8 && (false2 > variable >= 5.0) != true)`,
+	"a = 1 // one\n// the end",
+	`-0x10 1_000_000 0xFF_FF 1_000.5 0x1_0u`,
+	"a = \"x\\n\\\"y\\\"\"\nb = len(a) + 1\nprint(a)",
+	"for i = 0; i < 10; i++ {\n\tif i == 5 {\n\t\tbreak\n\t}\n\tcontinue\n}",
+	"if a == 1 {\n\tb = 1\n} else if a == 2 {\n\tb = 2\n} else {\n\tb = 3\n}",
+	"const c = 2 ** 3\nshadow a = c\na, b = b, a\na = b = 0",
+	"start:\ni = i + 1\nif i < 3 {\n\tgoto start\n}",
+	"s = \"hello\"\na = s[1] + s[argc]\nexit(a % 256)",
+	"a = (1\n+ 2)\nb = 1 +\n2",
+	"a = = 1\n}",
+	"for ;; {}",
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, code []byte) {
+		before := runtime.NumGoroutine()
+
+		tokens, err := tokenizeAll(code)
+		if err == nil && tokens[len(tokens)-1].tokenType != TOKEN_EOF {
+			t.Errorf("Expected the tokens to end with EOF, got: %v", tokens)
+		}
+
+		testLexerStopped(before, t)
+	})
+}
+
+// A 64KB string literal is a single token with all escape sequences decoded
+func TestLexerLongString(t *testing.T) {
+	literal := strings.Repeat(`abcd\x01ef\n`, 8192)
+	code := []byte("a = \"" + literal + "\"")
+
+	tokens, err := tokenizeAll(code)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tokens) != 4 {
+		t.Fatalf("Expected 4 tokens, got %v", len(tokens))
+	}
+	expected := "\"" + strings.Repeat("abcd\x01ef\n", 8192) + "\""
+	if tokens[2].tokenType != TOKEN_CONSTANT || tokens[2].value != expected {
+		t.Errorf("Expected the decoded string as constant, got %v with %v bytes", tokens[2].tokenType, len(tokens[2].value))
+	}
+}
+
+func BenchmarkLexerLongString(b *testing.B) {
+	code := []byte("a = \"" + strings.Repeat("x", 64*1024) + "\"")
+	for i := 0; i < b.N; i++ {
+		if _, err := tokenizeAll(code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
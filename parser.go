@@ -4,33 +4,74 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 /*
 
 
-stat 	::= assign | if | for
+block	::= {stat (newline | ';')}
+stat 	::= assign | const | struct | if | for | 'break' | 'continue' | label | goto | call
 
-if 		::= 'if' exp '{' [stat] '}' [else '{' [stat] '}']
-for		::= 'for' [assign] ';' [explist] ';' [assign] '{' [stat] '}'
+if 		::= 'if' exp '{' [stat] '}' [else ('{' [stat] '}' | if)]
+for		::= 'for' [assign] ';' [exp] ';' [assign] '{' [stat] '}'
 
 
-assign 	::= varlist ‘=’ explist
+assign 	::= varlist ‘=’ {varlist ‘=’} explist | Name '++' | Name '--' | Name asgnop exp | field '=' exp
+asgnop	::= '+=' | '-=' | '*=' | '/=' | '%='
+const	::= 'const' Name [type] '=' exp
+struct	::= 'struct' Name '{' {Name ':' type (newline | ';')} '}'
+label	::= Name ':'
+goto	::= 'goto' Name
 varlist	::= var {‘,’ var}
 explist	::= exp {‘,’ exp}
-exp 	::= Numeral | String | var | '(' exp ')' | exp binop exp | unop exp
-var 	::= [shadow] Name
-binop	::= '+' | '-' | '*' | '/' | '==' | '!=' | '<=' | '>=' | '<' | '>' | '&&' | '||'
-unop	::= '-' | '!'
+exp 	::= Numeral | String | var | field | call | '(' exp ')' | exp '[' exp ']' | exp binop exp | unop exp
+var 	::= [shadow] Name [type]
+field	::= Name '.' Name
+type	::= 'int' | 'uint' | 'float' | 'bool' | 'string'
+call	::= Name '(' [explist] ')'
+binop	::= '**' | '+' | '-' | '*' | '/' | '%' | '==' | '!=' | '<=' | '>=' | '<' | '>' | '&&' | '||'
+unop	::= '-' | '!' | '~'
 
 
 Operator priority (Descending priority!):
 
-1: 	'*', '/'
+0:	'**' (right associative: 2 ** 3 ** 2 == 2 ** 9)
+1: 	'*', '/', '%'
 2: 	'+', '-'
 3:	'==', '!=', '<=', '>=', '<', '>'
 4:	'&&', '||'
 
+Numerals can be hex (0xFF) and use '_' between digits (1_000_000). A 'u' suffix makes them unsigned (5u).
+
+The predefined variable argc is the number of program arguments (including the program name). The builtin
+exit(code) flushes the output and ends the program with the exit code. A constant code must be in 0..255.
+min(a, b) and max(a, b) take two numbers of the same type.
+
+A shadowed variable lives until the end of its block. In a loop body, every iteration starts with the outer variable again.
+
+A chained assignment 'a = b = exp' assigns right to left, like 'b = exp; a = b'.
+
+A newline ends a statement, except inside parentheses/brackets or directly after a binary operator. So 'a = 1 +\n 2' and
+'a = (1\n + 2)' are one statement each. But an operator can not start a new line and '=' needs its value on the same line.
+
+Unary '-', '!' and '~' bind tighter than all binary operators except '**': -a * b == (-a) * b, but -a ** 2 == -(a ** 2).
+'~' flips all bits of an int or uint: ~0 == -1.
+
+A type after the name of a variable or constant pins its type: 'a float = 1.0' or 'const n uint = 5u'. A value of
+another type is an error.
+
+'struct p { x: int; y: float }' declares the struct variable p. Its fields start with the zero value of their type
+(0, 0u, 0.0, false, ""). A struct is only used through its fields: 'p.x = 1' and 'a = p.x'.
+
+Indexing a string 's[i]' reads its byte i as an int (0..255). The index is checked against the length of the string.
+A constant index out of range is an error, otherwise the program aborts with a message.
+
+Integer '/' and '%' truncate toward zero (like C and Go): -7 / 2 == -3 and -7 % 2 == -1.
+The sign of a remainder always follows the left operand.
+Integer '**' with a negative exponent truncates like '/': 2 ** -1 == 0, but 1 ** -1 == 1 and (-1) ** -1 == -1.
+Float comparisons follow IEEE 754: Every comparison with NaN is false, except '!=', which is true.
+
 */
 
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -43,6 +84,12 @@ const (
 	TYPE_FLOAT
 	TYPE_BOOL
 	// TYPE_FUNCTION ?
+	// Unsigned integers are written with a 'u' suffix: 5u
+	TYPE_UINT
+	// The type of a struct variable. Its fields are in the symbol table.
+	TYPE_STRUCT
+	// The 'type' of a function call, that does not return anything
+	TYPE_VOID
 	TYPE_UNKNOWN
 )
 const (
@@ -50,9 +97,13 @@ const (
 	OP_MINUS
 	OP_MULT
 	OP_DIV
+	OP_MOD
+	OP_POW
 
 	OP_NEGATIVE
 	OP_NOT
+	// '~' flips all bits of an int or uint
+	OP_BITNOT
 
 	OP_EQ
 	OP_NE
@@ -71,6 +122,9 @@ const (
 // INTERFACES
 /////////////////////////////////////////////////////////////////////////////////////////////////
 
+// Every parse error wraps one of these. ErrNormal means, that the tokens just don't match the tried rule. Nothing
+// relevant was consumed and the caller can try another rule (or end the statement list). ErrCritical means, that the
+// program is invalid, e.g. a '}' is missing after a started block. Parsing stops right away.
 var (
 	ErrCritical = errors.New("")
 	ErrNormal   = errors.New("error - ")
@@ -79,6 +133,19 @@ var (
 type SymbolEntry struct {
 	sType   Type
 	varName string
+	// Constants are never assigned to a variable but substituted by their value
+	isConst    bool
+	constValue Constant
+	// The layout of a struct variable
+	fields []StructField
+	// Where the name is declared. Predefined names (argc) are declared nowhere in the source.
+	line, column int
+	predefined   bool
+	// unreachable is true for a name declared after a 'goto', 'break' or 'continue'. That code is removed, so the
+	// name has no value, until a reachable assignment in the same block.
+	unreachable bool
+	// used is true, once the value is read. See warnUnused.
+	used bool
 	// ... more information
 }
 
@@ -90,6 +157,7 @@ type SymbolTable struct {
 type AST struct {
 	block             Block
 	globalSymbolTable SymbolTable
+	warnings          []Diagnostic
 }
 
 type Type int
@@ -99,6 +167,8 @@ type Node interface {
 	// Notes the start position in the actual source code!
 	// (lineNr, columnNr)
 	startPos() (int, int)
+	// The direct child nodes in source order. See walk.
+	children() []Node
 	generateCode(asm *ASM, s *SymbolTable)
 }
 
@@ -141,16 +211,56 @@ type BinaryOp struct {
 	line, column int
 }
 type UnaryOp struct {
-	operator     Operator
+	operator Operator
+	expr     Expression
+	opType   Type
+	// fixed means, that the whole unary operation is in '(' ')'. This is only kept, so the original source
+	// can be reproduced and has no influence on code generation.
+	fixed        bool
+	line, column int
+}
+
+// Only builtin functions can be called for now. fType is the type of the returned value.
+type FunctionCall struct {
+	name         string
+	args         []Expression
+	fType        Type
+	line, column int
+}
+
+// Index reads a single byte of a string. iType is int after the semantic analysis.
+type Index struct {
+	expr         Expression
+	index        Expression
+	iType        Type
+	line, column int
+}
+
+// FieldAccess reads a field of a struct variable: 's.x'. fType and offset (in byte from the start of the struct) are
+// set by the semantic analysis.
+type FieldAccess struct {
+	variable     Variable
+	field        string
+	fType        Type
+	offset       int
+	line, column int
+}
+
+// Conversion converts a value to another type: 'float(i)', 'int(f)' (truncating), 'int(b)'
+type Conversion struct {
 	expr         Expression
-	opType       Type
+	cType        Type
 	line, column int
 }
 
-func (_ Variable) expression() {}
-func (_ Constant) expression() {}
-func (_ BinaryOp) expression() {}
-func (_ UnaryOp) expression()  {}
+func (_ Variable) expression()     {}
+func (_ Constant) expression()     {}
+func (_ BinaryOp) expression()     {}
+func (_ UnaryOp) expression()      {}
+func (_ FunctionCall) expression() {}
+func (_ Index) expression()        {}
+func (_ FieldAccess) expression()  {}
+func (_ Conversion) expression()   {}
 
 func (e Variable) startPos() (int, int) {
 	return e.line, e.column
@@ -164,6 +274,43 @@ func (e BinaryOp) startPos() (int, int) {
 func (e UnaryOp) startPos() (int, int) {
 	return e.line, e.column
 }
+func (e FunctionCall) startPos() (int, int) {
+	return e.line, e.column
+}
+func (e Index) startPos() (int, int) {
+	return e.line, e.column
+}
+func (e FieldAccess) startPos() (int, int) {
+	return e.line, e.column
+}
+func (e Conversion) startPos() (int, int) {
+	return e.line, e.column
+}
+
+func (e Variable) children() []Node {
+	return nil
+}
+func (e Constant) children() []Node {
+	return nil
+}
+func (e BinaryOp) children() []Node {
+	return []Node{e.leftExpr, e.rightExpr}
+}
+func (e UnaryOp) children() []Node {
+	return []Node{e.expr}
+}
+func (e FunctionCall) children() []Node {
+	return expressionNodes(e.args)
+}
+func (e Index) children() []Node {
+	return []Node{e.expr, e.index}
+}
+func (e FieldAccess) children() []Node {
+	return []Node{e.variable}
+}
+func (e Conversion) children() []Node {
+	return []Node{e.expr}
+}
 
 /////////////////////////////////////////////////////////////////////////////////////////////////
 // STATEMENTS
@@ -176,8 +323,39 @@ type Block struct {
 }
 
 type Assignment struct {
-	variables    []Variable
-	expressions  []Expression
+	variables   []Variable
+	expressions []Expression
+	// shorthand is '++' or '--', if the assignment was written as 'i++' or 'i--'. It is desugared to 'i = i + 1'.
+	// 'i += exp' is desugared to 'i = i + (exp)' and keeps '+=' as shorthand as well.
+	shorthand    string
+	line, column int
+}
+
+type ConstDeclaration struct {
+	variable     Variable
+	expression   Expression
+	line, column int
+}
+
+// StructField is one field of a struct declaration. offset is set by the semantic analysis.
+type StructField struct {
+	name         string
+	fType        Type
+	offset       int
+	line, column int
+}
+
+// StructDeclaration declares a struct variable. All fields start with the zero value of their type.
+type StructDeclaration struct {
+	variable     Variable
+	fields       []StructField
+	line, column int
+}
+
+// FieldAssignment assigns a single field of a struct variable: 's.x = exp'
+type FieldAssignment struct {
+	field        FieldAccess
+	expression   Expression
 	line, column int
 }
 
@@ -188,6 +366,7 @@ type Condition struct {
 	line, column int
 }
 
+// expressions is the loop condition. It is empty (loop until break) or has exactly one bool expression.
 type Loop struct {
 	assignment     Assignment
 	expressions    []Expression
@@ -196,10 +375,46 @@ type Loop struct {
 	line, column   int
 }
 
-func (a Block) statement()      {}
-func (a Assignment) statement() {}
-func (c Condition) statement()  {}
-func (l Loop) statement()       {}
+// Break and Continue always refer to the innermost loop
+type Break struct {
+	line, column int
+}
+type Continue struct {
+	line, column int
+}
+
+// A goto can jump to any label of its own block or of a surrounding block. But never into a nested block.
+// id is set by the semantic analysis and makes the label unique in the assembly.
+type Label struct {
+	name         string
+	id           int
+	line, column int
+}
+type Goto struct {
+	label        string
+	id           int
+	line, column int
+}
+
+// An expression, that is only evaluated for its side effect. Its value is dropped.
+// The parser only accepts function calls here. Something like '1 + 2' on its own is most likely a mistake.
+type ExprStatement struct {
+	expression   Expression
+	line, column int
+}
+
+func (a Block) statement()             {}
+func (a Assignment) statement()        {}
+func (c ConstDeclaration) statement()  {}
+func (s StructDeclaration) statement() {}
+func (f FieldAssignment) statement()   {}
+func (c Condition) statement()         {}
+func (l Loop) statement()              {}
+func (b Break) statement()             {}
+func (c Continue) statement()          {}
+func (l Label) statement()             {}
+func (g Goto) statement()              {}
+func (e ExprStatement) statement()     {}
 
 func (s Block) startPos() (int, int) {
 	return s.line, s.column
@@ -207,12 +422,111 @@ func (s Block) startPos() (int, int) {
 func (s Assignment) startPos() (int, int) {
 	return s.line, s.column
 }
+func (s ConstDeclaration) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s StructDeclaration) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s FieldAssignment) startPos() (int, int) {
+	return s.line, s.column
+}
 func (s Condition) startPos() (int, int) {
 	return s.line, s.column
 }
 func (s Loop) startPos() (int, int) {
 	return s.line, s.column
 }
+func (s Break) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s Continue) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s Label) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s Goto) startPos() (int, int) {
+	return s.line, s.column
+}
+func (s ExprStatement) startPos() (int, int) {
+	return s.line, s.column
+}
+
+func (s Block) children() []Node {
+	nodes := make([]Node, 0, len(s.statements))
+	for _, st := range s.statements {
+		nodes = append(nodes, st)
+	}
+	return nodes
+}
+func (s Assignment) children() []Node {
+	nodes := make([]Node, 0, len(s.variables)+len(s.expressions))
+	for _, v := range s.variables {
+		nodes = append(nodes, v)
+	}
+	return append(nodes, expressionNodes(s.expressions)...)
+}
+func (s ConstDeclaration) children() []Node {
+	return []Node{s.variable, s.expression}
+}
+func (s StructDeclaration) children() []Node {
+	return []Node{s.variable}
+}
+func (s FieldAssignment) children() []Node {
+	return []Node{s.field, s.expression}
+}
+func (s Condition) children() []Node {
+	return []Node{s.expression, s.block, s.elseBlock}
+}
+func (s Loop) children() []Node {
+	nodes := []Node{s.assignment}
+	nodes = append(nodes, expressionNodes(s.expressions)...)
+	return append(nodes, s.incrAssignment, s.block)
+}
+func (s Break) children() []Node {
+	return nil
+}
+func (s Continue) children() []Node {
+	return nil
+}
+func (s Label) children() []Node {
+	return nil
+}
+func (s Goto) children() []Node {
+	return nil
+}
+func (s ExprStatement) children() []Node {
+	return []Node{s.expression}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////
+// WALK
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+func expressionNodes(expressions []Expression) []Node {
+	nodes := make([]Node, 0, len(expressions))
+	for _, e := range expressions {
+		nodes = append(nodes, e)
+	}
+	return nodes
+}
+
+// walk visits the node and then all nodes below it, depth first in source order. The children of a node are skipped,
+// if visit returns false for it. The nodes are copies, so a pass can only collect information with walk. Passes, that
+// change the tree, still rebuild it by hand.
+func walk(node Node, visit func(Node) bool) {
+	// Only a broken tree has missing nodes. The code generation reports them with their position.
+	if node == nil {
+		return
+	}
+	if !visit(node) {
+		return
+	}
+	for _, c := range node.children() {
+		walk(c, visit)
+	}
+}
 
 /////////////////////////////////////////////////////////////////////////////////////////////////
 // AST, OPS STRING
@@ -237,6 +551,10 @@ func (o Operator) String() string {
 		return "*"
 	case OP_DIV:
 		return "/"
+	case OP_MOD:
+		return "%"
+	case OP_POW:
+		return "**"
 	case OP_NEGATIVE:
 		return "-"
 	case OP_EQ:
@@ -257,6 +575,8 @@ func (o Operator) String() string {
 		return "||"
 	case OP_NOT:
 		return "!"
+	case OP_BITNOT:
+		return "~"
 	case OP_UNKNOWN:
 		return "?"
 	}
@@ -275,6 +595,10 @@ func (v Variable) String() string {
 	return fmt.Sprintf("%v%v(%v)", shadowString, v.vType, v.vName)
 }
 func (c Constant) String() string {
+	// The value of a string is decoded already. Escaped again, a diagnostic stays on one line.
+	if c.cType == TYPE_STRING {
+		return fmt.Sprintf("%v(%v)", c.cType, encodeString(c.cValue))
+	}
 	return fmt.Sprintf("%v(%v)", c.cType, c.cValue)
 }
 func (b BinaryOp) String() string {
@@ -287,8 +611,27 @@ func (b BinaryOp) String() string {
 	return fmt.Sprintf("%v%v %v %v%v", start, b.leftExpr, b.operator, b.rightExpr, end)
 }
 func (u UnaryOp) String() string {
+	if u.fixed {
+		return fmt.Sprintf("(%v(%v))", u.operator, u.expr)
+	}
 	return fmt.Sprintf("%v(%v)", u.operator, u.expr)
 }
+func (f FunctionCall) String() string {
+	args := make([]string, 0, len(f.args))
+	for _, a := range f.args {
+		args = append(args, fmt.Sprintf("%v", a))
+	}
+	return fmt.Sprintf("%v(%v)", f.name, strings.Join(args, ", "))
+}
+func (i Index) String() string {
+	return fmt.Sprintf("%v[%v]", i.expr, i.index)
+}
+func (f FieldAccess) String() string {
+	return fmt.Sprintf("%v(%v.%v)", f.fType, f.variable.vName, f.field)
+}
+func (c Conversion) String() string {
+	return fmt.Sprintf("%v(%v)", c.cType, c.expr)
+}
 
 func (v Type) String() string {
 	switch v {
@@ -300,6 +643,12 @@ func (v Type) String() string {
 		return "float"
 	case TYPE_BOOL:
 		return "bool"
+	case TYPE_UINT:
+		return "uint"
+	case TYPE_STRUCT:
+		return "struct"
+	case TYPE_VOID:
+		return "void"
 	}
 	return "?"
 }
@@ -328,6 +677,22 @@ func (a Assignment) String() (s string) {
 	return
 }
 
+func (c ConstDeclaration) String() string {
+	return fmt.Sprintf("const %v = %v", c.variable, c.expression)
+}
+
+func (s StructDeclaration) String() string {
+	fields := make([]string, 0, len(s.fields))
+	for _, f := range s.fields {
+		fields = append(fields, fmt.Sprintf("%v: %v", f.name, f.fType))
+	}
+	return fmt.Sprintf("struct %v { %v }", s.variable.vName, strings.Join(fields, "; "))
+}
+
+func (f FieldAssignment) String() string {
+	return fmt.Sprintf("%v = %v", f.field, f.expression)
+}
+
 func (c Condition) String() (s string) {
 
 	s += fmt.Sprintf("if %v {\n", c.expression)
@@ -371,44 +736,216 @@ func (l Loop) String() (s string) {
 	return
 }
 
+func (b Break) String() string {
+	return "break"
+}
+
+func (c Continue) String() string {
+	return "continue"
+}
+
+func (l Label) String() string {
+	return fmt.Sprintf("%v:", l.name)
+}
+
+func (g Goto) String() string {
+	return fmt.Sprintf("goto %v", g.label)
+}
+
+func (e ExprStatement) String() string {
+	return fmt.Sprintf("%v", e.expression)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////
+// VERBOSE STRING
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+// StringVerbose prints the AST as a tree with one node per line and its position (line:col) in the source.
+// The compact String() stays as it is, this is only meant for debugging.
+func (ast AST) StringVerbose() string {
+	s := fmt.Sprintln("AST:")
+
+	for _, st := range ast.block.statements {
+		s += verboseString(st, 1)
+	}
+	return s
+}
+
+// verboseString prints a node and all its children indented by depth
+func verboseString(node Node, depth int) string {
+
+	var label string
+	var children []Node
+
+	switch n := node.(type) {
+	case BinaryOp:
+		label = fmt.Sprintf("BinaryOp '%v' %v", n.operator, n.opType)
+		children = []Node{n.leftExpr, n.rightExpr}
+	case UnaryOp:
+		label = fmt.Sprintf("UnaryOp '%v' %v", n.operator, n.opType)
+		children = []Node{n.expr}
+	case FunctionCall:
+		label = fmt.Sprintf("FunctionCall %v %v", n.name, n.fType)
+		for _, a := range n.args {
+			children = append(children, a)
+		}
+	case Index:
+		label = fmt.Sprintf("Index %v", n.iType)
+		children = []Node{n.expr, n.index}
+	case Block:
+		label = "Block"
+		for _, st := range n.statements {
+			children = append(children, st)
+		}
+	case Assignment:
+		label = "Assignment"
+		for _, v := range n.variables {
+			children = append(children, v)
+		}
+		for _, e := range n.expressions {
+			children = append(children, e)
+		}
+	case ConstDeclaration:
+		label = "ConstDeclaration"
+		children = []Node{n.variable, n.expression}
+	case FieldAssignment:
+		label = "FieldAssignment"
+		children = []Node{n.field, n.expression}
+	case Condition:
+		label = "Condition"
+		children = []Node{n.expression, n.block}
+		if n.elseBlock.statements != nil {
+			children = append(children, n.elseBlock)
+		}
+	case Loop:
+		label = "Loop"
+		children = []Node{n.assignment}
+		for _, e := range n.expressions {
+			children = append(children, e)
+		}
+		children = append(children, n.incrAssignment, n.block)
+	case ExprStatement:
+		label = "ExprStatement"
+		children = []Node{n.expression}
+	default:
+		// Variables, constants and the statements without children print themselves
+		label = fmt.Sprintf("%v", node)
+	}
+
+	row, col := node.startPos()
+	s := fmt.Sprintf("%v%v (%v:%v)\n", strings.Repeat("  ", depth), label, row, col)
+	for _, c := range children {
+		s += verboseString(c, depth+1)
+	}
+	return s
+}
+
 /////////////////////////////////////////////////////////////////////////////////////////////////
 // TOKEN CHANNEL
 /////////////////////////////////////////////////////////////////////////////////////////////////
 
-// Implements a channel with one cache/lookahead, that can be pushed back in (logically)
+// Implements a channel, that keeps the tokens read so far. The parser can go back to any earlier position and read
+// the same tokens again. So a parse function, that does not match, can leave the channel as it found it.
 type TokenChannel struct {
-	c        chan Token
-	isCached bool
-	token    Token
+	c chan Token
+	// All tokens read from the channel so far and the position of the next one
+	tokens []Token
+	pos    int
+
+	// Current nesting of expressions and the error, once it is too deep
+	depth      int
+	nestingErr error
+	// Number of open parentheses. Newlines don't end a statement inside of them.
+	parens int
+}
+
+// maxExpressionDepth limits the nesting of expressions (parentheses, operators). The parser recurses for every level.
+var maxExpressionDepth = 1000
+
+// enter goes one level deeper into an expression. Too deep nesting is an error instead of a stack overflow.
+func (tc *TokenChannel) enter() error {
+	if tc.depth >= maxExpressionDepth {
+		t := tc.peek()
+		tc.nestingErr = errorAt(
+			ErrCritical, t.line, t.column,
+			"Expression too deeply nested (more than %v levels)", maxExpressionDepth,
+		)
+		return tc.nestingErr
+	}
+	tc.depth++
+	return nil
+}
+
+// leave goes one level up again. The nesting error replaces all errors of the levels in between, which would
+// only repeat or hide it.
+func (tc *TokenChannel) leave(err *error) {
+	tc.depth--
+	if tc.nestingErr != nil {
+		*err = tc.nestingErr
+	}
 }
 
 func (tc *TokenChannel) next() Token {
-	if tc.isCached {
-		tc.isCached = false
-		return tc.token
+	if tc.pos == len(tc.tokens) {
+		// The lexer sends nothing after EOF
+		if tc.pos > 0 && tc.tokens[tc.pos-1].tokenType == TOKEN_EOF {
+			return tc.tokens[tc.pos-1]
+		}
+		v, ok := <-tc.c
+		if !ok {
+			fmt.Println("Error: Channel closed unexpectedly.")
+		}
+		tc.tokens = append(tc.tokens, v)
 	}
-	v, ok := <-tc.c
-	if !ok {
-		fmt.Println("Error: Channel closed unexpectedly.")
+	tc.pos++
+	return tc.tokens[tc.pos-1]
+}
+
+// last returns the last consumed token. Used to check, if tokens are on the same line.
+func (tc *TokenChannel) last() Token {
+	if tc.pos == 0 {
+		return Token{}
 	}
-	return v
+	return tc.tokens[tc.pos-1]
+}
+
+// newline is true, if the next token starts a new line outside of parentheses. This ends the current statement.
+func (tc *TokenChannel) newline() bool {
+	return tc.parens == 0 && tc.peek().line != tc.last().line
 }
 
-func (tc *TokenChannel) pushBack(t Token) {
-	if tc.isCached {
-		fmt.Println("Error: Can only cache one item at a time.")
+// peek returns the next token without consuming it
+func (tc *TokenChannel) peek() Token {
+	t := tc.next()
+	tc.pushBack()
+	return t
+}
+
+// pushBack puts the last consumed token back, so it is the next one again
+func (tc *TokenChannel) pushBack() {
+	if tc.pos == 0 {
+		fmt.Println("Error: Nothing to push back.")
 		return
 	}
-	tc.token = t
-	tc.isCached = true
+	tc.pos--
+}
+
+// mark returns the current position in the token stream. reset goes back to it, no matter how many tokens were
+// consumed in between.
+func (tc *TokenChannel) mark() int {
+	return tc.pos
 }
 
-//func (tc *TokenChannel) lastLineColumn() (int, int) {
-//	if !tc.isCached {
-//		return -1, -1
-//	}
-//	return tc.token.line, tc.token.column
-//}
+func (tc *TokenChannel) reset(mark int) {
+	tc.pos = mark
+}
+
+// restore goes back to the mark, if a parse function did not match. After a critical error, parsing stops anyway.
+func (tc *TokenChannel) restore(mark int, err error) {
+	if err != nil && !errors.Is(err, ErrCritical) {
+		tc.reset(mark)
+	}
+}
 
 /////////////////////////////////////////////////////////////////////////////////////////////////
 // PARSER IMPLEMENTATION
@@ -426,15 +963,30 @@ func (e UnaryOp) getExpressionType() Type {
 func (e BinaryOp) getExpressionType() Type {
 	return e.opType
 }
+func (e FunctionCall) getExpressionType() Type {
+	return e.fType
+}
+func (e Index) getExpressionType() Type {
+	return e.iType
+}
+func (e FieldAccess) getExpressionType() Type {
+	return e.fType
+}
+func (e Conversion) getExpressionType() Type {
+	return e.cType
+}
 
 // Operator priority (Descending priority!):
-// 1: 	'*', '/'
+// 0:	'**'
+// 1: 	'*', '/', '%'
 // 2: 	'+', '-'
 // 3:	'==', '!=', '<=', '>=', '<', '>'
 // 4:	'&&', '||'
 func (o Operator) priority() int {
 	switch o {
-	case OP_MULT, OP_DIV:
+	case OP_POW:
+		return 0
+	case OP_MULT, OP_DIV, OP_MOD:
 		return 1
 	case OP_PLUS, OP_MINUS:
 		return 2
@@ -458,6 +1010,10 @@ func getOperatorType(o string) Operator {
 		return OP_MULT
 	case "/":
 		return OP_DIV
+	case "%":
+		return OP_MOD
+	case "**":
+		return OP_POW
 	case "==":
 		return OP_EQ
 	case "!=":
@@ -476,6 +1032,8 @@ func getOperatorType(o string) Operator {
 		return OP_OR
 	case "!":
 		return OP_NOT
+	case "~":
+		return OP_BITNOT
 
 	}
 	return OP_UNKNOWN
@@ -486,7 +1044,7 @@ func getOperatorType(o string) Operator {
 func (tokens *TokenChannel) expectType(ttype TokenType) (string, int, int, bool) {
 	t := tokens.next()
 	if t.tokenType != ttype {
-		tokens.pushBack(t)
+		tokens.pushBack()
 		return "", -1, -1, false
 	}
 	return t.value, t.line, t.column, true
@@ -495,31 +1053,63 @@ func (tokens *TokenChannel) expectType(ttype TokenType) (string, int, int, bool)
 // expect checks the next token against a given expected type and value and returns true, if the
 // check was valid.
 func (tokens *TokenChannel) expect(ttype TokenType, value string) (int, int, bool) {
+	t, ok := tokens.expectToken(ttype, value)
+	return t.line, t.column, ok
+}
+
+// expectToken works like expect but returns the whole token. If the check was not valid, this is the
+// unexpected token, that can be reported.
+func (tokens *TokenChannel) expectToken(ttype TokenType, value string) (Token, bool) {
 	t := tokens.next()
 	if t.tokenType != ttype || t.value != value {
-		tokens.pushBack(t)
-		return t.line, t.column, false
+		tokens.pushBack()
+		return t, false
+	}
+	return t, true
+}
+
+// isReserved is true for keywords and the bool constants. They can never be a name.
+func isReserved(t Token) bool {
+	return t.tokenType == TOKEN_KEYWORD || (t.tokenType == TOKEN_CONSTANT && constBoolRegex.MatchString(t.value))
+}
+
+// reservedWord reports a keyword, that is used like a variable: 'for = 1'. The keyword is already consumed.
+func reservedWord(tokens *TokenChannel, keyword string, row, col int) error {
+	if t := tokens.peek(); t.tokenType == TOKEN_ASSIGNMENT || (t.tokenType == TOKEN_SEPARATOR && t.value == ",") {
+		return errorAt(ErrCritical, row, col, "'%v' is a reserved word and can not be used as a variable name", keyword)
 	}
-	return t.line, t.column, true
+	return nil
 }
 
-func parseVariable(tokens *TokenChannel) (Variable, bool) {
+func parseVariable(tokens *TokenChannel) (Variable, error) {
 
-	_, _, shadowing := tokens.expect(TOKEN_KEYWORD, "shadow")
+	shadowRow, shadowCol, shadowing := tokens.expect(TOKEN_KEYWORD, "shadow")
 
 	if v, row, col, ok := tokens.expectType(TOKEN_IDENTIFIER); ok {
-		return Variable{TYPE_UNKNOWN, v, shadowing, row, col}, true
+		return Variable{TYPE_UNKNOWN, v, shadowing, row, col}, nil
+	}
+
+	t := tokens.peek()
+	if shadowing {
+		if err := reservedWord(tokens, "shadow", shadowRow, shadowCol); err != nil {
+			return Variable{}, err
+		}
+		return Variable{}, errorAt(ErrCritical, t.line, t.column, "Expected variable after 'shadow', got %v", t.errorString())
 	}
-	// Only line/column are valid!
-	return Variable{}, false
+	return Variable{}, fmt.Errorf("%wExpected variable, got %v", ErrNormal, t.errorString())
 }
 
 func parseVarList(tokens *TokenChannel) (variables []Variable, err error) {
 	lastRow, lastCol := 0, 0
 	i := 0
 	for {
-		v, ok := parseVariable(tokens)
-		if !ok {
+		v, parseErr := parseVariable(tokens)
+		if errors.Is(parseErr, ErrCritical) {
+			err = parseErr
+			variables = nil
+			return
+		}
+		if parseErr != nil {
 
 			// If we don't find any variable, thats fine. Just don't end in ',', thats an error!
 			// We throw a normal error, so the parser up the chain can handle it how it likes.
@@ -527,13 +1117,23 @@ func parseVarList(tokens *TokenChannel) (variables []Variable, err error) {
 				err = fmt.Errorf("%wVariable list is empty or invalid", ErrNormal)
 				return
 			}
-			err = fmt.Errorf("%w[%v:%v] - Variable list ends with ','", ErrCritical, lastRow, lastCol)
+			if t := tokens.peek(); isReserved(t) {
+				err = errorAt(ErrCritical, t.line, t.column, "'%v' is a reserved word and can not be used as a variable name", t.value)
+				variables = nil
+				return
+			}
+			err = errorAt(ErrCritical, lastRow, lastCol, "Trailing ',' in variable list. Expected another variable after it")
 			variables = nil
 			return
 		}
+		// An optional type pins the type of the variable
+		if t, ok := parseOptionalType(tokens); ok {
+			v.vType = t
+		}
 		variables = append(variables, v)
 
 		// Expect separating ','. Otherwise, all good, we are through!
+		ok := false
 		if lastRow, lastCol, ok = tokens.expect(TOKEN_SEPARATOR, ","); !ok {
 			break
 		}
@@ -542,23 +1142,29 @@ func parseVarList(tokens *TokenChannel) (variables []Variable, err error) {
 	return
 }
 
-func getConstType(c string) Type {
-	rFloat := regexp.MustCompile(`^(-?\d+\.\d*)`)
-	rInt := regexp.MustCompile(`^(-?\d+)`)
-	rString := regexp.MustCompile(`^(".*")`)
-	rBool := regexp.MustCompile(`^(true|false)`)
-	cByte := []byte(c)
+// The spellings of constants, as the lexer emits them. Numbers are normalized by decodeNumber already: decimal, no '_'
+// and a float always has digits on both sides of the '.'.
+var (
+	constUintRegex   = regexp.MustCompile(`^\d+u$`)
+	constFloatRegex  = regexp.MustCompile(`^-?\d+\.\d+$`)
+	constIntRegex    = regexp.MustCompile(`^-?\d+$`)
+	constStringRegex = regexp.MustCompile(`(?s)^".*"$`)
+	constBoolRegex   = regexp.MustCompile(`^(true|false)$`)
+)
 
-	if s := rFloat.FindIndex(cByte); s != nil {
+// getConstType classifies the whole constant. Anything, that the lexer can not emit, is TYPE_UNKNOWN.
+func getConstType(c string) Type {
+	switch {
+	case constUintRegex.MatchString(c):
+		return TYPE_UINT
+	case constFloatRegex.MatchString(c):
 		return TYPE_FLOAT
-	}
-	if s := rInt.FindIndex(cByte); s != nil {
+	case constIntRegex.MatchString(c):
 		return TYPE_INT
-	}
-	if s := rString.FindIndex(cByte); s != nil {
+	// Strings can contain newlines after decoding the escape sequences
+	case constStringRegex.MatchString(c):
 		return TYPE_STRING
-	}
-	if s := rBool.FindIndex(cByte); s != nil {
+	case constBoolRegex.MatchString(c):
 		return TYPE_BOOL
 	}
 	return TYPE_UNKNOWN
@@ -569,14 +1175,78 @@ func parseConstant(tokens *TokenChannel) (Constant, bool) {
 	if v, row, col, ok := tokens.expectType(TOKEN_CONSTANT); ok {
 		return Constant{getConstType(v), v, row, col}, true
 	}
-	return Constant{TYPE_UNKNOWN, "", tokens.token.line, tokens.token.column}, false
+	return Constant{TYPE_UNKNOWN, "", tokens.peek().line, tokens.peek().column}, false
 }
 
-// parseSimpleExpression just parses variables, constants and '('...')'
+// parseSimpleExpression parses variables, constants and '('...')', that can be indexed
 func parseSimpleExpression(tokens *TokenChannel) (expression Expression, err error) {
-	// Expect either a constant/variable and you're done
-	if tmpV, ok := parseVariable(tokens); ok {
+	if expression, err = parseOperand(tokens); err != nil {
+		return
+	}
+	return parseIndex(tokens, expression)
+}
+
+// parseIndex parses any number of '[' exp ']' after the expression
+func parseIndex(tokens *TokenChannel, expression Expression) (Expression, error) {
+	for !tokens.newline() {
+		row, col, ok := tokens.expect(TOKEN_BRACKET_OPEN, "[")
+		if !ok {
+			break
+		}
+
+		tokens.parens++
+		index, parseErr := parseExpression(tokens)
+		tokens.parens--
+		if parseErr != nil {
+			return expression, errorAt(ErrCritical, row, col, "Invalid index expression --> %v", parseErr.Error())
+		}
+
+		if t, ok := tokens.expectToken(TOKEN_BRACKET_CLOSE, "]"); !ok {
+			return expression, errorAt(ErrCritical, t.line, t.column, "Expected ']' after index, got %v", t.errorString())
+		}
+
+		row, col = expression.startPos()
+		expression = Index{expression, index, TYPE_UNKNOWN, row, col}
+	}
+	return expression, nil
+}
+
+// parseOperand just parses variables, constants and '('...')'
+func parseOperand(tokens *TokenChannel) (expression Expression, err error) {
+	// A conversion looks like a function call. But 'int' and the like are no variable names.
+	switch conversion, parseErr := parseConversion(tokens); {
+	case parseErr == nil:
+		expression = conversion
+		return
+	case errors.Is(parseErr, ErrCritical):
+		err = parseErr
+		return
+	}
+
+	// Expect either a constant/variable/function call and you're done
+	switch tmpV, parseErr := parseVariable(tokens); {
+	case errors.Is(parseErr, ErrCritical):
+		err = parseErr
+		return
+	case parseErr == nil:
 		expression = tmpV
+		if tmpV.vShadow {
+			return
+		}
+		switch call, parseErr := parseFunctionCall(tokens, tmpV); {
+		case parseErr == nil:
+			expression = call
+			return
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		switch access, parseErr := parseFieldAccess(tokens, tmpV); {
+		case parseErr == nil:
+			expression = access
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+		}
 		return
 	}
 
@@ -586,10 +1256,12 @@ func parseSimpleExpression(tokens *TokenChannel) (expression Expression, err err
 	}
 
 	// Or a '(', then continue until ')'.
-	if row, col, ok := tokens.expect(TOKEN_PARENTHESIS_OPEN, "("); ok {
+	if _, _, ok := tokens.expect(TOKEN_PARENTHESIS_OPEN, "("); ok {
+		tokens.parens++
 		e, parseErr := parseExpression(tokens)
+		tokens.parens--
 		if parseErr != nil {
-			err = fmt.Errorf("%wInvalid expression in () --> %v", ErrCritical, parseErr.Error())
+			err = fmt.Errorf("%wInvalid expression in () --> %w", ErrCritical, parseErr)
 			return
 		}
 		if tmpE, ok := e.(BinaryOp); ok {
@@ -598,43 +1270,108 @@ func parseSimpleExpression(tokens *TokenChannel) (expression Expression, err err
 			e = tmpE
 
 		}
+		if tmpE, ok := e.(UnaryOp); ok {
+			tmpE.fixed = true
+			e = tmpE
+		}
 		expression = e
 
 		// Expect TOKEN_PARENTHESIS_CLOSE
-		row, col, ok = tokens.expect(TOKEN_PARENTHESIS_CLOSE, ")")
+		t, ok := tokens.expectToken(TOKEN_PARENTHESIS_CLOSE, ")")
 		if ok {
 			return
 		}
 
-		err = fmt.Errorf("%w[%v:%v] - Expected ')', got something else", ErrCritical, row, col)
+		err = errorAt(ErrCritical, t.line, t.column, "Expected ')', got %v", t.errorString())
 		return
 	}
 
-	err = fmt.Errorf("%wInvalid simple expression", ErrNormal)
+	err = fmt.Errorf("%wInvalid simple expression, got %v", ErrNormal, tokens.peek().errorString())
 	return
 }
 
-func parseUnaryExpression(tokens *TokenChannel) (expression Expression, err error) {
-	// Check for unary operator before the expression
-	if row, col, ok := tokens.expect(TOKEN_OPERATOR, "-"); ok {
-		e, parseErr := parseExpression(tokens)
-		if parseErr != nil {
-			err = fmt.Errorf("%w[%v:%v] - Invalid expression after unary '-'", ErrCritical, row, col)
+// isNegativeLiteral checks, if the token is a number, that the lexer read together with its sign
+func isNegativeLiteral(t Token) bool {
+	return t.tokenType == TOKEN_CONSTANT && strings.HasPrefix(t.value, "-")
+}
+
+// parseUnaryOperand parses the operand of a unary operator. Unary operators bind tighter than all binary operators
+// except '**' (like in Lua): -a * b == (-a) * b, but -a ** 2 == -(a ** 2).
+func parseUnaryOperand(tokens *TokenChannel) (expression Expression, err error) {
+
+	if err = tokens.enter(); err != nil {
+		return
+	}
+	defer tokens.leave(&err)
+
+	switch unaryExpression, parseErr := parseUnaryExpression(tokens); {
+	case parseErr == nil:
+		expression = unaryExpression
+		return
+	case errors.Is(parseErr, ErrCritical):
+		err = parseErr
+		return
+	}
+
+	// The lexer keeps the sign of a number in the constant. '-2 ** 2' must still be '-(2 ** 2)' like '-a ** 2'.
+	negativeLiteral := isNegativeLiteral(tokens.peek())
+
+	if expression, err = parseSimpleExpression(tokens); err != nil {
+		return
+	}
+
+	if t := tokens.peek(); t.tokenType == TOKEN_OPERATOR && t.value == "**" && !tokens.newline() {
+		c, negative := expression.(Constant)
+		if negative = negative && negativeLiteral; negative {
+			expression = Constant{c.cType, strings.TrimPrefix(c.cValue, "-"), c.line, c.column + 1}
+		}
+
+		tokens.next()
+		rightHandExpr, parseErr := parseUnaryOperand(tokens)
+		if parseErr != nil {
+			err = errorAt(ErrCritical, t.line, t.column, "Invalid expression on right hand side of binary operation")
+			return
+		}
+		row, col := expression.startPos()
+		expression = BinaryOp{OP_POW, expression, rightHandExpr, TYPE_UNKNOWN, false, row, col}
+		if negative {
+			expression = UnaryOp{OP_NEGATIVE, expression, TYPE_UNKNOWN, false, c.line, c.column}
+		}
+	}
+	return
+}
+
+func parseUnaryExpression(tokens *TokenChannel) (expression Expression, err error) {
+	// Check for unary operator before the expression
+	if row, col, ok := tokens.expect(TOKEN_OPERATOR, "-"); ok {
+		e, parseErr := parseUnaryOperand(tokens)
+		if parseErr != nil {
+			err = errorAt(ErrCritical, row, col, "Invalid expression after unary '-'")
 			return
 		}
 
-		expression = UnaryOp{OP_NEGATIVE, e, TYPE_UNKNOWN, row, col}
+		expression = UnaryOp{OP_NEGATIVE, e, TYPE_UNKNOWN, false, row, col}
 		return
 	}
 	// Check for unary operator before the expression
 	if row, col, ok := tokens.expect(TOKEN_OPERATOR, "!"); ok {
-		e, parseErr := parseExpression(tokens)
+		e, parseErr := parseUnaryOperand(tokens)
+		if parseErr != nil {
+			err = errorAt(ErrCritical, row, col, "Invalid expression after unary '!'")
+			return
+		}
+
+		expression = UnaryOp{OP_NOT, e, TYPE_UNKNOWN, false, row, col}
+		return
+	}
+	if row, col, ok := tokens.expect(TOKEN_OPERATOR, "~"); ok {
+		e, parseErr := parseUnaryOperand(tokens)
 		if parseErr != nil {
-			err = fmt.Errorf("%w[%v:%v] - Invalid expression after unary '!'", ErrCritical, row, col)
+			err = errorAt(ErrCritical, row, col, "Invalid expression after unary '~'")
 			return
 		}
 
-		expression = UnaryOp{OP_NOT, e, TYPE_UNKNOWN, row, col}
+		expression = UnaryOp{OP_BITNOT, e, TYPE_UNKNOWN, false, row, col}
 		return
 	}
 
@@ -644,13 +1381,24 @@ func parseUnaryExpression(tokens *TokenChannel) (expression Expression, err erro
 
 func parseExpression(tokens *TokenChannel) (expression Expression, err error) {
 
+	if err = tokens.enter(); err != nil {
+		return
+	}
+	defer tokens.leave(&err)
+
 	unaryExpression, parseErr := parseUnaryExpression(tokens)
 	if parseErr == nil {
 		expression = unaryExpression
+	} else if isNegativeLiteral(tokens.peek()) {
+		// '-2 ** 2' binds like '-a ** 2'. parseUnaryOperand takes care of that.
+		if expression, err = parseUnaryOperand(tokens); err != nil {
+			err = fmt.Errorf("%w - Simple expression expected", err)
+			return
+		}
 	} else {
 		simpleExpression, parseErr := parseSimpleExpression(tokens)
 		if parseErr != nil {
-			err = fmt.Errorf("%wSimple expression expected, got something else", parseErr)
+			err = fmt.Errorf("%w - Simple expression expected", parseErr)
 			return
 		}
 		expression = simpleExpression
@@ -658,12 +1406,22 @@ func parseExpression(tokens *TokenChannel) (expression Expression, err error) {
 
 	// Or an expression followed by a binop. Here we can continue just normally and just check
 	// if token.next() == binop, and just then, throw the parsed expression into a binop one.
+	// An operator on a new line belongs to the next statement. After the operator, the expression can continue there.
+	if tokens.newline() {
+		return
+	}
 	if t, row, col, ok := tokens.expectType(TOKEN_OPERATOR); ok {
 
+		// The lexer knows more operators than the language has. '<<' is one token, not '<' twice.
+		if getOperatorType(t) == OP_UNKNOWN {
+			err = errorAt(ErrCritical, row, col, "Operator '%v' is not supported", t)
+			return
+		}
+
 		// Create and return binary operation expression!
 		rightHandExpr, parseErr := parseExpression(tokens)
 		if parseErr != nil {
-			err = fmt.Errorf("%w[%v:%v] - Invalid expression on right hand side of binary operation", ErrCritical, row, col)
+			err = errorAt(ErrCritical, row, col, "Invalid expression on right hand side of binary operation")
 			return
 		}
 		row, col = expression.startPos()
@@ -690,7 +1448,7 @@ func parseExpressionList(tokens *TokenChannel) (expressions []Expression, err er
 				return
 			}
 
-			err = fmt.Errorf("%w[%v:%v] - Expression list ends in ','", ErrCritical, lastRow, lastCol)
+			err = errorAt(ErrCritical, lastRow, lastCol, "Trailing ',' in expression list. Expected another expression after it")
 			expressions = nil
 			return
 		}
@@ -705,26 +1463,120 @@ func parseExpressionList(tokens *TokenChannel) (expressions []Expression, err er
 	return
 }
 
-// parseBlock parses a list of statements from the tokens.
+// parseConversion parses a type name followed by an expression in '(' ')': 'float(i)'
+func parseConversion(tokens *TokenChannel) (conversion Conversion, err error) {
+
+	start := tokens.mark()
+	t := tokens.next()
+	cType, ok := typeName(t)
+	if !ok {
+		tokens.reset(start)
+		err = fmt.Errorf("%wExpected a type name for a conversion, got %v", ErrNormal, t.errorString())
+		return
+	}
+	if _, _, ok := tokens.expect(TOKEN_PARENTHESIS_OPEN, "("); !ok {
+		tokens.reset(start)
+		err = fmt.Errorf("%wExpected '(' after '%v' for a conversion, got %v", ErrNormal, t.value, tokens.peek().errorString())
+		return
+	}
+
+	tokens.parens++
+	expression, parseErr := parseExpression(tokens)
+	tokens.parens--
+	if parseErr != nil {
+		err = errorAt(ErrCritical, t.line, t.column, "Invalid expression in conversion to '%v' --> %v", cType, parseErr.Error())
+		return
+	}
+
+	if closing, ok := tokens.expectToken(TOKEN_PARENTHESIS_CLOSE, ")"); !ok {
+		err = errorAt(ErrCritical, closing.line, closing.column, "Expected ')' after conversion to '%v', got %v", cType, closing.errorString())
+		return
+	}
+
+	conversion = Conversion{expression, cType, t.line, t.column}
+	return
+}
+
+// parseFunctionCall parses the arguments of a call. The name is already parsed as a variable.
+// call ::= Name '(' [explist] ')'
+func parseFunctionCall(tokens *TokenChannel, name Variable) (call FunctionCall, err error) {
+
+	if _, _, ok := tokens.expect(TOKEN_PARENTHESIS_OPEN, "("); !ok {
+		err = fmt.Errorf("%wExpected '(' for function call, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+
+	// No arguments at all are fine too
+	tokens.parens++
+	args, parseErr := parseExpressionList(tokens)
+	tokens.parens--
+	if errors.Is(parseErr, ErrCritical) {
+		err = fmt.Errorf("%w - Invalid arguments for function call '%v'", parseErr, name.vName)
+		return
+	}
+
+	if t, ok := tokens.expectToken(TOKEN_PARENTHESIS_CLOSE, ")"); !ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected ')' after arguments of '%v', got %v", name.vName, t.errorString())
+		return
+	}
+
+	call = FunctionCall{name.vName, args, TYPE_UNKNOWN, name.line, name.column}
+	return
+}
+
 func parseAssignment(tokens *TokenChannel) (assignment Assignment, err error) {
 
 	// A list of variables!
 	variables, parseErr := parseVarList(tokens)
+	// This is most likely a critical error, like: a, = ...
+	if errors.Is(parseErr, ErrCritical) {
+		err = fmt.Errorf("%w - Parsing the variable list for an assignment resulted in an error", parseErr)
+		return
+	}
 	// No variables will return an ErrNormal. So all good, severity is handled up stream.
 	if len(variables) == 0 {
-		err = fmt.Errorf("%wExpected variable in assignment, got something else", parseErr)
+		err = fmt.Errorf("%wExpected variable in assignment, got %v", parseErr, tokens.peek().errorString())
 		return
 	}
-	// This is most likely a critical error, like: a, = ...
-	if parseErr != nil {
-		err = fmt.Errorf("%w - Parsing the variable list for an assignment resulted in an error", parseErr)
+
+	return parseAssignmentValues(tokens, variables)
+}
+
+// parseAssignmentValues parses the rest of an assignment after the variable list
+func parseAssignmentValues(tokens *TokenChannel, variables []Variable) (assignment Assignment, err error) {
+
+	// 'i++' and 'i--' are just short for 'i = i + 1' and 'i = i - 1'
+	if t, _, _, ok := tokens.expectType(TOKEN_INCREMENT); ok {
+		v := variables[0]
+		if len(variables) != 1 || v.vShadow {
+			err = errorAt(ErrCritical, v.line, v.column, "'%v' needs exactly one variable, that is not shadowing", t)
+			return
+		}
+		operator := Operator(OP_PLUS)
+		if t == "--" {
+			operator = OP_MINUS
+		}
+		one := Constant{TYPE_INT, "1", v.line, v.column}
+		expression := BinaryOp{operator, v, one, TYPE_UNKNOWN, false, v.line, v.column}
+		assignment = Assignment{variables, []Expression{expression}, t, v.line, v.column}
 		return
 	}
 
+	if t := tokens.peek(); t.tokenType == TOKEN_ASSIGNMENT && t.value != "=" && !tokens.newline() {
+		return parseCompoundAssignment(tokens, variables)
+	}
+
 	// One TOKEN_ASSIGNMENT
 	// If we got this far, we have a valid variable list. So from here on out, this _needs_ to be valid!
-	if row, col, ok := tokens.expect(TOKEN_ASSIGNMENT, "="); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected '=' in assignment, got something else", ErrCritical, row, col)
+	if t := tokens.peek(); tokens.newline() {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected '=' in assignment before the end of the line, got %v", t.errorString())
+		return
+	}
+	if t, ok := tokens.expectToken(TOKEN_ASSIGNMENT, "="); !ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected '=' in assignment, got %v", t.errorString())
+		return
+	}
+	if err = expectValueOnLine(tokens); err != nil {
 		return
 	}
 
@@ -737,28 +1589,262 @@ func parseAssignment(tokens *TokenChannel) (assignment Assignment, err error) {
 	}
 
 	row, col := variables[0].startPos()
-	assignment = Assignment{variables, expressions, row, col}
+	assignment = Assignment{variables, expressions, "", row, col}
+	return
+}
+
+// parseCompoundAssignment parses the rest of 'i += exp', which is short for 'i = i + (exp)'
+func parseCompoundAssignment(tokens *TokenChannel, variables []Variable) (assignment Assignment, err error) {
+
+	t := tokens.next()
+	v := variables[0]
+	if len(variables) != 1 || v.vShadow {
+		err = errorAt(ErrCritical, v.line, v.column, "'%v' needs exactly one variable, that is not shadowing", t.value)
+		return
+	}
+	if err = expectValueOnLine(tokens); err != nil {
+		return
+	}
+
+	value, parseErr := parseExpression(tokens)
+	if parseErr != nil {
+		err = fmt.Errorf("%w%w - Expected expression after '%v'", ErrCritical, parseErr, t.value)
+		return
+	}
+	// The whole value is the right operand: 'i *= 2 + 3' is 'i = i * (2 + 3)'
+	if b, ok := value.(BinaryOp); ok {
+		b.fixed = true
+		value = b
+	}
+
+	operator := getOperatorType(strings.TrimSuffix(t.value, "="))
+	expression := BinaryOp{operator, v, value, TYPE_UNKNOWN, false, v.line, v.column}
+	assignment = Assignment{variables, []Expression{expression}, t.value, v.line, v.column}
+	return
+}
+
+// expectValueOnLine makes sure, that the value after '=' starts on the same line. A newline would end the statement.
+func expectValueOnLine(tokens *TokenChannel) error {
+	if !tokens.newline() {
+		return nil
+	}
+	return errorAt(ErrCritical, tokens.last().line, tokens.last().column, "Expected value after '=' on the same line")
+}
+
+// const ::= 'const' Name [type] '=' exp
+func parseConstDeclaration(tokens *TokenChannel) (constDecl ConstDeclaration, err error) {
+
+	startRow, startCol, ok := tokens.expect(TOKEN_KEYWORD, "const")
+	if !ok {
+		err = fmt.Errorf("%wExpected 'const' keyword for constant declaration, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+
+	if err = reservedWord(tokens, "const", startRow, startCol); err != nil {
+		return
+	}
+
+	name, row, col, ok := tokens.expectType(TOKEN_IDENTIFIER)
+	if !ok {
+		t := tokens.peek()
+		err = errorAt(ErrCritical, t.line, t.column, "Expected name after 'const', got %v", t.errorString())
+		return
+	}
+	constType, _ := parseOptionalType(tokens)
+
+	if t, ok := tokens.expectToken(TOKEN_ASSIGNMENT, "="); !ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected '=' in constant declaration, got %v", t.errorString())
+		return
+	}
+	if err = expectValueOnLine(tokens); err != nil {
+		return
+	}
+
+	expression, parseErr := parseExpression(tokens)
+	if parseErr != nil {
+		err = fmt.Errorf("%w%w - Expected expression in constant declaration", ErrCritical, parseErr)
+		return
+	}
+
+	constDecl = ConstDeclaration{Variable{constType, name, false, row, col}, expression, startRow, startCol}
+	return
+}
+
+// typeNames are the types, that can be written in the source
+var typeNames = map[string]Type{
+	"int":    TYPE_INT,
+	"uint":   TYPE_UINT,
+	"float":  TYPE_FLOAT,
+	"bool":   TYPE_BOOL,
+	"string": TYPE_STRING,
+}
+
+// typeName returns the type, that the token names. 'int', 'string' and 'float' are keywords, 'uint' and 'bool' are not.
+func typeName(t Token) (Type, bool) {
+	typ, ok := typeNames[t.value]
+	return typ, ok && (t.tokenType == TOKEN_KEYWORD || t.tokenType == TOKEN_IDENTIFIER)
+}
+
+func parseTypeName(tokens *TokenChannel) (Type, error) {
+	t := tokens.next()
+	if typ, ok := typeName(t); ok {
+		return typ, nil
+	}
+	return TYPE_UNKNOWN, errorAt(ErrCritical, t.line, t.column, "Expected type, got %v", t.errorString())
+}
+
+// parseOptionalType parses a type right after a name on the same line: 'a int = 1'
+func parseOptionalType(tokens *TokenChannel) (Type, bool) {
+	if typ, ok := typeName(tokens.peek()); ok && !tokens.newline() {
+		tokens.next()
+		return typ, true
+	}
+	return TYPE_UNKNOWN, false
+}
+
+// struct ::= 'struct' Name '{' {Name ':' type (newline | ';')} '}'
+func parseStructDeclaration(tokens *TokenChannel) (structDecl StructDeclaration, err error) {
+
+	startRow, startCol, ok := tokens.expect(TOKEN_KEYWORD, "struct")
+	if !ok {
+		err = fmt.Errorf("%wExpected 'struct' keyword for struct declaration, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+
+	if err = reservedWord(tokens, "struct", startRow, startCol); err != nil {
+		return
+	}
+
+	name, row, col, ok := tokens.expectType(TOKEN_IDENTIFIER)
+	if !ok {
+		t := tokens.peek()
+		err = errorAt(ErrCritical, t.line, t.column, "Expected name after 'struct', got %v", t.errorString())
+		return
+	}
+
+	open, ok := tokens.expectToken(TOKEN_CURLY_OPEN, "{")
+	if !ok {
+		err = errorAt(ErrCritical, open.line, open.column, "Expected '{' after struct name, got %v", open.errorString())
+		return
+	}
+
+	// The lexer reads 'x:' like a label
+	for {
+		if _, _, ok := tokens.expect(TOKEN_CURLY_CLOSE, "}"); ok {
+			break
+		}
+
+		if tokens.peek().tokenType == TOKEN_EOF {
+			err = expectBlockEnd(tokens, open, "struct fields")
+			return
+		}
+
+		field, fieldRow, fieldCol, ok := tokens.expectType(TOKEN_LABEL)
+		if !ok {
+			t := tokens.peek()
+			err = errorAt(ErrCritical, t.line, t.column, "Expected field like 'x: int' or '}' in struct, got %v", t.errorString())
+			return
+		}
+		field = strings.TrimSuffix(field, ":")
+
+		if t := tokens.peek(); tokens.newline() {
+			err = errorAt(ErrCritical, t.line, t.column, "Expected type of field '%v' on the same line", field)
+			return
+		}
+		fieldType, parseErr := parseTypeName(tokens)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		structDecl.fields = append(structDecl.fields, StructField{field, fieldType, 0, fieldRow, fieldCol})
+
+		if _, _, ok := tokens.expect(TOKEN_SEMICOLON, ";"); ok {
+			continue
+		}
+		if t := tokens.peek(); t.line == tokens.last().line && t.tokenType != TOKEN_CURLY_CLOSE {
+			err = errorAt(ErrCritical, t.line, t.column, "Expected newline or ';' after field '%v', got %v", field, t.errorString())
+			return
+		}
+	}
+
+	structDecl.variable = Variable{TYPE_UNKNOWN, name, false, row, col}
+	structDecl.line, structDecl.column = startRow, startCol
+	return
+}
+
+// parseFieldAccess parses the '.' Name after a variable
+func parseFieldAccess(tokens *TokenChannel, v Variable) (access FieldAccess, err error) {
+
+	if tokens.newline() {
+		err = fmt.Errorf("%wExpected '.' on the same line for field access", ErrNormal)
+		return
+	}
+	if _, _, ok := tokens.expect(TOKEN_DOT, "."); !ok {
+		err = fmt.Errorf("%wExpected '.' for field access, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+
+	field, _, _, ok := tokens.expectType(TOKEN_IDENTIFIER)
+	if !ok {
+		t := tokens.peek()
+		err = errorAt(ErrCritical, t.line, t.column, "Expected field name after '.', got %v", t.errorString())
+		return
+	}
+
+	access = FieldAccess{v, field, TYPE_UNKNOWN, 0, v.line, v.column}
+	return
+}
+
+// parseFieldAssignment parses the rest of 's.x = exp'. A field is always assigned on its own, not in a list or chain.
+func parseFieldAssignment(tokens *TokenChannel, access FieldAccess) (statements []Statement, err error) {
+
+	if t := tokens.peek(); tokens.newline() {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected '=' in assignment before the end of the line, got %v", t.errorString())
+		return
+	}
+	if t, ok := tokens.expectToken(TOKEN_ASSIGNMENT, "="); !ok {
+		err = errorAt(
+			ErrCritical, t.line, t.column,
+			"Expected '=' after field '%v.%v', got %v", access.variable.vName, access.field, t.errorString(),
+		)
+		return
+	}
+	if err = expectValueOnLine(tokens); err != nil {
+		return
+	}
+
+	expression, parseErr := parseExpression(tokens)
+	if parseErr != nil {
+		err = fmt.Errorf("%w%w - Expected expression in field assignment", ErrCritical, parseErr)
+		return
+	}
+
+	statements = []Statement{FieldAssignment{access, expression, access.line, access.column}}
 	return
 }
 
-// if ::= 'if' exp '{' [stat] '}' [else '{' [stat] '}']
+// if ::= 'if' exp '{' [stat] '}' [else ('{' [stat] '}' | if)]
 func parseCondition(tokens *TokenChannel) (condition Condition, err error) {
 
 	startRow, startCol, ok := 0, 0, false
 
 	if startRow, startCol, ok = tokens.expect(TOKEN_KEYWORD, "if"); !ok {
-		err = fmt.Errorf("%wExpected 'if' keyword for condition, got something else", ErrNormal)
+		err = fmt.Errorf("%wExpected 'if' keyword for condition, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+	if err = reservedWord(tokens, "if", startRow, startCol); err != nil {
 		return
 	}
 
 	expression, parseErr := parseExpression(tokens)
 	if parseErr != nil {
-		err = fmt.Errorf("%w%v - Expected expression after 'if' keyword", ErrCritical, parseErr.Error())
+		err = fmt.Errorf("%w%w - Expected expression after 'if' keyword", ErrCritical, parseErr)
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_CURLY_OPEN, "{"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected '{' after condition, got something else", ErrCritical, row, col)
+	open, ok := tokens.expectToken(TOKEN_CURLY_OPEN, "{")
+	if !ok {
+		err = errorAt(ErrCritical, open.line, open.column, "Expected '{' after condition, got %v", open.errorString())
 		return
 	}
 
@@ -768,8 +1854,7 @@ func parseCondition(tokens *TokenChannel) (condition Condition, err error) {
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_CURLY_CLOSE, "}"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected '}' after condition block, got something else", ErrCritical, row, col)
+	if err = expectBlockEnd(tokens, open, "condition block"); err != nil {
 		return
 	}
 
@@ -778,8 +1863,23 @@ func parseCondition(tokens *TokenChannel) (condition Condition, err error) {
 
 	// Just in case we have an else, handle it!
 	if _, _, ok := tokens.expect(TOKEN_KEYWORD, "else"); ok {
-		if row, col, ok := tokens.expect(TOKEN_CURLY_OPEN, "{"); !ok {
-			err = fmt.Errorf("%w[%v:%v] - Expected '{' after 'else' in condition, got something else", ErrCritical, row, col)
+
+		// 'else if' is short for an else block with just the next condition. Otherwise the braces are mandatory.
+		switch elseIf, parseErr := parseCondition(tokens); {
+		case parseErr == nil:
+			row, col := elseIf.startPos()
+			condition.elseBlock = Block{statements: []Statement{elseIf}, line: row, column: col}
+			condition.line = startRow
+			condition.column = startCol
+			return
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+
+		open, ok := tokens.expectToken(TOKEN_CURLY_OPEN, "{")
+		if !ok {
+			err = errorAt(ErrCritical, open.line, open.column, "Expected '{' or 'if' after 'else' in condition, got %v", open.errorString())
 			return
 		}
 
@@ -789,8 +1889,7 @@ func parseCondition(tokens *TokenChannel) (condition Condition, err error) {
 			return
 		}
 
-		if row, col, ok := tokens.expect(TOKEN_CURLY_CLOSE, "}"); !ok {
-			err = fmt.Errorf("%w[%v:%v] - Expected '}' after 'else' block in condition, got something else", ErrCritical, row, col)
+		if err = expectBlockEnd(tokens, open, "'else' block in condition"); err != nil {
 			return
 		}
 
@@ -808,42 +1907,58 @@ func parseLoop(tokens *TokenChannel) (loop Loop, err error) {
 	startRow, startCol, ok := 0, 0, false
 
 	if startRow, startCol, ok = tokens.expect(TOKEN_KEYWORD, "for"); !ok {
-		err = fmt.Errorf("%wExpected 'for' keyword for loop, got something else", ErrNormal)
+		err = fmt.Errorf("%wExpected 'for' keyword for loop, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+	if err = reservedWord(tokens, "for", startRow, startCol); err != nil {
 		return
 	}
 
 	// We don't care about a valid assignment. If there is none, we are fine too :)
+	start := tokens.mark()
 	assignment, parseErr := parseAssignment(tokens)
+	tokens.restore(start, parseErr)
 	if errors.Is(parseErr, ErrCritical) {
 		err = fmt.Errorf("%w - Invalid assignment in loop", parseErr)
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_SEMICOLON, ";"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected ';' after loop assignment, got something else", ErrCritical, row, col)
+	if t, ok := tokens.expectToken(TOKEN_SEMICOLON, ";"); !ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected ';' after loop assignment, got %v", t.errorString())
 		return
 	}
 
-	expressions, parseErr := parseExpressionList(tokens)
-	if errors.Is(parseErr, ErrCritical) {
-		err = fmt.Errorf("%w - Invalid expression list in loop expression", parseErr)
+	// The condition is optional. But there is at most one, 'a && b' combines several.
+	var expressions []Expression
+	switch expression, parseErr := parseExpression(tokens); {
+	case parseErr == nil:
+		expressions = []Expression{expression}
+	case errors.Is(parseErr, ErrCritical):
+		err = fmt.Errorf("%w - Invalid expression in loop condition", parseErr)
+		return
+	}
+	if t, ok := tokens.expectToken(TOKEN_SEPARATOR, ","); ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected a single loop condition, got ','. Use '&&' to combine conditions")
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_SEMICOLON, ";"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected ';' after loop expression, got something else", ErrCritical, row, col)
+	if t, ok := tokens.expectToken(TOKEN_SEMICOLON, ";"); !ok {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected ';' after loop expression, got %v", t.errorString())
 		return
 	}
 
 	// We are also fine with no assignment!
+	start = tokens.mark()
 	incrAssignment, parseErr := parseAssignment(tokens)
+	tokens.restore(start, parseErr)
 	if errors.Is(parseErr, ErrCritical) {
 		err = fmt.Errorf("%w - Invalid increment assignment in loop", parseErr)
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_CURLY_OPEN, "{"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected '{' after loop header, got something else", ErrCritical, row, col)
+	open, ok := tokens.expectToken(TOKEN_CURLY_OPEN, "{")
+	if !ok {
+		err = errorAt(ErrCritical, open.line, open.column, "Expected '{' after loop header, got %v", open.errorString())
 		return
 	}
 
@@ -853,8 +1968,7 @@ func parseLoop(tokens *TokenChannel) (loop Loop, err error) {
 		return
 	}
 
-	if row, col, ok := tokens.expect(TOKEN_CURLY_CLOSE, "}"); !ok {
-		err = fmt.Errorf("%w[%v:%v] - Expected '}' after loop block, got something else", ErrCritical, row, col)
+	if err = expectBlockEnd(tokens, open, "loop block"); err != nil {
 		return
 	}
 
@@ -868,35 +1982,276 @@ func parseLoop(tokens *TokenChannel) (loop Loop, err error) {
 	return
 }
 
+// parseLoopControl parses the 'break' and 'continue' statements, which consist only of the keyword
+func parseLoopControl(tokens *TokenChannel) (statement Statement, err error) {
+
+	if row, col, ok := tokens.expect(TOKEN_KEYWORD, "break"); ok {
+		statement = Break{row, col}
+		err = reservedWord(tokens, "break", row, col)
+		return
+	}
+	if row, col, ok := tokens.expect(TOKEN_KEYWORD, "continue"); ok {
+		statement = Continue{row, col}
+		err = reservedWord(tokens, "continue", row, col)
+		return
+	}
+
+	err = fmt.Errorf("%wExpected 'break' or 'continue', got %v", ErrNormal, tokens.peek().errorString())
+	return
+}
+
+// label ::= Name ':'
+func parseLabel(tokens *TokenChannel) (label Label, err error) {
+
+	name, row, col, ok := tokens.expectType(TOKEN_LABEL)
+	if !ok {
+		err = fmt.Errorf("%wExpected label, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+
+	label = Label{strings.TrimSuffix(name, ":"), 0, row, col}
+	return
+}
+
+// goto ::= 'goto' Name
+func parseGoto(tokens *TokenChannel) (gotoStatement Goto, err error) {
+
+	startRow, startCol, ok := tokens.expect(TOKEN_KEYWORD, "goto")
+	if !ok {
+		err = fmt.Errorf("%wExpected 'goto' keyword, got %v", ErrNormal, tokens.peek().errorString())
+		return
+	}
+	if err = reservedWord(tokens, "goto", startRow, startCol); err != nil {
+		return
+	}
+
+	name, _, _, ok := tokens.expectType(TOKEN_IDENTIFIER)
+	if !ok {
+		t := tokens.peek()
+		err = errorAt(ErrCritical, t.line, t.column, "Expected label name after 'goto', got %v", t.errorString())
+		return
+	}
+
+	gotoStatement = Goto{name, 0, startRow, startCol}
+	return
+}
+
+// parseSimpleStatement parses an assignment or a function call. Both start with a name, so the decision is
+// made after the variable list.
+func parseSimpleStatement(tokens *TokenChannel) (statements []Statement, err error) {
+
+	variables, parseErr := parseVarList(tokens)
+	if errors.Is(parseErr, ErrCritical) {
+		err = fmt.Errorf("%w - Parsing the variable list for an assignment resulted in an error", parseErr)
+		return
+	}
+	if len(variables) == 0 {
+		err = fmt.Errorf("%wExpected assignment or function call, got %v", parseErr, tokens.peek().errorString())
+		return
+	}
+
+	// A function call or field access never has a type: 'f int(1)' is no call
+	if v := variables[0]; len(variables) == 1 && !v.vShadow && v.vType == TYPE_UNKNOWN {
+		switch call, parseErr := parseFunctionCall(tokens, v); {
+		case parseErr == nil:
+			statements = []Statement{ExprStatement{call, v.line, v.column}}
+			return
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		switch access, parseErr := parseFieldAccess(tokens, v); {
+		case parseErr == nil:
+			return parseFieldAssignment(tokens, access)
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+	}
+
+	return parseChainedAssignment(tokens, variables)
+}
+
+// parseChainedAssignment parses an assignment, that may be chained like 'a = b = exp'. The chain is desugared
+// right to left into sequential assignments 'b = exp' and 'a = b'. So the value is only evaluated once and every
+// target is type checked against the value it receives.
+func parseChainedAssignment(tokens *TokenChannel, variables []Variable) (statements []Statement, err error) {
+
+	assignment, err := parseAssignmentValues(tokens, variables)
+	if err != nil {
+		return
+	}
+
+	// A '=' on the next line does not continue the chain
+	if tokens.newline() {
+		statements = []Statement{assignment}
+		return
+	}
+	t, ok := tokens.expectToken(TOKEN_ASSIGNMENT, "=")
+	if !ok {
+		statements = []Statement{assignment}
+		return
+	}
+	if len(assignment.expressions) == 0 {
+		err = errorAt(ErrCritical, t.line, t.column, "Expected variable before '=' in chained assignment")
+		return
+	}
+
+	// The values of this assignment are the variables of the next one in the chain
+	targets := make([]Variable, len(assignment.expressions))
+	for i, e := range assignment.expressions {
+		v, ok := e.(Variable)
+		if !ok {
+			row, col := e.startPos()
+			err = errorAt(ErrCritical, row, col, "Only variables can be assigned in a chained assignment, got %v", e)
+			return
+		}
+		targets[i] = v
+	}
+
+	tokens.pushBack()
+	statements, err = parseChainedAssignment(tokens, targets)
+	statements = append(statements, assignment)
+	return
+}
+
+// expectBlockEnd expects the '}' of the block opened by open. At the end of the program, the '{' is named. The
+// missing '}' could belong anywhere after it, so that is the only certain position.
+func expectBlockEnd(tokens *TokenChannel, open Token, block string) error {
+	t, ok := tokens.expectToken(TOKEN_CURLY_CLOSE, "}")
+	switch {
+	case ok:
+		return nil
+	case t.tokenType == TOKEN_EOF:
+		return errorAt(ErrCritical, t.line, t.column, "Unclosed '{' opened at %v:%v", open.line, open.column)
+	}
+	return errorAt(ErrCritical, t.line, t.column, "Expected '}' after %v, got %v", block, t.errorString())
+}
+
+// parseStatementEnd makes sure, that a statement is terminated by a newline or ';'.
+// The end of a block or the program terminates a statement as well.
+func parseStatementEnd(tokens *TokenChannel) error {
+	if _, _, ok := tokens.expect(TOKEN_SEMICOLON, ";"); ok {
+		return nil
+	}
+	t := tokens.peek()
+	if t.line != tokens.last().line || t.tokenType == TOKEN_CURLY_CLOSE || t.tokenType == TOKEN_EOF {
+		return nil
+	}
+	return errorAt(ErrCritical, t.line, t.column, "Expected newline or ';' after statement, got %v", t.errorString())
+}
+
 func parseStatementList(tokens *TokenChannel) (block Block, err error) {
 	for {
+		// A statement, that does not match, leaves the tokens as they were for the next one
+		start := tokens.mark()
 
 		switch ifStatement, parseErr := parseCondition(tokens); {
 		case parseErr == nil:
 			block.statements = append(block.statements, ifStatement)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
 			continue
 		case errors.Is(parseErr, ErrCritical):
 			err = parseErr
 			return
 		}
+		tokens.reset(start)
 
 		switch loopStatement, parseErr := parseLoop(tokens); {
 		case parseErr == nil:
 			block.statements = append(block.statements, loopStatement)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
+			continue
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		tokens.reset(start)
+
+		switch loopControl, parseErr := parseLoopControl(tokens); {
+		case parseErr == nil:
+			block.statements = append(block.statements, loopControl)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
+			continue
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		tokens.reset(start)
+
+		// A label only marks the following statement. So it doesn't need to be terminated.
+		if label, parseErr := parseLabel(tokens); parseErr == nil {
+			block.statements = append(block.statements, label)
+			continue
+		}
+		tokens.reset(start)
+
+		switch gotoStatement, parseErr := parseGoto(tokens); {
+		case parseErr == nil:
+			block.statements = append(block.statements, gotoStatement)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
+			continue
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		tokens.reset(start)
+
+		switch constDecl, parseErr := parseConstDeclaration(tokens); {
+		case parseErr == nil:
+			block.statements = append(block.statements, constDecl)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
+			continue
+		case errors.Is(parseErr, ErrCritical):
+			err = parseErr
+			return
+		}
+		tokens.reset(start)
+
+		switch structDecl, parseErr := parseStructDeclaration(tokens); {
+		case parseErr == nil:
+			block.statements = append(block.statements, structDecl)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
 			continue
 		case errors.Is(parseErr, ErrCritical):
 			err = parseErr
 			return
 		}
+		tokens.reset(start)
 
-		switch assignment, parseErr := parseAssignment(tokens); {
+		switch simpleStatements, parseErr := parseSimpleStatement(tokens); {
 		case parseErr == nil:
-			block.statements = append(block.statements, assignment)
+			block.statements = append(block.statements, simpleStatements...)
+			if err = parseStatementEnd(tokens); err != nil {
+				return
+			}
 			continue
 		case errors.Is(parseErr, ErrCritical):
 			err = parseErr
 			return
 		}
+		tokens.reset(start)
+
+		// The other keywords and the bool constants never start a statement. So they are consumed for a clear error.
+		if t := tokens.peek(); isReserved(t) {
+			tokens.next()
+			if err = reservedWord(tokens, t.value, t.line, t.column); err == nil {
+				err = errorAt(ErrCritical, t.line, t.column, "Unexpected %v at the start of a statement", t.errorString())
+			}
+			return
+		}
 
 		// If we don't recognize the current token as part of a known statement, we break
 		// This means likely, that we are at the end of a block
@@ -909,23 +2264,40 @@ func parseStatementList(tokens *TokenChannel) (block Block, err error) {
 		block.line = row
 		block.column = col
 	} else {
-		// Backup solution! As we tried parsing statements (unsuccessfully), there must be
-		// a token cached. We just take its position for now
-		block.line = tokens.token.line
-		block.column = tokens.token.column
+		// Backup solution! No statement matched, so we take the position of the next token for now
+		t := tokens.peek()
+		block.line = t.line
+		block.column = t.column
 	}
 
 	return
 }
 
-func parse(tokens chan Token) (ast AST, err error) {
+func parse(tokens chan Token) (ast AST, diagnostics Diagnostics) {
 
 	var tokenChan TokenChannel
 	tokenChan.c = tokens
 
+	// There are no statements yet. The last token is the best guess, where a panic happened.
+	defer func() {
+		if r := recover(); r != nil {
+			panic(internalError{tokenChan.last().line, tokenChan.last().column, r})
+		}
+	}()
+
 	block, parseErr := parseStatementList(&tokenChan)
-	err = parseErr
+	if parseErr != nil {
+		diagnostics = Diagnostics{newDiagnostic(parseErr)}
+		return
+	}
 	ast.block = block
 
+	// The statement list stops at the first token, that does not start a statement. At the top level,
+	// this must be the end of the program. Otherwise there is trailing junk (e.g. a '}' without opener).
+	if t, ok := tokenChan.expectToken(TOKEN_EOF, ""); !ok {
+		err := errorAt(ErrCritical, t.line, t.column, "Unexpected token after program: %v", t.errorString())
+		diagnostics = Diagnostics{newDiagnostic(err)}
+	}
+
 	return
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -29,16 +32,51 @@ func compareExpression(e1, e2 Expression) (bool, string) {
 		if v2, ok := e2.(BinaryOp); ok {
 			ok1, err1 := compareExpression(v1.leftExpr, v2.leftExpr)
 			ok2, err2 := compareExpression(v1.rightExpr, v2.rightExpr)
-			ok3 := v1.operator == v2.operator
-			return ok1 && ok2 && ok3, err1 + err2
+			ok3 := v1.operator == v2.operator && v1.opType == v2.opType
+			if ok1 && ok2 && ok3 {
+				return true, ""
+			}
+			return false, err1 + err2 + fmt.Sprintf(" (%v %v != %v %v)", v1.operator, v1.opType, v2.operator, v2.opType)
 		}
 		return false, fmt.Sprintf("%v != %v (BinaryOp)", e1, e2)
 	case UnaryOp:
 		if v2, ok := e2.(UnaryOp); ok {
 			ok1, err1 := compareExpression(v1.expr, v2.expr)
-			return v1.operator == v2.operator && ok1, err1
+			return v1.operator == v2.operator && v1.opType == v2.opType && ok1, err1
 		}
 		return false, fmt.Sprintf("%v != %v (UnaryOp)", e1, e2)
+	case FunctionCall:
+		if v2, ok := e2.(FunctionCall); ok {
+			ok1, err1 := compareExpressions(v1.args, v2.args)
+			if v1.name == v2.name && v1.fType == v2.fType && ok1 {
+				return true, ""
+			}
+			return false, err1 + fmt.Sprintf(" (%v != %v)", v1, v2)
+		}
+		return false, fmt.Sprintf("%v != %v (FunctionCall)", e1, e2)
+	case Index:
+		if v2, ok := e2.(Index); ok {
+			ok1, err1 := compareExpressions([]Expression{v1.expr, v1.index}, []Expression{v2.expr, v2.index})
+			return v1.iType == v2.iType && ok1, err1
+		}
+		return false, fmt.Sprintf("%v != %v (Index)", e1, e2)
+	case FieldAccess:
+		if v2, ok := e2.(FieldAccess); ok {
+			if v1.variable.eq(v2.variable) && v1.field == v2.field && v1.fType == v2.fType && v1.offset == v2.offset {
+				return true, ""
+			}
+			return false, fmt.Sprintf("%v != %v (FieldAccess)", v1, v2)
+		}
+		return false, fmt.Sprintf("%v != %v (FieldAccess)", e1, e2)
+	case Conversion:
+		if v2, ok := e2.(Conversion); ok {
+			ok1, err1 := compareExpression(v1.expr, v2.expr)
+			if v1.cType == v2.cType && ok1 {
+				return true, ""
+			}
+			return false, err1 + fmt.Sprintf(" (%v != %v)", v1, v2)
+		}
+		return false, fmt.Sprintf("%v != %v (Conversion)", e1, e2)
 	}
 	return false, fmt.Sprintf("%v is not an expression", e1)
 }
@@ -77,6 +115,33 @@ func compareStatement(s1, s2 Statement) (bool, string) {
 			return ok1 && ok2, err1 + err2
 		}
 		return false, fmt.Sprintf("%v not an Assignment", s2)
+	case ConstDeclaration:
+		if v2, ok := s2.(ConstDeclaration); ok {
+			ok1 := v1.variable.eq(v2.variable)
+			ok2, err2 := compareExpression(v1.expression, v2.expression)
+			return ok1 && ok2, err2
+		}
+		return false, fmt.Sprintf("%v not a ConstDeclaration", s2)
+	case StructDeclaration:
+		if v2, ok := s2.(StructDeclaration); ok {
+			ok1 := v1.variable.eq(v2.variable) && len(v1.fields) == len(v2.fields)
+			for i := 0; ok1 && i < len(v1.fields); i++ {
+				f1, f2 := v1.fields[i], v2.fields[i]
+				ok1 = f1.name == f2.name && f1.fType == f2.fType && f1.offset == f2.offset
+			}
+			if ok1 {
+				return true, ""
+			}
+			return false, fmt.Sprintf("%v != %v (StructDeclaration)", v1, v2)
+		}
+		return false, fmt.Sprintf("%v not a StructDeclaration", s2)
+	case FieldAssignment:
+		if v2, ok := s2.(FieldAssignment); ok {
+			ok1, err1 := compareExpression(v1.field, v2.field)
+			ok2, err2 := compareExpression(v1.expression, v2.expression)
+			return ok1 && ok2, err1 + err2
+		}
+		return false, fmt.Sprintf("%v not a FieldAssignment", s2)
 	case Condition:
 		if v2, ok := s2.(Condition); ok {
 			ok1, err1 := compareExpression(v1.expression, v2.expression)
@@ -93,6 +158,37 @@ func compareStatement(s1, s2 Statement) (bool, string) {
 			ok4, err4 := compareBlock(v1.block, v2.block)
 			return ok1 && ok2 && ok3 && ok4, err1 + err2 + err3 + err4
 		}
+	case Break:
+		if _, ok := s2.(Break); ok {
+			return true, ""
+		}
+		return false, fmt.Sprintf("%v not a Break", s2)
+	case Continue:
+		if _, ok := s2.(Continue); ok {
+			return true, ""
+		}
+		return false, fmt.Sprintf("%v not a Continue", s2)
+	case Label:
+		if v2, ok := s2.(Label); ok {
+			if v1.name == v2.name {
+				return true, ""
+			}
+			return false, fmt.Sprintf("Label %v != %v", v1.name, v2.name)
+		}
+		return false, fmt.Sprintf("%v not a Label", s2)
+	case Goto:
+		if v2, ok := s2.(Goto); ok {
+			if v1.label == v2.label {
+				return true, ""
+			}
+			return false, fmt.Sprintf("Goto %v != %v", v1.label, v2.label)
+		}
+		return false, fmt.Sprintf("%v not a Goto", s2)
+	case ExprStatement:
+		if v2, ok := s2.(ExprStatement); ok {
+			return compareExpression(v1.expression, v2.expression)
+		}
+		return false, fmt.Sprintf("%v not an ExprStatement", s2)
 	}
 	return false, fmt.Sprintf("Expected statement, got: %v", s1)
 }
@@ -106,18 +202,40 @@ func compareBlock(ss1, ss2 Block) (bool, string) {
 			return false, e
 		}
 	}
-	// TODO: Compare symbol table
+	return compareSymbolTables(ss1.symbolTable, ss2.symbolTable)
+}
+
+// compareSymbolTables compares names and types of all entries. The expected table s2 is only compared, if it
+// exists. The parser alone doesn't create any symbol tables.
+func compareSymbolTables(s1, s2 SymbolTable) (bool, string) {
+	if s2.table == nil {
+		return true, ""
+	}
+	if len(s1.table) != len(s2.table) {
+		return false, fmt.Sprintf("Symbol tables of different lengths: %v, %v", s1.table, s2.table)
+	}
+	for name, e2 := range s2.table {
+		e1, ok := s1.table[name]
+		if !ok {
+			return false, fmt.Sprintf("Symbol %v missing in %v", name, s1.table)
+		}
+		if e1.sType != e2.sType || e1.isConst != e2.isConst {
+			return false, fmt.Sprintf("Symbol %v is different: %v != %v", name, e1, e2)
+		}
+	}
 	return true, ""
 }
 
 func compareASTs(generated AST, expected AST) (bool, string) {
+	if ok, e := compareSymbolTables(generated.globalSymbolTable, expected.globalSymbolTable); !ok {
+		return false, e
+	}
 	return compareBlock(generated.block, expected.block)
 }
 
 func testAST(code []byte, expected AST, t *testing.T) {
-	tokenChan := make(chan Token, 1)
-	lexerErr := make(chan error, 1)
-	go tokenize(code, tokenChan, lexerErr)
+	tokenChan, lexerErr, stop := lex(code)
+	defer stop()
 
 	generated, err := parse(tokenChan)
 	select {
@@ -135,6 +253,40 @@ func testAST(code []byte, expected AST, t *testing.T) {
 	}
 }
 
+// testASTSemantic runs the parser and the semantic analysis. So the expected tree has to contain all types.
+func testASTSemantic(code []byte, expected AST, t *testing.T) {
+	generated, err := analyzeCode(code)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+		return
+	}
+
+	if b, e := compareASTs(generated, expected); !b {
+		t.Errorf("Trees don't match: %v", e)
+	}
+}
+
+// testParseError expects parsing to fail with an error message containing the expected string
+func testParseError(code []byte, expected string, t *testing.T) {
+	tokenChan, lexerErr, stop := lex(code)
+	defer stop()
+
+	_, err := parse(tokenChan)
+	select {
+	case e := <-lexerErr:
+		t.Errorf("%v", e.Error())
+		return
+	default:
+	}
+	if err == nil {
+		t.Errorf("Expected parsing error containing '%v', got none", expected)
+		return
+	}
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected parsing error containing '%v', got: %v", expected, err)
+	}
+}
+
 func newVar(t Type, value string, shadow bool) Variable {
 	return Variable{t, value, shadow, 0, 0}
 }
@@ -142,13 +294,16 @@ func newConst(t Type, value string) Constant {
 	return Constant{t, value, 0, 0}
 }
 func newUnary(op Operator, e Expression) UnaryOp {
-	return UnaryOp{op, e, TYPE_UNKNOWN, 0, 0}
+	return UnaryOp{op, e, TYPE_UNKNOWN, false, 0, 0}
 }
 func newBinary(op Operator, eLeft, eRight Expression, t Type, fixed bool) BinaryOp {
 	return BinaryOp{op, eLeft, eRight, t, fixed, 0, 0}
 }
 func newAssignment(variables []Variable, expressions []Expression) Assignment {
-	return Assignment{variables, expressions, 0, 0}
+	return Assignment{variables, expressions, "", 0, 0}
+}
+func newConstDeclaration(v Variable, e Expression) ConstDeclaration {
+	return ConstDeclaration{v, e, 0, 0}
 }
 func newCondition(e Expression, block, elseBlock Block) Condition {
 	return Condition{e, block, elseBlock, 0, 0}
@@ -156,11 +311,20 @@ func newCondition(e Expression, block, elseBlock Block) Condition {
 func newLoop(a Assignment, exprs []Expression, incrA Assignment, b Block) Loop {
 	return Loop{a, exprs, incrA, b, 0, 0}
 }
-func newBlock(statements []Statement) Block {
-	return Block{statements, SymbolTable{}, 0, 0}
+
+// newBlock optionally gets the expected symbols of the block. They are only compared, if there are any.
+func newBlock(statements []Statement, symbols ...Variable) Block {
+	var table map[string]SymbolEntry
+	if len(symbols) > 0 {
+		table = make(map[string]SymbolEntry, 0)
+	}
+	for _, v := range symbols {
+		table[v.vName] = SymbolEntry{sType: v.vType}
+	}
+	return Block{statements, SymbolTable{table, nil}, 0, 0}
 }
 func newAST(b Block) AST {
-	return AST{b, SymbolTable{}}
+	return AST{b, SymbolTable{}, nil}
 }
 
 func TestParserExpression1(t *testing.T) {
@@ -432,3 +596,862 @@ func TestParserFor3(t *testing.T) {
 
 	testAST(code, expected, t)
 }
+
+func TestParserParenthesesString(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = (b + c) * d
+	a = (-b) * c
+	`)
+
+	tokenChan, _, stop := lex(code)
+	defer stop()
+
+	ast, err := parse(tokenChan)
+	if err != nil {
+		t.Fatalf("Parsing error: %v", err)
+	}
+
+	expected := []string{"(?(b) + ?(c)) * ?(d)", "(-(?(b))) * ?(c)"}
+	for i, e := range expected {
+		assignment := ast.block.statements[i].(Assignment)
+		if s := fmt.Sprintf("%v", assignment.expressions[0]); s != e {
+			t.Errorf("Expected parentheses to be reproduced: %v, got: %v", e, s)
+		}
+	}
+}
+
+func TestParserErrorToken(t *testing.T) {
+
+	testParseError([]byte(`a = (b + c d`), `Expected ')', got IDENTIFIER "d"`, t)
+	testParseError([]byte(`a b = 1`), `Expected '=' in assignment, got IDENTIFIER "b"`, t)
+	testParseError([]byte(`if a == b c = 1 }`), `Expected '{' after condition, got IDENTIFIER "c"`, t)
+	testParseError([]byte(`for i = 0 i < 5; i = i+1 {}`), `Expected ';' after loop assignment, got IDENTIFIER "i"`, t)
+	testParseError([]byte(`for ;; { a = 1`), `[0:14] - Unclosed '{' opened at 0:7`, t)
+	testParseError([]byte("for ;; { a = 1\n)"), `Expected '}' after loop block, got PARENTHESIS_CLOSE ")"`, t)
+}
+
+func TestParserUnclosedBlock(t *testing.T) {
+
+	// The block, that is not closed, is named. Not just the end of the program.
+	var code []byte = []byte(`
+	if a {
+		for ;; {
+			if b {
+				c = 1
+		}
+	}
+	`)
+
+	testParseError(code, "[7:1] - Unclosed '{' opened at 1:6", t)
+	testParseError([]byte("if a {\n} else {\n\tb = 1"), "[2:6] - Unclosed '{' opened at 1:7", t)
+	testParseError([]byte("struct p {\n\tx: int\n"), "[2:0] - Unclosed '{' opened at 0:9", t)
+}
+
+func TestParserConversion(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = float(i + 1) * 2.0
+	b = bool(int(f))
+	`)
+
+	expected := newAST(
+		newBlock([]Statement{
+			newAssignment(
+				[]Variable{newVar(TYPE_UNKNOWN, "a", false)},
+				[]Expression{newBinary(OP_MULT,
+					Conversion{newBinary(OP_PLUS, newVar(TYPE_UNKNOWN, "i", false), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false), TYPE_FLOAT, 0, 0},
+					newConst(TYPE_FLOAT, "2.0"), TYPE_UNKNOWN, false,
+				)},
+			),
+			newAssignment(
+				[]Variable{newVar(TYPE_UNKNOWN, "b", false)},
+				[]Expression{Conversion{Conversion{newVar(TYPE_UNKNOWN, "f", false), TYPE_INT, 0, 0}, TYPE_BOOL, 0, 0}},
+			),
+		}),
+	)
+
+	testAST(code, expected, t)
+	testParseError([]byte("a = int(1"), "[0:9] - Expected ')' after conversion to 'int', got EOF", t)
+}
+
+func TestParserUnsupportedOperator(t *testing.T) {
+	testParseError([]byte("a = 1 << 2"), "[0:6] - Operator '<<' is not supported", t)
+}
+
+func TestParserWalk(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1 + 2
+	if a > 0 {
+		print(-a)
+	}
+	`)
+
+	tokenChan, _, stop := lex(code)
+	defer stop()
+	ast, err := parse(tokenChan)
+	if err != nil {
+		t.Fatalf("Parsing error: %v", err)
+	}
+
+	// The empty else block is a node as well
+	nodes, variables, constants := 0, 0, 0
+	walk(ast.block, func(n Node) bool {
+		nodes++
+		switch n.(type) {
+		case Variable:
+			variables++
+		case Constant:
+			constants++
+		}
+		return true
+	})
+	if nodes != 16 || variables != 3 || constants != 3 {
+		t.Errorf("Expected 16 nodes, 3 variables and 3 constants, got %v, %v and %v", nodes, variables, constants)
+	}
+
+	// Nothing below the condition is visited
+	nodes = 0
+	walk(ast.block, func(n Node) bool {
+		nodes++
+		_, isCondition := n.(Condition)
+		return !isCondition
+	})
+	if nodes != 7 {
+		t.Errorf("Expected 7 nodes without the children of the condition, got %v", nodes)
+	}
+}
+
+func TestParserStatementSeparator(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1; b = 2
+	if a == b { c = 3 }
+	`)
+
+	expected := newAST(
+		newBlock(
+			[]Statement{
+				newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{newConst(TYPE_INT, "1")}),
+				newAssignment([]Variable{newVar(TYPE_UNKNOWN, "b", false)}, []Expression{newConst(TYPE_INT, "2")}),
+				newCondition(
+					newBinary(OP_EQ, newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "b", false), TYPE_UNKNOWN, false),
+					newBlock([]Statement{newAssignment([]Variable{newVar(TYPE_UNKNOWN, "c", false)}, []Expression{newConst(TYPE_INT, "3")})}),
+					newBlock([]Statement{}),
+				),
+			},
+		),
+	)
+
+	testAST(code, expected, t)
+}
+
+func TestParserMissingStatementSeparator(t *testing.T) {
+
+	testParseError([]byte(`a = 1 b = 2`), `[0:6] - Expected newline or ';' after statement, got IDENTIFIER "b"`, t)
+	testParseError([]byte(`if a == b {} c = 3`), `[0:13] - Expected newline or ';' after statement, got IDENTIFIER "c"`, t)
+}
+
+func TestParserConst(t *testing.T) {
+
+	var code []byte = []byte(`
+	const a = 2 * 3
+	`)
+
+	expected := newAST(
+		newBlock(
+			[]Statement{
+				newConstDeclaration(
+					newVar(TYPE_UNKNOWN, "a", false),
+					newBinary(OP_MULT, newConst(TYPE_INT, "2"), newConst(TYPE_INT, "3"), TYPE_UNKNOWN, false),
+				),
+			},
+		),
+	)
+
+	testAST(code, expected, t)
+}
+
+func TestParserBreakContinue(t *testing.T) {
+
+	var code []byte = []byte(`
+	for ;; {
+		continue
+		break
+	}
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newLoop(Assignment{}, nil, Assignment{}, newBlock([]Statement{Continue{}, Break{}})),
+	}))
+
+	testAST(code, expected, t)
+}
+
+func TestParserLabelGoto(t *testing.T) {
+
+	var code []byte = []byte(`
+	start:
+	a = 1
+	goto start
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		Label{"start", 0, 0, 0},
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{newConst(TYPE_INT, "1")}),
+		Goto{"start", 0, 0, 0},
+	}))
+
+	testAST(code, expected, t)
+}
+
+func TestParserUnmatchedCurlyClose(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	}
+	`)
+
+	testParseError(code, `[2:1] - Unexpected token after program: CURLY_CLOSE "}"`, t)
+}
+
+func TestParserTrailingGarbage(t *testing.T) {
+
+	testParseError([]byte("a = 1\n)"), `[1:0] - Unexpected token after program: PARENTHESIS_CLOSE ")"`, t)
+	testParseError([]byte("a = 1; 5"), `[0:7] - Unexpected token after program: CONSTANT "5"`, t)
+	testParseError([]byte("if a {\n}\n= 2"), `[2:0] - Unexpected token after program: ASSIGNMENT "="`, t)
+}
+
+func TestParserForMultipleVariables(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i, j = 0, 10; i < j; i, j = i+1, j-1 {
+	}
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newLoop(
+			newAssignment(
+				[]Variable{newVar(TYPE_UNKNOWN, "i", false), newVar(TYPE_UNKNOWN, "j", false)},
+				[]Expression{newConst(TYPE_INT, "0"), newConst(TYPE_INT, "10")},
+			),
+			[]Expression{newBinary(OP_LESS, newVar(TYPE_UNKNOWN, "i", false), newVar(TYPE_UNKNOWN, "j", false), TYPE_UNKNOWN, false)},
+			newAssignment(
+				[]Variable{newVar(TYPE_UNKNOWN, "i", false), newVar(TYPE_UNKNOWN, "j", false)},
+				[]Expression{
+					newBinary(OP_PLUS, newVar(TYPE_UNKNOWN, "i", false), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false),
+					newBinary(OP_MINUS, newVar(TYPE_UNKNOWN, "j", false), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false),
+				},
+			),
+			newBlock(nil),
+		),
+	}))
+
+	testAST(code, expected, t)
+}
+
+// The loop condition is a single expression. Several conditions are combined with '&&'.
+func TestParserForSingleCondition(t *testing.T) {
+
+	var code []byte = []byte("for ; a && b; {\n}")
+
+	a, b := newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "b", false)
+
+	expected := newAST(newBlock([]Statement{
+		newLoop(
+			newAssignment([]Variable{}, []Expression{}),
+			[]Expression{newBinary(OP_AND, a, b, TYPE_UNKNOWN, false)},
+			newAssignment([]Variable{}, []Expression{}),
+			newBlock(nil),
+		),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte(`for ; a, b; {}`), "[0:7] - Expected a single loop condition, got ','. Use '&&' to combine conditions", t)
+	testParseError([]byte(`for i = 0; i < 5, i > 0; i++ {}`), "[0:16] - Expected a single loop condition, got ','", t)
+}
+
+func TestParserErrorSeverity(t *testing.T) {
+
+	// A missing '}' after a started block aborts parsing
+	tokens, _ := tokenizeAll([]byte("if a {\n b = 1\n"))
+	_, err := parse(tokenChannel(tokens))
+	if !errors.Is(err, ErrCritical) {
+		t.Errorf("Expected critical error for missing '}', got: %v", err)
+	} else if !strings.Contains(err.Error(), "[2:0] - Unclosed '{' opened at 0:5") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+
+	// A token, that doesn't start a statement, just ends the statement list
+	tokens, _ = tokenizeAll([]byte("a = 1\n}"))
+	var tc TokenChannel
+	tc.c = tokenChannel(tokens)
+	block, listErr := parseStatementList(&tc)
+	if listErr != nil {
+		t.Errorf("Expected statement list to end without error, got: %v", listErr)
+	}
+	if len(block.statements) != 1 {
+		t.Errorf("Expected one statement, got: %v", block.statements)
+	}
+	if next := tc.next(); next.tokenType != TOKEN_CURLY_CLOSE {
+		t.Errorf("Expected '}' to be left for the caller, got: %v", next)
+	}
+
+	// Each rule on its own reports a mismatch as normal error
+	tokens, _ = tokenizeAll([]byte("a = 1"))
+	tc = TokenChannel{}
+	tc.c = tokenChannel(tokens)
+	if _, err := parseCondition(&tc); !errors.Is(err, ErrNormal) {
+		t.Errorf("Expected normal error for a non matching rule, got: %v", err)
+	}
+}
+
+func TestParserNoMatchRestoresTokens(t *testing.T) {
+
+	// The channel can go back over several tokens at once
+	tokens, _ := tokenizeAll([]byte("a, b = 1, 2"))
+	tc := TokenChannel{c: tokenChannel(tokens)}
+	tc.next()
+	start := tc.mark()
+	for i := 0; i < 4; i++ {
+		tc.next()
+	}
+	tc.reset(start)
+	if last, next := tc.last(), tc.next(); last.value != "a" || next.value != "," {
+		t.Errorf("Expected to continue after 'a', got last %v and next %v", last, next)
+	}
+
+	// A rule, that does not match, leaves all tokens for the next rule or the caller
+	for code, first := range map[string]string{
+		"5 = a":   "5",
+		"(a) = 1": "(",
+		"} a = 1": "}",
+	} {
+		tokens, _ = tokenizeAll([]byte(code))
+		tc = TokenChannel{c: tokenChannel(tokens)}
+		block, err := parseStatementList(&tc)
+		if err != nil {
+			t.Errorf("Expected '%v' to end the statement list without error, got: %v", code, err)
+			continue
+		}
+		if len(block.statements) != 0 {
+			t.Errorf("Expected no statements for '%v', got: %v", code, block.statements)
+		}
+		if tc.peek().value != first {
+			t.Errorf("Expected '%v' to be left for the caller of '%v', got: %v", first, code, tc.peek())
+		}
+	}
+
+	// An optional assignment in the loop header, that does not match, does not eat the expression
+	tokens, _ = tokenizeAll([]byte("for ; i < 3; {\n}"))
+	tc = TokenChannel{c: tokenChannel(tokens)}
+	if _, err := parseLoop(&tc); err != nil {
+		t.Errorf("Expected loop without assignments to parse, got: %v", err)
+	}
+
+	// Input, that looks like an assignment, but is none, is reported at its start and not somewhere after it
+	testParseError([]byte("a = 1\n(b) = 2"), `[1:0] - Unexpected token after program: PARENTHESIS_OPEN "("`, t)
+	testParseError([]byte("a = 1\n5, b = 2"), `[1:0] - Unexpected token after program: CONSTANT "5"`, t)
+}
+
+func TestParserIncrementDecrement(t *testing.T) {
+
+	var code []byte = []byte(`
+	a++
+	for i = 0; i < 10; i-- {
+	}
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment(
+			[]Variable{newVar(TYPE_UNKNOWN, "a", false)},
+			[]Expression{newBinary(OP_PLUS, newVar(TYPE_UNKNOWN, "a", false), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false)},
+		),
+		newLoop(
+			newAssignment([]Variable{newVar(TYPE_UNKNOWN, "i", false)}, []Expression{newConst(TYPE_INT, "0")}),
+			[]Expression{newBinary(OP_LESS, newVar(TYPE_UNKNOWN, "i", false), newConst(TYPE_INT, "10"), TYPE_UNKNOWN, false)},
+			newAssignment(
+				[]Variable{newVar(TYPE_UNKNOWN, "i", false)},
+				[]Expression{newBinary(OP_MINUS, newVar(TYPE_UNKNOWN, "i", false), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false)},
+			),
+			newBlock(nil),
+		),
+	}))
+
+	testAST(code, expected, t)
+}
+
+func TestParserCompoundAssignment(t *testing.T) {
+
+	var code []byte = []byte(`
+	a *= 2 + 3
+	for i = 0; i < 10; i += 2 {
+	}
+	`)
+
+	a, i := newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "i", false)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{a}, []Expression{
+			newBinary(OP_MULT, a, newBinary(OP_PLUS, newConst(TYPE_INT, "2"), newConst(TYPE_INT, "3"), TYPE_UNKNOWN, true), TYPE_UNKNOWN, false),
+		}),
+		newLoop(
+			newAssignment([]Variable{i}, []Expression{newConst(TYPE_INT, "0")}),
+			[]Expression{newBinary(OP_LESS, i, newConst(TYPE_INT, "10"), TYPE_UNKNOWN, false)},
+			newAssignment([]Variable{i}, []Expression{newBinary(OP_PLUS, i, newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false)}),
+			newBlock(nil),
+		),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte("a, b += 1"), "[0:0] - '+=' needs exactly one variable, that is not shadowing", t)
+	testParseError([]byte("a -=\n1"), "[0:2] - Expected value after '=' on the same line", t)
+}
+
+func TestParserIncrementInvalid(t *testing.T) {
+
+	testParseError([]byte(`"s"++`), `[0:0] - Unexpected token after program: CONSTANT "\"s\""`, t)
+	testParseError([]byte(`a, b++`), `[0:0] - '++' needs exactly one variable, that is not shadowing`, t)
+}
+
+func TestParserSeparatedTwoCharOperators(t *testing.T) {
+
+	testParseError([]byte(`c = a < = b`), `[0:6] - Invalid expression on right hand side of binary operation`, t)
+	testParseError([]byte(`if a = = b {}`), `[0:5] - Expected '{' after condition, got ASSIGNMENT "="`, t)
+	testParseError([]byte(`c = a ! = b`), `[0:6] - Invalid expression on right hand side of binary operation`, t)
+}
+
+func TestParserTrailingComma(t *testing.T) {
+
+	testParseError([]byte(`a, b, = 1, 2`), "[0:4] - Trailing ',' in variable list. Expected another variable after it", t)
+	testParseError([]byte(`a, b = 1, 2,`), "[0:11] - Trailing ',' in expression list. Expected another expression after it", t)
+	testParseError([]byte(`for i, = 0;; {}`), "[0:5] - Trailing ',' in variable list. Expected another variable after it", t)
+	testParseError([]byte(`for ; a, ; {}`), "[0:7] - Expected a single loop condition, got ','", t)
+}
+
+func TestParserSemanticTypes(t *testing.T) {
+
+	var code []byte = []byte(`
+	b = 3
+	a = b + 2 * b
+	c = a < 1 * b
+	d = 1.5 * 2.0
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_INT, "b", false)}, []Expression{newConst(TYPE_INT, "3")}),
+		newAssignment(
+			[]Variable{newVar(TYPE_INT, "a", false)},
+			[]Expression{newBinary(
+				OP_PLUS, newVar(TYPE_INT, "b", false),
+				newBinary(OP_MULT, newConst(TYPE_INT, "2"), newVar(TYPE_INT, "b", false), TYPE_INT, false),
+				TYPE_INT, false,
+			)},
+		),
+		newAssignment(
+			[]Variable{newVar(TYPE_BOOL, "c", false)},
+			[]Expression{newBinary(
+				OP_LESS, newVar(TYPE_INT, "a", false),
+				newBinary(OP_MULT, newConst(TYPE_INT, "1"), newVar(TYPE_INT, "b", false), TYPE_INT, false),
+				TYPE_BOOL, false,
+			)},
+		),
+		newAssignment([]Variable{newVar(TYPE_FLOAT, "d", false)}, []Expression{newConst(TYPE_FLOAT, "3.0")}),
+	}, newVar(TYPE_INT, "a", false), newVar(TYPE_INT, "b", false), newVar(TYPE_BOOL, "c", false), newVar(TYPE_FLOAT, "d", false)))
+
+	testASTSemantic(code, expected, t)
+}
+
+func TestParserSemanticConstantAddition(t *testing.T) {
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_INT, "a", false)}, []Expression{newConst(TYPE_INT, "3")}),
+	}, newVar(TYPE_INT, "a", false)))
+
+	testASTSemantic([]byte(`a = 1 + 2`), expected, t)
+}
+
+func TestParserFunctionCall(t *testing.T) {
+
+	var code []byte = []byte(`
+	print(a)
+	b = len("abc") + 1
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		ExprStatement{FunctionCall{"print", []Expression{newVar(TYPE_UNKNOWN, "a", false)}, TYPE_UNKNOWN, 0, 0}, 0, 0},
+		newAssignment(
+			[]Variable{newVar(TYPE_UNKNOWN, "b", false)},
+			[]Expression{newBinary(OP_PLUS, FunctionCall{"len", []Expression{newConst(TYPE_STRING, `"abc"`)}, TYPE_UNKNOWN, 0, 0}, newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false)},
+		),
+	}))
+
+	testAST(code, expected, t)
+}
+
+func TestParserExpressionStatementOnlyCalls(t *testing.T) {
+	testParseError([]byte(`1 + 2`), `[0:0] - Unexpected token after program: CONSTANT "1"`, t)
+	testParseError([]byte(`print(1`), `Expected ')' after arguments of 'print'`, t)
+}
+
+func TestParserChainedAssignment(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = b = 0
+	a, b = c, d = 1, 2
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "b", false)}, []Expression{newConst(TYPE_INT, "0")}),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{newVar(TYPE_UNKNOWN, "b", false)}),
+		newAssignment(
+			[]Variable{newVar(TYPE_UNKNOWN, "c", false), newVar(TYPE_UNKNOWN, "d", false)},
+			[]Expression{newConst(TYPE_INT, "1"), newConst(TYPE_INT, "2")},
+		),
+		newAssignment(
+			[]Variable{newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "b", false)},
+			[]Expression{newVar(TYPE_UNKNOWN, "c", false), newVar(TYPE_UNKNOWN, "d", false)},
+		),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte(`a = b + 1 = 0`), "[0:4] - Only variables can be assigned in a chained assignment", t)
+	testParseError([]byte(`a = = 0`), "[0:4] - Expected variable before '=' in chained assignment", t)
+}
+
+func TestParserNewlines(t *testing.T) {
+
+	var code []byte = []byte("a = (1\n+ 2)\nb = 1 +\n2\nc = print(1,\n2)")
+
+	one, two := newConst(TYPE_INT, "1"), newConst(TYPE_INT, "2")
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{newBinary(OP_PLUS, one, two, TYPE_UNKNOWN, true)}),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "b", false)}, []Expression{newBinary(OP_PLUS, one, two, TYPE_UNKNOWN, false)}),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "c", false)}, []Expression{FunctionCall{"print", []Expression{one, two}, TYPE_UNKNOWN, 0, 0}}),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte("a =\n1"), "[0:2] - Expected value after '=' on the same line", t)
+	testParseError([]byte("a\n= 1"), "[1:0] - Expected '=' in assignment before the end of the line", t)
+	testParseError([]byte("const c =\n1"), "[0:8] - Expected value after '=' on the same line", t)
+	// The operator can not start a new statement
+	testParseError([]byte("a = 1\n+ 2"), "[1:0] - Unexpected token after program", t)
+	testParseError([]byte("a = b = 1\n= 2"), "[1:0] - Unexpected token after program", t)
+}
+
+// A newline inside parentheses does not end the statement. So a long condition can be split over several lines.
+func TestParserMultiLineHeader(t *testing.T) {
+
+	var code []byte = []byte("if (a &&\n\tb) {\n\tprint(a)\n}\nfor i = 0; (i < 10 ||\n\ta); i = i + 1 {\n}")
+
+	a, b, i := newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "b", false), newVar(TYPE_UNKNOWN, "i", false)
+
+	expected := newAST(newBlock([]Statement{
+		newCondition(
+			newBinary(OP_AND, a, b, TYPE_UNKNOWN, true),
+			newBlock([]Statement{ExprStatement{FunctionCall{"print", []Expression{a}, TYPE_UNKNOWN, 0, 0}, 0, 0}}),
+			newBlock(nil),
+		),
+		newLoop(
+			newAssignment([]Variable{i}, []Expression{newConst(TYPE_INT, "0")}),
+			[]Expression{newBinary(OP_LESS, i, newBinary(OP_OR, newConst(TYPE_INT, "10"), a, TYPE_UNKNOWN, false), TYPE_UNKNOWN, true)},
+			newAssignment([]Variable{i}, []Expression{newBinary(OP_PLUS, i, newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false)}),
+			newBlock(nil),
+		),
+	}))
+
+	testAST(code, expected, t)
+
+	// Without parentheses, the newline after the condition still ends it
+	testParseError([]byte("if a\n&& b {\n}"), "[1:0] - Expected '{' after condition, got OPERATOR \"&&\"", t)
+}
+
+func TestParserStringVerbose(t *testing.T) {
+
+	var code []byte = []byte("a = 1 + b\nif a == 2 {\n\tprint(a)\n}")
+
+	tokenChan, _, stop := lex(code)
+	defer stop()
+	ast, err := parse(tokenChan)
+	if err != nil {
+		t.Fatalf("Parsing error: %v", err)
+	}
+
+	verbose := ast.StringVerbose()
+	for _, expected := range []string{
+		"  Assignment (0:0)\n    ?(a) (0:0)\n    BinaryOp '+' ? (0:4)\n      int(1) (0:4)\n      ?(b) (0:8)\n",
+		"  Condition (1:0)\n    BinaryOp '==' ? (1:3)\n",
+		"    Block (2:1)\n      ExprStatement (2:1)\n        FunctionCall print ? (2:1)\n          ?(a) (2:7)\n",
+	} {
+		if !strings.Contains(verbose, expected) {
+			t.Errorf("Expected the verbose AST to contain:\n%v\ngot:\n%v", expected, verbose)
+		}
+	}
+
+	if strings.Contains(ast.String(), "(0:0)") {
+		t.Errorf("Expected the compact AST to be without positions, got:\n%v", ast)
+	}
+}
+
+func TestParserUnaryPrecedence(t *testing.T) {
+
+	var code []byte = []byte(`
+	x = -a * b
+	x = -(a * b)
+	x = -a + b
+	x = -a ** 2
+	x = -2 ** 2
+	x = !c && d
+	`)
+
+	a, b := newVar(TYPE_UNKNOWN, "a", false), newVar(TYPE_UNKNOWN, "b", false)
+	c, d := newVar(TYPE_UNKNOWN, "c", false), newVar(TYPE_UNKNOWN, "d", false)
+	x := []Variable{newVar(TYPE_UNKNOWN, "x", false)}
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment(x, []Expression{newBinary(OP_MULT, newUnary(OP_NEGATIVE, a), b, TYPE_UNKNOWN, false)}),
+		newAssignment(x, []Expression{newUnary(OP_NEGATIVE, newBinary(OP_MULT, a, b, TYPE_UNKNOWN, true))}),
+		newAssignment(x, []Expression{newBinary(OP_PLUS, newUnary(OP_NEGATIVE, a), b, TYPE_UNKNOWN, false)}),
+		newAssignment(x, []Expression{newUnary(OP_NEGATIVE, newBinary(OP_POW, a, newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false))}),
+		newAssignment(x, []Expression{newUnary(OP_NEGATIVE, newBinary(OP_POW, newConst(TYPE_INT, "2"), newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false))}),
+		newAssignment(x, []Expression{newBinary(OP_AND, newUnary(OP_NOT, c), d, TYPE_UNKNOWN, false)}),
+	}))
+
+	testAST(code, expected, t)
+}
+
+func TestParserNestingTooDeep(t *testing.T) {
+
+	n := 10000
+	parentheses := "a = " + strings.Repeat("(", n) + "1" + strings.Repeat(")", n)
+	operators := "a = " + strings.Repeat("1 + ", n) + "1"
+	unary := "a = " + strings.Repeat("!", n) + "true"
+
+	for _, code := range []string{parentheses, operators, unary} {
+		testParseError([]byte(code), "Expression too deeply nested (more than 1000 levels)", t)
+	}
+
+	// Just below the limit is fine
+	n = maxExpressionDepth - 1
+	code := []byte("a = " + strings.Repeat("(", n) + "1" + strings.Repeat(")", n))
+	tokenChan, _, stop := lex(code)
+	defer stop()
+	if _, err := parse(tokenChan); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParserElseIf(t *testing.T) {
+
+	var code []byte = []byte(`
+	if a == 1 {
+		b = 1
+	} else if a == 2 {
+		b = 2
+	} else {
+		b = 3
+	}
+	`)
+
+	a, b := newVar(TYPE_UNKNOWN, "a", false), []Variable{newVar(TYPE_UNKNOWN, "b", false)}
+
+	expected := newAST(newBlock([]Statement{
+		newCondition(
+			newBinary(OP_EQ, a, newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false),
+			newBlock([]Statement{newAssignment(b, []Expression{newConst(TYPE_INT, "1")})}),
+			newBlock([]Statement{
+				newCondition(
+					newBinary(OP_EQ, a, newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false),
+					newBlock([]Statement{newAssignment(b, []Expression{newConst(TYPE_INT, "2")})}),
+					newBlock([]Statement{newAssignment(b, []Expression{newConst(TYPE_INT, "3")})}),
+				),
+			}),
+		),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte("if true {\n} else a = 1"), "[1:7] - Expected '{' or 'if' after 'else' in condition, got IDENTIFIER \"a\"", t)
+	testParseError([]byte("if true {\n} else if {\n}"), "Expected expression after 'if' keyword", t)
+}
+
+func TestParserConstType(t *testing.T) {
+
+	for c, expected := range map[string]Type{
+		"5": TYPE_INT, "-0": TYPE_INT, "00": TYPE_INT, "-8": TYPE_INT,
+		"5.0": TYPE_FLOAT, "-0.5": TYPE_FLOAT, "00.50": TYPE_FLOAT,
+		"5u": TYPE_UINT, "0u": TYPE_UINT,
+		`"a"`: TYPE_STRING, "\"a\nb\"": TYPE_STRING, "true": TYPE_BOOL, "false": TYPE_BOOL,
+		// The lexer never emits these
+		"5.": TYPE_UNKNOWN, ".5": TYPE_UNKNOWN, "-5u": TYPE_UNKNOWN, "5.5u": TYPE_UNKNOWN, "1e5": TYPE_UNKNOWN,
+		"0x10": TYPE_UNKNOWN, "1_000": TYPE_UNKNOWN, "truely": TYPE_UNKNOWN, "5abc": TYPE_UNKNOWN,
+	} {
+		if got := getConstType(c); got != expected {
+			t.Errorf("Expected %v to be of type %v, got: %v", c, expected, got)
+		}
+	}
+
+	// Every constant, that the lexer accepts, has a type
+	for _, code := range []string{"5.", ".5", "-0", "00", "0x10", "1_000", "2.5", "-0.0", "7u", "0xFFu", `"\x00"`} {
+		tokens, err := tokenizeAll([]byte("a = " + code))
+		if err != nil {
+			continue
+		}
+		for _, token := range tokens {
+			if token.tokenType == TOKEN_CONSTANT && getConstType(token.value) == TYPE_UNKNOWN {
+				t.Errorf("Expected a type for the constant %v of '%v'", token.value, code)
+			}
+		}
+	}
+}
+
+func TestParserIndex(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = "hello"[1]
+	b = -s[i + 1] * 2
+	`)
+
+	s, i := newVar(TYPE_UNKNOWN, "s", false), newVar(TYPE_UNKNOWN, "i", false)
+	index := Index{s, newBinary(OP_PLUS, i, newConst(TYPE_INT, "1"), TYPE_UNKNOWN, false), TYPE_UNKNOWN, 0, 0}
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{
+			Index{newConst(TYPE_STRING, `"hello"`), newConst(TYPE_INT, "1"), TYPE_UNKNOWN, 0, 0},
+		}),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "b", false)}, []Expression{
+			newBinary(OP_MULT, newUnary(OP_NEGATIVE, index), newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false),
+		}),
+	}))
+
+	testAST(code, expected, t)
+
+	testParseError([]byte(`a = s[1`), "[0:7] - Expected ']' after index, got EOF", t)
+	testParseError([]byte(`a = s[]`), "[0:5] - Invalid index expression", t)
+}
+
+func TestParserOperatorString(t *testing.T) {
+	// OP_UNKNOWN is the only operator without a spelling
+	for op := Operator(0); op < OP_UNKNOWN; op++ {
+		if op.String() == "?" {
+			t.Errorf("Operator %d has no string", int(op))
+		}
+	}
+}
+
+func TestParserStruct(t *testing.T) {
+
+	var code []byte = []byte(`
+	struct p { x: int; name: string }
+	struct q {
+		b: bool
+		u: uint
+	}
+	p.x = 5
+	a = p.x + p.name[0]
+	`)
+
+	p := newVar(TYPE_UNKNOWN, "p", false)
+	x := FieldAccess{p, "x", TYPE_UNKNOWN, 0, 0, 0}
+	name := FieldAccess{p, "name", TYPE_UNKNOWN, 0, 0, 0}
+
+	expected := newAST(newBlock([]Statement{
+		StructDeclaration{p, []StructField{{"x", TYPE_INT, 0, 0, 0}, {"name", TYPE_STRING, 0, 0, 0}}, 0, 0},
+		StructDeclaration{newVar(TYPE_UNKNOWN, "q", false), []StructField{{"b", TYPE_BOOL, 0, 0, 0}, {"u", TYPE_UINT, 0, 0, 0}}, 0, 0},
+		FieldAssignment{x, newConst(TYPE_INT, "5"), 0, 0},
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "a", false)}, []Expression{
+			newBinary(OP_PLUS, x, Index{name, newConst(TYPE_INT, "0"), TYPE_UNKNOWN, 0, 0}, TYPE_UNKNOWN, false),
+		}),
+	}))
+
+	testAST(code, expected, t)
+
+	for code, expected := range map[string]string{
+		"struct { x: int }":          "[0:7] - Expected name after 'struct', got CURLY_OPEN \"{\"",
+		"struct p { x int }":         "[0:11] - Expected field like 'x: int' or '}' in struct, got IDENTIFIER \"x\"",
+		"struct p { x: number }":     "[0:14] - Expected type, got IDENTIFIER \"number\"",
+		"struct p { x: int y: int }": "[0:18] - Expected newline or ';' after field 'x', got LABEL \"y:\"",
+		"struct = 1":                 "[0:0] - 'struct' is a reserved word and can not be used as a variable name",
+		"p.x, a = 1, 2":              "[0:3] - Expected '=' after field 'p.x', got SEPARATOR \",\"",
+		"a = p.":                     "[0:6] - Expected field name after '.', got EOF",
+	} {
+		testParseError([]byte(code), expected, t)
+	}
+}
+
+func TestParserTypeAnnotation(t *testing.T) {
+
+	var code []byte = []byte(`
+	a int, shadow b bool = 1, true
+	const c uint = 5u
+	d string = e = "x"
+	`)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_INT, "a", false), newVar(TYPE_BOOL, "b", true)}, []Expression{
+			newConst(TYPE_INT, "1"), newConst(TYPE_BOOL, "true"),
+		}),
+		newConstDeclaration(newVar(TYPE_UINT, "c", false), newConst(TYPE_UINT, "5u")),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "e", false)}, []Expression{newConst(TYPE_STRING, `"x"`)}),
+		newAssignment([]Variable{newVar(TYPE_STRING, "d", false)}, []Expression{newVar(TYPE_UNKNOWN, "e", false)}),
+	}))
+
+	testAST(code, expected, t)
+
+	// A type is no function to call and ends at the line
+	testParseError([]byte("f int(1)"), `[0:5] - Expected '=' in assignment, got PARENTHESIS_OPEN "("`, t)
+	testParseError([]byte("a\nint = 1"), "[1:0] - Expected '=' in assignment before the end of the line", t)
+}
+
+func TestParserReservedWords(t *testing.T) {
+
+	for code, expected := range map[string]string{
+		"shadow = 1":     "[0:0] - 'shadow' is a reserved word and can not be used as a variable name",
+		"for = 2":        "[0:0] - 'for' is a reserved word and can not be used as a variable name",
+		"a, if = 1, 2":   "[0:3] - 'if' is a reserved word and can not be used as a variable name",
+		"if, a = 1, 2":   "[0:0] - 'if' is a reserved word and can not be used as a variable name",
+		"const = 3":      "[0:0] - 'const' is a reserved word and can not be used as a variable name",
+		"else = 1":       "[0:0] - 'else' is a reserved word and can not be used as a variable name",
+		"true = 1":       "[0:0] - 'true' is a reserved word and can not be used as a variable name",
+		"a = 1\nint":     "[1:0] - Unexpected KEYWORD \"int\" at the start of a statement",
+		"a = shadow + 1": "[0:11] - Expected variable after 'shadow', got OPERATOR \"+\"",
+	} {
+		testParseError([]byte(code), expected, t)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, code []byte) {
+		before := runtime.NumGoroutine()
+
+		// Parsing stops at the first error, long before the lexer is done
+		tokenChan, _, stop := lex(code)
+		parse(tokenChan)
+		stop()
+
+		testLexerStopped(before, t)
+	})
+}
+
+func TestParserBitNot(t *testing.T) {
+
+	var code []byte = []byte("a = ~0\nb = ~a * 2")
+
+	a := newVar(TYPE_UNKNOWN, "a", false)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{a}, []Expression{newUnary(OP_BITNOT, newConst(TYPE_INT, "0"))}),
+		newAssignment([]Variable{newVar(TYPE_UNKNOWN, "b", false)}, []Expression{newBinary(OP_MULT, newUnary(OP_BITNOT, a), newConst(TYPE_INT, "2"), TYPE_UNKNOWN, false)}),
+	}))
+
+	testAST(code, expected, t)
+}
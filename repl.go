@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The interactive mode reads one line at a time. Every line is lexed, parsed and analyzed on its own, but all lines
+// share the same top level symbol table. So variables and constants of earlier lines can be used later on.
+// Nothing is executed! An expression is shown with its type and its value, if the value is known at compile time.
+
+type Session struct {
+	symbolTable SymbolTable
+}
+
+func newSession() *Session {
+	return &Session{
+		SymbolTable{
+			make(map[string]SymbolEntry, 0),
+			nil,
+		},
+	}
+}
+
+// snapshot copies the top level symbol table, so it can be restored after a line fails
+func (session *Session) snapshot() map[string]SymbolEntry {
+	table := make(map[string]SymbolEntry, len(session.symbolTable.table))
+	for name, entry := range session.symbolTable.table {
+		table[name] = entry
+	}
+	return table
+}
+
+// tokenChannel provides already lexed tokens for the parser
+func tokenChannel(tokens []Token) chan Token {
+	c := make(chan Token, len(tokens))
+	for _, t := range tokens {
+		c <- t
+	}
+	return c
+}
+
+// parseSingleExpression returns true, if all tokens form exactly one expression
+func parseSingleExpression(tokens []Token) (Expression, bool) {
+	var tc TokenChannel
+	tc.c = tokenChannel(tokens)
+
+	e, err := parseExpression(&tc)
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := tc.expectToken(TOKEN_EOF, ""); !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func describeExpression(e Expression) string {
+	if c, ok := e.(Constant); ok {
+		return fmt.Sprintf("%v = %v", c.cType, c.cValue)
+	}
+	return fmt.Sprintf("%v", e.getExpressionType())
+}
+
+func describeStatement(statement Statement) string {
+	switch st := statement.(type) {
+	case Assignment:
+		s := make([]string, 0, len(st.variables))
+		for i, v := range st.variables {
+			s = append(s, fmt.Sprintf("%v: %v", v.vName, describeExpression(st.expressions[i])))
+		}
+		return strings.Join(s, ", ")
+	case ConstDeclaration:
+		return fmt.Sprintf("const %v: %v", st.variable.vName, describeExpression(st.expression))
+	case StructDeclaration:
+		return fmt.Sprintf("%v: struct", st.variable.vName)
+	case FieldAssignment:
+		return fmt.Sprintf("%v.%v: %v", st.field.variable.vName, st.field.field, describeExpression(st.expression))
+	}
+	return "ok"
+}
+
+// evaluate analyzes one line against the symbol table of the session and describes the result
+func (session *Session) evaluate(line []byte) (string, []Diagnostic, error) {
+
+	tokens, err := tokenizeAll(line)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if e, ok := parseSingleExpression(tokens); ok {
+		e, err := analyzeTypeExpression(e, &Scope{&session.symbolTable}, newAnalysis())
+		if err != nil {
+			return "", nil, err
+		}
+		return describeExpression(e), nil, nil
+	}
+
+	ast, diagnostics := parse(tokenChannel(tokens))
+	if diagnostics != nil {
+		return "", nil, diagnostics
+	}
+
+	// The block gets the session symbol table as its own. So all new variables are kept for the next line.
+	// A line with an error must not keep the variables it declared before the error.
+	snapshot := session.snapshot()
+	analysis := newAnalysis()
+	block, err := analyzeTypeBlock(ast.block, &Scope{}, &session.symbolTable, analysis)
+	if diagnostics := analysis.diagnostics(err); diagnostics != nil {
+		session.symbolTable.table = snapshot
+		return "", analysis.warnings, diagnostics
+	}
+
+	s := make([]string, 0, len(block.statements))
+	for _, st := range block.statements {
+		s = append(s, describeStatement(st))
+	}
+	return strings.Join(s, "\n"), analysis.warnings, nil
+}
+
+// repl runs an interactive session until the input ends
+func repl(in io.Reader, out io.Writer) {
+	session := newSession()
+	scanner := bufio.NewScanner(in)
+
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			result, warnings, err := session.evaluate([]byte(line))
+			for _, w := range warnings {
+				fmt.Fprintln(out, w)
+			}
+			if err != nil {
+				fmt.Fprintln(out, err)
+			} else if result != "" {
+				fmt.Fprintln(out, result)
+			}
+		}
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReplSession(t *testing.T) {
+
+	input := strings.Join([]string{
+		"a = 1 + 2",
+		"a * 2",
+		"const c = 4 * 2",
+		"c + 1",
+		"",
+		"b = a == c",
+		"b = 5",
+		"if c < 3 {}",
+		"x + 1",
+		"d = 1; e = d + true",
+		"d",
+	}, "\n")
+
+	var out bytes.Buffer
+	repl(strings.NewReader(input), &out)
+
+	expected := strings.Join([]string{
+		"> a: int = 3",
+		"> int",
+		"> const c: int = 8",
+		"> int = 9",
+		"> > b: bool",
+		"> [0:0] - Assignment type missmatch between variable ?(b) and expression int",
+		"> [0:3] - warning - condition is always false",
+		"ok",
+		"> [0:0] - Variable 'x' referenced before declaration",
+		"> [0:11] - BinaryOp '+' expected same type, got: 'int', 'bool'",
+		"> [0:0] - Variable 'd' referenced before declaration",
+		"> ",
+		"",
+	}, "\n")
+
+	if out.String() != expected {
+		t.Errorf("Expected session:\n%v\ngot:\n%v", expected, out.String())
+	}
+}
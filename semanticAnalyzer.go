@@ -1,9 +1,107 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// The common semantic errors wrap one of these instead of ErrCritical directly. They still are critical errors, so
+// errors.Is works for both: errors.Is(err, ErrUndeclared) and errors.Is(err, ErrCritical).
+var (
+	ErrUndeclared    error = errorKind("Name is not declared")
+	ErrRedeclared    error = errorKind("Name is already declared")
+	ErrTypeMismatch  error = errorKind("Types do not match")
+	ErrArityMismatch error = errorKind("Wrong number of values")
+)
+
+// errReported is returned by a block, that stopped after an error. The error itself is in Analysis.errs already.
+var errReported error = errorKind("Block stopped after an error")
+
+// errorKind is a critical error with a text of its own. A positioned error of that kind starts like every other
+// critical error, its message already describes the error. See positionError.
+type errorKind string
+
+func (k errorKind) Error() string {
+	return string(k)
+}
+
+func (k errorKind) Unwrap() error {
+	return ErrCritical
+}
+
+// Analysis is passed through the whole semantic analysis and collects everything, that is not a hard error
+type Analysis struct {
+	// Findings, that don't stop the compilation
+	warnings []Diagnostic
+	// Number of loops around the current statement. 'break' and 'continue' need at least one.
+	loopDepth int
+	// Labels of all blocks around the current statement, innermost last
+	labels     []map[string]Label
+	usedLabels map[int]bool
+	labelCount int
+	// Index of the current statement and the gotos to a later label of all blocks around it. Same order as labels.
+	statementIndex []int
+	forwardGotos   [][]forwardGoto
+	// wshadow warns about every 'shadow', that hides a name of a surrounding block
+	wshadow bool
+	// wunused warns about every variable, that is never read
+	wunused bool
+	// trapv makes a signed overflow in a constant expression an error, as the program would abort there
+	trapv bool
+	// All errors so far. The analysis goes on after an error, as long as it does not lead to follow-up errors.
+	errs []error
+	// unreachable is true, while the current statement follows a jump. See removeUnreachableStatements.
+	unreachable bool
+}
+
+// Builtin describes a function, that is implemented directly by the code generation.
+type Builtin struct {
+	// Number of arguments. All of them have the same type, which is one of argTypes.
+	args     int
+	argTypes []Type
+	// TYPE_UNKNOWN means, that the result has the type of the arguments
+	result Type
+	// sideEffect is true, if calling the function changes anything but its result (e.g. writes output)
+	sideEffect bool
+}
+
+var builtins = map[string]Builtin{
+	"print":  {1, []Type{TYPE_INT, TYPE_UINT, TYPE_BOOL, TYPE_STRING}, TYPE_VOID, true},
+	"len":    {1, []Type{TYPE_STRING}, TYPE_INT, false},
+	"exit":   {1, []Type{TYPE_INT}, TYPE_VOID, true},
+	"min":    {2, []Type{TYPE_INT, TYPE_UINT, TYPE_FLOAT}, TYPE_UNKNOWN, false},
+	"max":    {2, []Type{TYPE_INT, TYPE_UINT, TYPE_FLOAT}, TYPE_UNKNOWN, false},
+	"assert": {1, []Type{TYPE_BOOL}, TYPE_VOID, true},
+}
+
+func newAnalysis() *Analysis {
+	return &Analysis{usedLabels: make(map[int]bool, 0)}
+}
+
+// forwardGoto is a goto to a label of a surrounding block. from is the index of the statement in that block, which
+// contains the goto. Whether it jumps forward is only known, once the whole block is analyzed.
+type forwardGoto struct {
+	jump Goto
+	from int
+}
+
+// getLabel looks for a label in the current and all surrounding blocks. level is the index of its block in labels.
+func (a *Analysis) getLabel(name string) (label Label, level int, ok bool) {
+	for i := len(a.labels) - 1; i >= 0; i-- {
+		if l, ok := a.labels[i][name]; ok {
+			return l, i, true
+		}
+	}
+	return Label{}, 0, false
+}
+
+func (a *Analysis) warn(line, column int, format string, args ...interface{}) {
+	a.warnings = append(a.warnings, newWarning(line, column, fmt.Sprintf(format, args...)))
+}
+
 // get goes through all symbol tables recursively and looks for an entry for the given variable name v
 func (s *SymbolTable) get(v string) (SymbolEntry, bool) {
 	if s == nil {
@@ -24,8 +122,116 @@ func (s *SymbolTable) getLocal(v string) (SymbolEntry, bool) {
 	return se, ok
 }
 
-func (s *SymbolTable) set(v string, t Type) {
-	s.table[v] = SymbolEntry{t, ""}
+// Scope is the stack of nested symbol tables during the semantic analysis, the innermost one on top.
+// All names are defined and resolved through it. So the shadowing rules are in one place (see bind).
+type Scope struct {
+	top *SymbolTable
+}
+
+// push opens a new innermost scope with the given table (e.g. pre-filled for a loop) or a new, empty one.
+func (s *Scope) push(table *SymbolTable) *SymbolTable {
+	if table == nil {
+		table = &SymbolTable{make(map[string]SymbolEntry, 0), nil}
+	}
+	table.parent = s.top
+	s.top = table
+	return table
+}
+
+// pop closes the innermost scope and returns its table
+func (s *Scope) pop() *SymbolTable {
+	table := s.top
+	s.top = table.parent
+	return table
+}
+
+// define adds or replaces the entry for name in the innermost scope
+func (s *Scope) define(name string, entry SymbolEntry) {
+	s.top.table[name] = entry
+}
+
+// resolve looks for name from the innermost to the outermost scope
+func (s *Scope) resolve(name string) (SymbolEntry, bool) {
+	return s.top.get(name)
+}
+
+// resolveLocal only looks into the innermost scope
+func (s *Scope) resolveLocal(name string) (SymbolEntry, bool) {
+	return s.top.getLocal(name)
+}
+
+// bind binds the variable of an assignment to the type of its value:
+//   - A new name is defined in the innermost scope.
+//   - 'shadow' defines the name again in the innermost scope. It must not be defined there already, to avoid
+//     confusion and complicated variable handling.
+//   - Otherwise the existing variable is assigned and keeps its type. Constants can not be assigned.
+func (s *Scope) bind(v Variable, t Type) error {
+
+	entry, exists := s.resolve(v.vName)
+
+	if v.vShadow {
+		if _, ok := s.resolveLocal(v.vName); ok {
+			return errorAt(
+				ErrRedeclared, v.line, v.column,
+				"Variable %v is shadowing another variable in the same block. This is not allowed", v.vName,
+			)
+		}
+		s.define(v.vName, SymbolEntry{sType: t, line: v.line, column: v.column})
+		return nil
+	}
+
+	if !exists {
+		s.define(v.vName, SymbolEntry{sType: t, line: v.line, column: v.column})
+		return nil
+	}
+	if entry.isConst {
+		return errorAt(ErrNormal, v.line, v.column, "Cannot assign to constant '%v'", v.vName)
+	}
+	if entry.sType != t {
+		return errorAt(
+			ErrTypeMismatch, v.line, v.column,
+			"Assignment type missmatch between variable %v and expression %v", v, t,
+		)
+	}
+	return nil
+}
+
+// setUnreachable marks the name in the table, that declares it. See SymbolEntry.unreachable.
+func (s *SymbolTable) setUnreachable(v string, unreachable bool) {
+	if s == nil {
+		return
+	}
+	if entry, ok := s.table[v]; ok {
+		entry.unreachable = unreachable
+		s.table[v] = entry
+		return
+	}
+	s.parent.setUnreachable(v, unreachable)
+}
+
+// setUsed marks the name in the table, that declares it. See SymbolEntry.used.
+func (s *SymbolTable) setUsed(v string) {
+	if s == nil {
+		return
+	}
+	if entry, ok := s.table[v]; ok {
+		entry.used = true
+		s.table[v] = entry
+		return
+	}
+	s.parent.setUsed(v)
+}
+
+// checkReachable rejects the use of a name, that is only declared in unreachable code. Uses within that code are
+// removed with it.
+func checkReachable(v Variable, entry SymbolEntry, analysis *Analysis) error {
+	if entry.unreachable && !analysis.unreachable {
+		return errorAt(
+			ErrUndeclared, v.line, v.column,
+			"Variable '%v' is only declared in unreachable code at [%v:%v]", v.vName, entry.line, entry.column,
+		)
+	}
+	return nil
 }
 
 func (s *SymbolTable) setAsmName(v string, asmName string) {
@@ -43,8 +249,8 @@ func (s *SymbolTable) setAsmName(v string, asmName string) {
 	s.parent.setAsmName(v, asmName)
 }
 
-func analyzeTypeUnaryOp(unaryOp UnaryOp, symbolTable *SymbolTable) (Expression, error) {
-	expression, err := analyzeTypeExpression(unaryOp.expr, symbolTable)
+func analyzeTypeUnaryOp(unaryOp UnaryOp, scope *Scope, analysis *Analysis) (Expression, error) {
+	expression, err := analyzeTypeExpression(unaryOp.expr, scope, analysis)
 	if err != nil {
 		return unaryOp, err
 	}
@@ -55,21 +261,66 @@ func analyzeTypeUnaryOp(unaryOp UnaryOp, symbolTable *SymbolTable) (Expression,
 	switch unaryOp.operator {
 	case OP_NEGATIVE:
 		if t != TYPE_FLOAT && t != TYPE_INT {
-			return nil, fmt.Errorf("%w[%v:%v] - Unary '-' expression must be float or int, but is: %v", ErrCritical, unaryOp.line, unaryOp.column, unaryOp)
+			return nil, errorAt(ErrTypeMismatch, unaryOp.line, unaryOp.column, "Unary '-' expression must be float or int, but is: %v", unaryOp)
 		}
 		unaryOp.opType = expression.getExpressionType()
-		return unaryOp, nil
+		if err := checkFoldOverflow(unaryOp.operator, unaryOp.expr, unaryOp.expr, unaryOp.line, unaryOp.column, analysis); err != nil {
+			return unaryOp, err
+		}
+		return foldUnaryOp(unaryOp)
 	case OP_NOT:
 		if t != TYPE_BOOL {
-			return nil, fmt.Errorf("%w[%v:%v] - Unary '!' expression must be bool, but is: %v", ErrCritical, unaryOp.line, unaryOp.column, unaryOp)
+			return nil, errorAt(ErrTypeMismatch, unaryOp.line, unaryOp.column, "Unary '!' expression must be bool, but is: %v", unaryOp)
 		}
 		unaryOp.opType = TYPE_BOOL
-		return unaryOp, nil
+		return foldUnaryOp(unaryOp)
+	case OP_BITNOT:
+		if t != TYPE_INT && t != TYPE_UINT {
+			return nil, errorAt(ErrTypeMismatch, unaryOp.line, unaryOp.column, "Unary '~' expression must be int or uint, but is: %v", unaryOp)
+		}
+		unaryOp.opType = t
+		return foldUnaryOp(unaryOp)
+	}
+	return nil, errorAt(ErrCritical, unaryOp.line, unaryOp.column, "Unknown unary expression: %v", unaryOp)
+}
+
+// analyzeFloatConstant replaces the literal by its canonical value: '007.50' is '7.5'. A literal, that is too large
+// for a 64 bit float, is an error.
+func analyzeFloatConstant(c Constant) (Expression, error) {
+	v, err := strconv.ParseFloat(c.cValue, 64)
+	if err != nil {
+		return c, errorAt(ErrCritical, c.line, c.column, "Float constant is out of range")
+	}
+	canonical, _ := newFloatConstant(v, c.line, c.column)
+	return canonical, nil
+}
+
+// analyzeIntConstant rejects an int or uint literal, that does not fit into 64 bit. So the constant folding and
+// code generation can rely on every literal to parse.
+func analyzeIntConstant(c Constant) (Expression, error) {
+	var err error
+	if c.cType == TYPE_UINT {
+		_, err = strconv.ParseUint(strings.TrimSuffix(c.cValue, "u"), 10, 64)
+	} else {
+		_, err = strconv.ParseInt(c.cValue, 10, 64)
 	}
-	return nil, fmt.Errorf("%w[%v:%v] - Unknown unary expression: %v", ErrCritical, unaryOp.line, unaryOp.column, unaryOp)
+	if err != nil {
+		return c, errorAt(ErrCritical, c.line, c.column, "Constant %v is out of range for %v", c.cValue, c.cType)
+	}
+	return c, nil
 }
 
-func analyzeTypeBinaryOp(binaryOp BinaryOp, symbolTable *SymbolTable) (Expression, error) {
+// isRelational returns true for the operators, that order their operands
+func isRelational(o Operator) bool {
+	return o == OP_LE || o == OP_GE || o == OP_LESS || o == OP_GREATER
+}
+
+// isComparison returns true for all operators, that compare their operands and result in a bool
+func isComparison(o Operator) bool {
+	return isRelational(o) || o == OP_EQ || o == OP_NE
+}
+
+func analyzeTypeBinaryOp(binaryOp BinaryOp, scope *Scope, analysis *Analysis) (Expression, error) {
 
 	// Re-order expression, if the expression is not fixed and the priority is of the operator is not according to the priority
 	// The priority of an operator must be equal or higher in (right) sub-trees (as they are evaluated first).
@@ -82,13 +333,26 @@ func analyzeTypeBinaryOp(binaryOp BinaryOp, symbolTable *SymbolTable) (Expressio
 		}
 	}
 
-	leftExpression, err := analyzeTypeExpression(binaryOp.leftExpr, symbolTable)
+	// Something like '5 < false <= 8' is read as '5 < (false <= 8)', which is not what anyone means.
+	// Comparing the bool result of a comparison needs explicit parentheses.
+	if isRelational(binaryOp.operator) {
+		for _, e := range []Expression{binaryOp.leftExpr, binaryOp.rightExpr} {
+			if tmpE, ok := e.(BinaryOp); ok && !tmpE.fixed && isComparison(tmpE.operator) {
+				return binaryOp, errorAt(
+					ErrCritical, binaryOp.line, binaryOp.column,
+					"Comparisons can not be chained: '%v' and '%v'. Use parentheses to compare a bool result", binaryOp.operator, tmpE.operator,
+				)
+			}
+		}
+	}
+
+	leftExpression, err := analyzeTypeExpression(binaryOp.leftExpr, scope, analysis)
 	if err != nil {
 		return binaryOp, err
 	}
 	binaryOp.leftExpr = leftExpression
 
-	rightExpression, err := analyzeTypeExpression(binaryOp.rightExpr, symbolTable)
+	rightExpression, err := analyzeTypeExpression(binaryOp.rightExpr, scope, analysis)
 	if err != nil {
 		return binaryOp, err
 	}
@@ -97,11 +361,18 @@ func analyzeTypeBinaryOp(binaryOp BinaryOp, symbolTable *SymbolTable) (Expressio
 	tLeft := binaryOp.leftExpr.getExpressionType()
 	tRight := binaryOp.rightExpr.getExpressionType()
 
+	if tLeft == TYPE_VOID || tRight == TYPE_VOID {
+		return binaryOp, errorAt(
+			ErrTypeMismatch, binaryOp.line, binaryOp.column,
+			"BinaryOp '%v' needs a value on both sides, got a function call without result", binaryOp.operator,
+		)
+	}
+
 	// Check types only after we possibly rearranged the expression!
 	if binaryOp.leftExpr.getExpressionType() != binaryOp.rightExpr.getExpressionType() {
-		return binaryOp, fmt.Errorf(
-			"%w[%v:%v] - BinaryOp '%v' expected same type, got: '%v', '%v'",
-			ErrCritical, binaryOp.line, binaryOp.column, binaryOp.operator, tLeft, tRight,
+		return binaryOp, errorAt(
+			ErrTypeMismatch, binaryOp.line, binaryOp.column,
+			"BinaryOp '%v' expected same type, got: '%v', '%v'", binaryOp.operator, tLeft, tRight,
 		)
 	}
 
@@ -112,95 +383,317 @@ func analyzeTypeBinaryOp(binaryOp BinaryOp, symbolTable *SymbolTable) (Expressio
 		binaryOp.opType = TYPE_BOOL
 		// We know left and right are the same type, so only compare left here.
 		if tLeft != TYPE_BOOL {
-			return binaryOp, fmt.Errorf(
-				"%w[%v:%v] - BinaryOp '%v' needs bool, got: '%v'",
-				ErrCritical, binaryOp.line, binaryOp.column, binaryOp.operator, tLeft,
+			return binaryOp, errorAt(
+				ErrTypeMismatch, binaryOp.line, binaryOp.column,
+				"BinaryOp '%v' needs bool, got: '%v'", binaryOp.operator, tLeft,
 			)
 		}
 		//return binaryOp, TYPE_BOOL, nil
-	case OP_PLUS, OP_MINUS, OP_MULT, OP_DIV:
+	case OP_PLUS, OP_MINUS, OP_MULT, OP_DIV, OP_POW:
 
-		if tLeft == TYPE_FLOAT {
-			binaryOp.opType = TYPE_FLOAT
-		} else {
-			binaryOp.opType = TYPE_INT
-		}
-		if tLeft != TYPE_FLOAT && tLeft != TYPE_INT {
-			return binaryOp, fmt.Errorf(
-				"%w[%v:%v] - BinaryOp '%v' needs int/float, got: '%v'",
-				ErrCritical, binaryOp.line, binaryOp.column, binaryOp.operator, tLeft,
+		binaryOp.opType = tLeft
+		if tLeft != TYPE_FLOAT && tLeft != TYPE_INT && tLeft != TYPE_UINT {
+			return binaryOp, errorAt(
+				ErrTypeMismatch, binaryOp.line, binaryOp.column,
+				"BinaryOp '%v' needs int/uint/float, got: '%v'", binaryOp.operator, tLeft,
 			)
 		}
 		//return binaryOp, tLeft, nil
+	case OP_MOD:
+		binaryOp.opType = tLeft
+		if tLeft != TYPE_FLOAT && tLeft != TYPE_INT && tLeft != TYPE_UINT {
+			return binaryOp, errorAt(
+				ErrTypeMismatch, binaryOp.line, binaryOp.column,
+				"BinaryOp '%v' needs int/uint/float, got: '%v'", binaryOp.operator, tLeft,
+			)
+		}
 	case OP_LE, OP_GE, OP_LESS, OP_GREATER:
 		binaryOp.opType = TYPE_BOOL
-		if tLeft != TYPE_FLOAT && tLeft != TYPE_INT && tLeft != TYPE_STRING {
-			return binaryOp, fmt.Errorf(
-				"%w[%v:%v] - BinaryOp '%v' needs int/float/string, got: '%v'",
-				ErrCritical, binaryOp.line, binaryOp.column, binaryOp.operator, tLeft,
+		if tLeft != TYPE_FLOAT && tLeft != TYPE_INT && tLeft != TYPE_UINT && tLeft != TYPE_STRING {
+			return binaryOp, errorAt(
+				ErrTypeMismatch, binaryOp.line, binaryOp.column,
+				"BinaryOp '%v' needs int/uint/float/string, got: '%v'", binaryOp.operator, tLeft,
 			)
 		}
 		//return binaryOp, TYPE_BOOL, nil
 	case OP_EQ, OP_NE:
 		binaryOp.opType = TYPE_BOOL
 		// We can actually compare all data types. So there will be no missmatch in general!
+		// But rounding makes floats, that should be equal, differ slightly. 'abs(a - b) < 0.001' is meant most of the time.
+		if tLeft == TYPE_FLOAT {
+			analysis.warn(binaryOp.line, binaryOp.column, "floating-point equality comparison may be unreliable")
+		}
 	default:
-		return binaryOp, fmt.Errorf(
-			"%w[%v:%v] - Invalid binary operator: '%v' for type '%v'",
-			ErrCritical, binaryOp.line, binaryOp.column, binaryOp.operator, tLeft,
+		return binaryOp, errorAt(
+			ErrTypeMismatch, binaryOp.line, binaryOp.column,
+			"Invalid binary operator: '%v' for type '%v'", binaryOp.operator, tLeft,
+		)
+	}
+
+	if err := checkFoldOverflow(binaryOp.operator, binaryOp.leftExpr, binaryOp.rightExpr, binaryOp.line, binaryOp.column, analysis); err != nil {
+		return binaryOp, err
+	}
+	return foldBinaryOp(binaryOp)
+}
+
+func analyzeTypeFunctionCall(call FunctionCall, scope *Scope, analysis *Analysis) (Expression, error) {
+
+	builtin, ok := builtins[call.name]
+	if !ok {
+		return call, errorAt(ErrUndeclared, call.line, call.column, "Unknown function '%v'", call.name)
+	}
+	if len(call.args) != builtin.args {
+		plural := ""
+		if builtin.args != 1 {
+			plural = "s"
+		}
+		return call, errorAt(
+			ErrArityMismatch, call.line, call.column,
+			"Function '%v' expects %v argument%v, got %v", call.name, builtin.args, plural, len(call.args),
 		)
 	}
 
-	return binaryOp, nil
+	for i, a := range call.args {
+		expression, err := analyzeTypeExpression(a, scope, analysis)
+		if err != nil {
+			return call, err
+		}
+		call.args[i] = expression
+	}
+
+	t := call.args[0].getExpressionType()
+	for _, a := range call.args[1:] {
+		if a.getExpressionType() != t {
+			row, col := a.startPos()
+			return call, errorAt(
+				ErrTypeMismatch, row, col,
+				"Function '%v' expects arguments of the same type, got '%v' and '%v'", call.name, t, a.getExpressionType(),
+			)
+		}
+	}
+	for _, argType := range builtin.argTypes {
+		if t == argType {
+			call.fType = builtin.result
+			if call.fType == TYPE_UNKNOWN {
+				call.fType = t
+			}
+			if err := checkExitCode(call); err != nil {
+				return call, err
+			}
+			return foldFunctionCall(call), nil
+		}
+	}
+	row, col := call.args[0].startPos()
+	return call, errorAt(ErrTypeMismatch, row, col, "Function '%v' can not be called with '%v'", call.name, t)
 }
 
-func analyzeTypeExpression(expression Expression, symbolTable *SymbolTable) (Expression, error) {
+// checkExitCode makes sure, that a constant exit code fits into the 8 bit, the process gets. An exit code, that is only
+// known at run time, is cut to its lowest 8 bit by the operating system.
+func checkExitCode(call FunctionCall) error {
+	c, ok := call.args[0].(Constant)
+	if call.name != "exit" || !ok {
+		return nil
+	}
+	if code, err := strconv.ParseInt(c.cValue, 10, 64); err != nil || code < 0 || code > 255 {
+		return errorAt(ErrCritical, c.line, c.column, "Exit code %v is out of range 0..255", c.cValue)
+	}
+	return nil
+}
+
+// conversions lists the types, that can be converted into each type. Every type can be converted into itself.
+var conversions = map[Type][]Type{
+	TYPE_INT:    {TYPE_INT, TYPE_UINT, TYPE_FLOAT, TYPE_BOOL},
+	TYPE_UINT:   {TYPE_INT, TYPE_UINT, TYPE_FLOAT, TYPE_BOOL},
+	TYPE_FLOAT:  {TYPE_INT, TYPE_UINT, TYPE_FLOAT},
+	TYPE_BOOL:   {TYPE_INT, TYPE_UINT, TYPE_BOOL},
+	TYPE_STRING: {TYPE_STRING},
+}
+
+func analyzeTypeConversion(conversion Conversion, scope *Scope, analysis *Analysis) (Expression, error) {
+
+	expression, err := analyzeTypeExpression(conversion.expr, scope, analysis)
+	if err != nil {
+		return conversion, err
+	}
+	conversion.expr = expression
+
+	t := expression.getExpressionType()
+	for _, from := range conversions[conversion.cType] {
+		if t == from {
+			return foldConversion(conversion), nil
+		}
+	}
+	return conversion, errorAt(
+		ErrTypeMismatch, conversion.line, conversion.column,
+		"Cannot convert '%v' to '%v'", t, conversion.cType,
+	)
+}
+
+func analyzeTypeIndex(index Index, scope *Scope, analysis *Analysis) (Expression, error) {
+
+	expression, err := analyzeTypeExpression(index.expr, scope, analysis)
+	if err != nil {
+		return index, err
+	}
+	index.expr = expression
+
+	i, err := analyzeTypeExpression(index.index, scope, analysis)
+	if err != nil {
+		return index, err
+	}
+	index.index = i
+
+	if t := expression.getExpressionType(); t != TYPE_STRING {
+		return index, errorAt(ErrTypeMismatch, index.line, index.column, "Only strings can be indexed, got '%v'", t)
+	}
+	if t := i.getExpressionType(); t != TYPE_INT {
+		row, col := i.startPos()
+		return index, errorAt(ErrTypeMismatch, row, col, "Index must be int, got '%v'", t)
+	}
+	index.iType = TYPE_INT
+
+	return foldIndex(index)
+}
+
+// analyzeTypeFieldAccess looks up the field in the layout of the struct variable
+func analyzeTypeFieldAccess(access FieldAccess, scope *Scope, analysis *Analysis) (FieldAccess, error) {
+
+	v := access.variable
+	entry, ok := scope.resolve(v.vName)
+	if !ok {
+		return access, errorAt(ErrUndeclared, v.line, v.column, "Variable '%v' referenced before declaration", v.vName)
+	}
+	if err := checkReachable(v, entry, analysis); err != nil {
+		return access, err
+	}
+	if entry.sType != TYPE_STRUCT {
+		return access, errorAt(ErrTypeMismatch, v.line, v.column, "Variable '%v' is no struct, got '%v'", v.vName, entry.sType)
+	}
+	access.variable.vType = TYPE_STRUCT
+	scope.top.setUsed(v.vName)
+
+	for _, f := range entry.fields {
+		if f.name == access.field {
+			access.fType = f.fType
+			access.offset = f.offset
+			return access, nil
+		}
+	}
+	return access, errorAt(ErrUndeclared, access.line, access.column, "Struct '%v' has no field '%v'", v.vName, access.field)
+}
+
+// hasSideEffect returns true, if evaluating the expression does more than calculating its value
+func hasSideEffect(expression Expression) bool {
+	switch e := expression.(type) {
+	case UnaryOp:
+		return hasSideEffect(e.expr)
+	case BinaryOp:
+		return hasSideEffect(e.leftExpr) || hasSideEffect(e.rightExpr)
+	case Index:
+		return hasSideEffect(e.expr) || hasSideEffect(e.index)
+	case FunctionCall:
+		if builtins[e.name].sideEffect {
+			return true
+		}
+		for _, a := range e.args {
+			if hasSideEffect(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func analyzeTypeExpression(expression Expression, scope *Scope, analysis *Analysis) (Expression, error) {
 
 	switch e := expression.(type) {
 	case Constant:
+		// The lexer should never let this happen. But it must not reach the code generation.
+		if e.cType == TYPE_UNKNOWN {
+			return e, errorAt(ErrCritical, e.line, e.column, "Internal error - Unknown type for constant <<%v>>", e.cValue)
+		}
+		switch e.cType {
+		case TYPE_FLOAT:
+			return analyzeFloatConstant(e)
+		case TYPE_INT, TYPE_UINT:
+			return analyzeIntConstant(e)
+		}
 		return e, nil
 	case Variable:
 
+		if e.vName == blankIdentifier {
+			return e, errorAt(ErrUndeclared, e.line, e.column, "'_' can only be assigned to. It has no value")
+		}
+
 		// Lookup variable type and annotate node.
-		if vTable, ok := symbolTable.get(e.vName); ok {
+		if vTable, ok := scope.resolve(e.vName); ok {
+			// Constants are replaced by their value right away
+			if vTable.isConst {
+				c := vTable.constValue
+				c.line, c.column = e.line, e.column
+				return c, nil
+			}
+			if vTable.sType == TYPE_STRUCT {
+				return e, errorAt(ErrTypeMismatch, e.line, e.column, "Struct '%v' can only be used through its fields", e.vName)
+			}
+			if err := checkReachable(e, vTable, analysis); err != nil {
+				return e, err
+			}
+			scope.top.setUsed(e.vName)
 			e.vType = vTable.sType
 		} else {
-			return e, fmt.Errorf("%w[%v:%v] - Variable '%v' referenced before declaration", ErrCritical, e.line, e.column, e.vName)
+			return e, errorAt(ErrUndeclared, e.line, e.column, "Variable '%v' referenced before declaration", e.vName)
 		}
 		// Always access the very last entry for variables!
 		return e, nil
 	case UnaryOp:
-		return analyzeTypeUnaryOp(e, symbolTable)
+		return analyzeTypeUnaryOp(e, scope, analysis)
 	case BinaryOp:
-		return analyzeTypeBinaryOp(e, symbolTable)
+		return analyzeTypeBinaryOp(e, scope, analysis)
+	case FunctionCall:
+		return analyzeTypeFunctionCall(e, scope, analysis)
+	case Index:
+		return analyzeTypeIndex(e, scope, analysis)
+	case FieldAccess:
+		return analyzeTypeFieldAccess(e, scope, analysis)
+	case Conversion:
+		return analyzeTypeConversion(e, scope, analysis)
 	}
 	row, col := expression.startPos()
-	return expression, fmt.Errorf("%w[%v:%v] - Unknown type for expression %v", ErrCritical, row, col, expression)
+	return expression, errorAt(ErrCritical, row, col, "Unknown type for expression %v", expression)
+}
+
+// warnConstantCondition warns about if/for conditions, that are folded into a constant and never change
+func warnConstantCondition(e Expression, analysis *Analysis) {
+	if c, ok := e.(Constant); ok {
+		analysis.warn(c.line, c.column, "condition is always %v", c.cValue)
+	}
 }
 
-func analyzeTypeCondition(condition Condition, symbolTable *SymbolTable) (Condition, error) {
+func analyzeTypeCondition(condition Condition, scope *Scope, analysis *Analysis) (Condition, error) {
 
 	// This expression MUST come out as boolean!
-	e, err := analyzeTypeExpression(condition.expression, symbolTable)
+	e, err := analyzeTypeExpression(condition.expression, scope, analysis)
 	if err != nil {
 		return condition, err
 	}
 	if e.getExpressionType() != TYPE_BOOL {
 		row, col := e.startPos()
-		return condition, fmt.Errorf(
-			"%w[%v:%v] - If expression expected boolean, got: %v --> <<%v>>",
-			ErrCritical, row, col, e.getExpressionType(), condition.expression,
+		return condition, errorAt(
+			ErrTypeMismatch, row, col,
+			"If expression expected boolean, got: %v --> <<%v>>", e.getExpressionType(), condition.expression,
 		)
 	}
 	condition.expression = e
+	warnConstantCondition(e, analysis)
 
-	block, err := analyzeTypeBlock(condition.block, symbolTable, nil)
+	block, err := analyzeTypeBlock(condition.block, scope, nil, analysis)
 	if err != nil {
 		return condition, err
 	}
 	condition.block = block
 
-	elseBlock, err := analyzeTypeBlock(condition.elseBlock, symbolTable, nil)
+	elseBlock, err := analyzeTypeBlock(condition.elseBlock, scope, nil, analysis)
 	if err != nil {
 		return condition, err
 	}
@@ -209,55 +702,115 @@ func analyzeTypeCondition(condition Condition, symbolTable *SymbolTable) (Condit
 	return condition, nil
 }
 
-func analyzeTypeLoop(loop Loop, symbolTable *SymbolTable) (Loop, error) {
+func analyzeTypeLoop(loop Loop, scope *Scope, analysis *Analysis) (Loop, error) {
 
-	nextSymbolTable := SymbolTable{
-		make(map[string]SymbolEntry, 0),
-		symbolTable,
+	// The variables of the loop header belong to the loop block
+	loopSymbolTable := &SymbolTable{make(map[string]SymbolEntry, 0), nil}
+	loop, err := analyzeTypeLoopHeader(loop, scope, loopSymbolTable, analysis)
+	if err != nil {
+		return loop, err
+	}
+
+	analysis.loopDepth++
+	statements, err := analyzeTypeBlock(loop.block, scope, loopSymbolTable, analysis)
+	analysis.loopDepth--
+	if err != nil {
+		return loop, err
+	}
+	loop.block = statements
+
+	if len(loop.expressions) == 0 && !hasLoopExit(loop.block, false) {
+		analysis.warn(loop.line, loop.column, "possibly infinite loop: no exit condition and no break")
 	}
 
-	assignment, err := analyzeTypeAssignment(loop.assignment, &nextSymbolTable)
+	return loop, nil
+}
+
+// analyzeTypeLoopHeader analyzes the assignments and the condition of the loop. Their variables go into
+// loopSymbolTable, which is only in scope while the header is analyzed. Even after an error, the names are not
+// visible after the loop.
+func analyzeTypeLoopHeader(loop Loop, scope *Scope, loopSymbolTable *SymbolTable, analysis *Analysis) (Loop, error) {
+
+	scope.push(loopSymbolTable)
+	defer scope.pop()
+
+	assignment, err := analyzeTypeAssignment(loop.assignment, scope, analysis)
 	if err != nil {
 		return loop, err
 	}
 	loop.assignment = assignment
 
 	for i, e := range loop.expressions {
-		expression, err := analyzeTypeExpression(e, &nextSymbolTable)
+		expression, err := analyzeTypeExpression(e, scope, analysis)
 		if err != nil {
 			return loop, err
 		}
 		if expression.getExpressionType() != TYPE_BOOL {
 			row, col := expression.startPos()
-			return loop, fmt.Errorf(
-				"%w[%v:%v] - Loop expression expected boolean, got: %v (%v)",
-				ErrCritical, row, col, expression.getExpressionType(), e,
+			return loop, errorAt(
+				ErrTypeMismatch, row, col,
+				"Loop expression expected boolean, got: %v (%v)", expression.getExpressionType(), e,
 			)
 		}
 
 		loop.expressions[i] = expression
+		warnConstantCondition(expression, analysis)
 	}
 
-	incrAssignment, err := analyzeTypeAssignment(loop.incrAssignment, &nextSymbolTable)
+	incrAssignment, err := analyzeTypeAssignment(loop.incrAssignment, scope, analysis)
 	if err != nil {
 		return loop, err
 	}
 	loop.incrAssignment = incrAssignment
 
-	statements, err := analyzeTypeBlock(loop.block, symbolTable, &nextSymbolTable)
-	if err != nil {
-		return loop, err
+	return loop, nil
+}
+
+// hasLoopExit checks, if a loop body contains a 'break' of this loop, a 'goto', that might leave it, or ends the program
+// with 'exit'. A 'break' in a nested loop only leaves the nested one. Unreachable statements are removed already.
+func hasLoopExit(block Block, nested bool) bool {
+	for _, s := range block.statements {
+		switch st := s.(type) {
+		case Break:
+			if !nested {
+				return true
+			}
+		case Goto:
+			return true
+		case ExprStatement:
+			if call, ok := st.expression.(FunctionCall); ok && call.name == "exit" {
+				return true
+			}
+		case Condition:
+			if hasLoopExit(st.block, nested) || hasLoopExit(st.elseBlock, nested) {
+				return true
+			}
+		case Loop:
+			if hasLoopExit(st.block, true) {
+				return true
+			}
+		}
 	}
-	loop.block = statements
-	loop.block.symbolTable = nextSymbolTable
+	return false
+}
 
-	return loop, nil
+// warnShadow warns, if a 'shadow' hides a name of a surrounding block. A new name does not hide anything.
+func warnShadow(v Variable, scope *Scope, analysis *Analysis) {
+	entry, ok := scope.resolve(v.vName)
+	if !ok {
+		return
+	}
+	if entry.predefined {
+		analysis.warn(v.line, v.column, "'%v' shadows the predefined variable", v.vName)
+		return
+	}
+	analysis.warn(v.line, v.column, "'%v' shadows the declaration at [%v:%v]", v.vName, entry.line, entry.column)
 }
 
 // Returns newly created variables and variables that should shadow others!
 // This is just for housekeeping and removing them later!!!!
 // All new variables (and shadow ones) are updated/written to the symbol table
-func analyzeTypeAssignment(assignment Assignment, symbolTable *SymbolTable) (Assignment, error) {
+func analyzeTypeAssignment(assignment Assignment, scope *Scope, analysis *Analysis) (Assignment, error) {
 
 	// Populate/overwrite the dictionary of variables for futher statements :)
 	if len(assignment.variables) != len(assignment.expressions) {
@@ -267,111 +820,452 @@ func analyzeTypeAssignment(assignment Assignment, symbolTable *SymbolTable) (Ass
 			row, col = assignment.variables[0].line, assignment.variables[0].column
 		}
 
-		return assignment, fmt.Errorf(
-			"%w[%v:%v] - Assignment %v - variables and expression count need to match",
-			ErrCritical, row, col, assignment,
+		return assignment, errorAt(
+			ErrArityMismatch, row, col,
+			"Assignment %v - variables and expression count need to match", assignment,
 		)
 	}
 
-	for i, v := range assignment.variables {
+	// 'i++' and 'i--' work for numbers only. The '1' has to match the type of the variable.
+	if assignment.shorthand == "++" || assignment.shorthand == "--" {
+		v := assignment.variables[0]
+		if vTable, ok := scope.resolve(v.vName); ok {
+			switch vTable.sType {
+			case TYPE_INT:
+			case TYPE_UINT:
+				b := assignment.expressions[0].(BinaryOp)
+				b.rightExpr = Constant{TYPE_UINT, "1u", v.line, v.column}
+				assignment.expressions[0] = b
+			case TYPE_FLOAT:
+				b := assignment.expressions[0].(BinaryOp)
+				b.rightExpr = Constant{TYPE_FLOAT, "1.0", v.line, v.column}
+				assignment.expressions[0] = b
+			default:
+				return assignment, errorAt(
+					ErrTypeMismatch, v.line, v.column,
+					"'%v' needs an int, uint or float variable, got: %v", assignment.shorthand, vTable.sType,
+				)
+			}
+		}
+	}
 
-		expression, err := analyzeTypeExpression(assignment.expressions[i], symbolTable)
+	// All expressions are analyzed before any variable is bound. This way, a variable on the left side never refers
+	// to itself on the right side: 'shadow a = a + 1' uses the outer 'a' or fails, if there is none.
+	for i, e := range assignment.expressions {
+		expression, err := analyzeTypeExpression(e, scope, analysis)
 		if err != nil {
 			return assignment, err
 		}
-		expressionType := expression.getExpressionType()
+		assignment.expressions[i] = expression
+	}
+
+	for i, v := range assignment.variables {
+
+		expressionType := assignment.expressions[i].getExpressionType()
+		if expressionType == TYPE_VOID {
+			return assignment, errorAt(
+				ErrTypeMismatch, v.line, v.column,
+				"Cannot assign %v to '%v'. The function does not return a value", assignment.expressions[i], v.vName,
+			)
+		}
 
-		// Shadowing is only allowed in a different block, not right after the first variable, to avoid confusion and complicated
-		// variable handling
-		if _, ok := symbolTable.getLocal(v.vName); ok && v.vShadow {
-			return assignment, fmt.Errorf(
-				"%w[%v:%v] - Variable %v is shadowing another variable in the same block. This is not allowed",
-				ErrCritical, v.line, v.column, v.vName,
+		// A type annotation must match the value
+		if v.vType != TYPE_UNKNOWN && v.vType != expressionType {
+			return assignment, errorAt(
+				ErrTypeMismatch, v.line, v.column,
+				"Variable '%v' is declared as '%v', got '%v'", v.vName, v.vType, expressionType,
 			)
 		}
 
-		// Only, if the variable already exists and we're not trying to shadow it!
-		if vTable, ok := symbolTable.get(v.vName); ok {
-			if !v.vShadow {
-				if vTable.sType != expressionType {
-					return assignment, fmt.Errorf(
-						"%w[%v:%v] - Assignment type missmatch between variable %v and expression %v",
-						ErrCritical, v.line, v.column, v, expressionType,
-					)
-				}
-			} else {
-				symbolTable.set(v.vName, expressionType)
-			}
-		} else {
-			symbolTable.set(v.vName, expressionType)
+		// The value is calculated and dropped. There is no variable to bind.
+		if v.vName == blankIdentifier {
+			assignment.variables[i].vType = expressionType
+			continue
 		}
 
-		assignment.expressions[i] = expression
+		if v.vShadow && analysis.wshadow {
+			warnShadow(v, scope, analysis)
+		}
+
+		_, exists := scope.resolve(v.vName)
+		_, local := scope.resolveLocal(v.vName)
+		if err := scope.bind(v, expressionType); err != nil {
+			return assignment, err
+		}
+		// Only an assignment in the declaring block gets the variable a slot, that lives as long as the name
+		switch {
+		case analysis.unreachable && (v.vShadow || !exists):
+			scope.top.setUnreachable(v.vName, true)
+		case !analysis.unreachable && local:
+			scope.top.setUnreachable(v.vName, false)
+		}
 
 		assignment.variables[i].vType = expressionType
 	}
 	return assignment, nil
 }
 
-func analyzeTypeStatement(statement Statement, symbolTable *SymbolTable) (Statement, error) {
+// analyzeTypeConstDeclaration evaluates the constant expression and adds the value to the symbol table.
+// Every following use of the constant is replaced by the value.
+func analyzeTypeConstDeclaration(constDecl ConstDeclaration, scope *Scope, analysis *Analysis) (ConstDeclaration, error) {
+
+	v := constDecl.variable
+	if _, ok := scope.resolve(v.vName); ok {
+		return constDecl, errorAt(ErrRedeclared, v.line, v.column, "Constant '%v' is already declared", v.vName)
+	}
+
+	expression, err := analyzeTypeExpression(constDecl.expression, scope, analysis)
+	if err != nil {
+		return constDecl, err
+	}
+	c, ok := expression.(Constant)
+	if !ok {
+		row, col := expression.startPos()
+		return constDecl, errorAt(
+			ErrCritical, row, col,
+			"Constant '%v' needs a constant expression, got: %v", v.vName, expression,
+		)
+	}
+
+	if v.vType != TYPE_UNKNOWN && v.vType != c.cType {
+		return constDecl, errorAt(ErrTypeMismatch, v.line, v.column, "Constant '%v' is declared as '%v', got '%v'", v.vName, v.vType, c.cType)
+	}
+
+	constDecl.expression = c
+	constDecl.variable.vType = c.cType
+	scope.define(v.vName, SymbolEntry{sType: c.cType, isConst: true, constValue: c, line: v.line, column: v.column})
+
+	return constDecl, nil
+}
+
+// analyzeTypeStructDeclaration computes the layout of the struct and adds the variable to the symbol table.
+// Every field takes one qword, like any other variable.
+func analyzeTypeStructDeclaration(structDecl StructDeclaration, scope *Scope, analysis *Analysis) (StructDeclaration, error) {
+
+	v := structDecl.variable
+	if _, ok := scope.resolve(v.vName); ok {
+		return structDecl, errorAt(ErrRedeclared, v.line, v.column, "Struct '%v' is already declared", v.vName)
+	}
+	if len(structDecl.fields) == 0 {
+		return structDecl, errorAt(ErrCritical, v.line, v.column, "Struct '%v' needs at least one field", v.vName)
+	}
+
+	fields := make([]StructField, len(structDecl.fields))
+	for i, f := range structDecl.fields {
+		for _, other := range fields[:i] {
+			if other.name == f.name {
+				return structDecl, errorAt(ErrRedeclared, f.line, f.column, "Field '%v' is already declared in struct '%v'", f.name, v.vName)
+			}
+		}
+		f.offset = 8 * i
+		fields[i] = f
+	}
+
+	structDecl.fields = fields
+	structDecl.variable.vType = TYPE_STRUCT
+	scope.define(v.vName, SymbolEntry{sType: TYPE_STRUCT, fields: fields, line: v.line, column: v.column, unreachable: analysis.unreachable})
+
+	return structDecl, nil
+}
+
+// analyzeTypeFieldAssignment checks, that the value has the type of the field
+func analyzeTypeFieldAssignment(assignment FieldAssignment, scope *Scope, analysis *Analysis) (FieldAssignment, error) {
+
+	access, err := analyzeTypeFieldAccess(assignment.field, scope, analysis)
+	if err != nil {
+		return assignment, err
+	}
+	assignment.field = access
+
+	expression, err := analyzeTypeExpression(assignment.expression, scope, analysis)
+	if err != nil {
+		return assignment, err
+	}
+	assignment.expression = expression
+
+	if t := expression.getExpressionType(); t != access.fType {
+		return assignment, errorAt(
+			ErrTypeMismatch, access.line, access.column,
+			"Assignment type missmatch between field %v.%v ('%v') and expression '%v'", access.variable.vName, access.field, access.fType, t,
+		)
+	}
+	return assignment, nil
+}
+
+func analyzeTypeStatement(statement Statement, scope *Scope, analysis *Analysis) (Statement, error) {
 	switch st := statement.(type) {
+	case ConstDeclaration:
+		return analyzeTypeConstDeclaration(st, scope, analysis)
+	case StructDeclaration:
+		return analyzeTypeStructDeclaration(st, scope, analysis)
+	case FieldAssignment:
+		return analyzeTypeFieldAssignment(st, scope, analysis)
 	case Condition:
-		return analyzeTypeCondition(st, symbolTable)
+		return analyzeTypeCondition(st, scope, analysis)
 	case Loop:
-		return analyzeTypeLoop(st, symbolTable)
+		return analyzeTypeLoop(st, scope, analysis)
 	case Assignment:
-		assignment, err := analyzeTypeAssignment(st, symbolTable)
+		assignment, err := analyzeTypeAssignment(st, scope, analysis)
 		if err != nil {
 			return assignment, err
 		}
 		return assignment, nil
+	case ExprStatement:
+		expression, err := analyzeTypeExpression(st.expression, scope, analysis)
+		if err != nil {
+			return st, err
+		}
+		// The warning shows the call as written, not folded
+		if !hasSideEffect(expression) {
+			analysis.warn(st.line, st.column, "result of '%v' is not used and has no effect", st.expression)
+		}
+		st.expression = expression
+		return st, nil
+	case Label:
+		return st, nil
+	case Goto:
+		label, level, ok := analysis.getLabel(st.label)
+		if !ok {
+			return st, errorAt(
+				ErrUndeclared, st.line, st.column,
+				"Label '%v' is not defined in this or any surrounding block", st.label,
+			)
+		}
+		analysis.usedLabels[label.id] = true
+		st.id = label.id
+		analysis.forwardGotos[level] = append(analysis.forwardGotos[level], forwardGoto{st, analysis.statementIndex[level]})
+		return st, nil
+	case Break, Continue:
+		if analysis.loopDepth == 0 {
+			row, col := st.startPos()
+			return statement, errorAt(ErrCritical, row, col, "'%v' is only allowed inside a loop", st)
+		}
+		return statement, nil
 	}
 	row, col := statement.startPos()
-	return statement, fmt.Errorf("%w[%v:%v] - Unexpected statement: %v", ErrCritical, row, col, statement)
+	return statement, errorAt(ErrCritical, row, col, "Unexpected statement: %v", statement)
 }
 
 // analyzeTypeBlock gets a reference to the current (now parent) symbol table
 // Additionally, it might get a pre-filled symbol table for the new scope to use!
 // This might be the case for function arguments or in a for-loop, where variables belong to the
 // coming block only but are parsed in the TreeNode before.
-func analyzeTypeBlock(block Block, symbolTable, newBlockSymbolTable *SymbolTable) (Block, error) {
+func analyzeTypeBlock(block Block, scope *Scope, newBlockSymbolTable *SymbolTable, analysis *Analysis) (Block, error) {
 
-	if newBlockSymbolTable != nil {
-		block.symbolTable = *newBlockSymbolTable
-	} else {
-		block.symbolTable = SymbolTable{
-			make(map[string]SymbolEntry, 0),
-			symbolTable,
+	symbolTable := scope.push(newBlockSymbolTable)
+	defer scope.pop()
+
+	// All labels of the block are known beforehand, so a goto can jump forward.
+	labels := make(map[string]Label, 0)
+	for i, s := range block.statements {
+		if l, ok := s.(Label); ok {
+			if _, exists := labels[l.name]; exists {
+				return block, errorAt(ErrRedeclared, l.line, l.column, "Label '%v' is already defined", l.name)
+			}
+			if _, _, exists := analysis.getLabel(l.name); exists {
+				return block, errorAt(ErrRedeclared, l.line, l.column, "Label '%v' is already defined", l.name)
+			}
+			l.id = analysis.labelCount
+			analysis.labelCount++
+			labels[l.name] = l
+			block.statements[i] = l
 		}
 	}
+	analysis.labels = append(analysis.labels, labels)
+	analysis.statementIndex = append(analysis.statementIndex, 0)
+	analysis.forwardGotos = append(analysis.forwardGotos, nil)
+	level := len(analysis.labels) - 1
+	defer func() {
+		analysis.labels = analysis.labels[:level]
+		analysis.statementIndex = analysis.statementIndex[:level]
+		analysis.forwardGotos = analysis.forwardGotos[:level]
+	}()
+
+	var current Statement
+	defer func() { positionPanic(recover(), current) }()
+
+	// The statements after a jump are removed. A nested block in them is unreachable as a whole.
+	outer := analysis.unreachable
+	defer func() { analysis.unreachable = outer }()
+	jumped := false
+	// declares[i] is true, if statement i adds a name to this block, that is visible to all following statements
+	declares := make([]bool, len(block.statements))
 
 	for i, s := range block.statements {
-		statement, err := analyzeTypeStatement(s, &block.symbolTable)
+		if i > 0 {
+			switch block.statements[i-1].(type) {
+			case Break, Continue, Goto:
+				jumped = true
+			}
+		}
+		if _, ok := s.(Label); ok {
+			jumped = false
+		}
+		current = s
+		analysis.unreachable = outer || jumped
+		analysis.statementIndex[level] = i
+		// Names declared in unreachable code can not be used after a label anyway. See checkReachable.
+		declares[i] = !analysis.unreachable && declaresName(s, scope)
+		statement, err := analyzeTypeStatement(s, scope, analysis)
 		if err != nil {
-			return block, err
+			if !errors.Is(err, errReported) {
+				analysis.errs = append(analysis.errs, err)
+			}
+			// The following statements might use the name. Every use would be another error with the same cause.
+			if declaresName(s, scope) {
+				return block, errReported
+			}
+			continue
 		}
 		block.statements[i] = statement
 	}
 
+	checkForwardGotos(block, declares, analysis.forwardGotos[level], analysis)
+
+	for _, s := range block.statements {
+		if l, ok := s.(Label); ok && !analysis.usedLabels[l.id] {
+			analysis.warn(l.line, l.column, "label '%v' is never used", l.name)
+		}
+	}
+
+	if analysis.wunused {
+		warnUnused(symbolTable, analysis)
+	}
+
+	removeUnreachableStatements(&block, analysis)
+	block.symbolTable = *symbolTable
+
 	return block, nil
 }
 
+// warnUnused warns about the variables of the table, that are never read. Assigning a value is no use.
+// Names of unreachable code are already part of that warning.
+func warnUnused(table *SymbolTable, analysis *Analysis) {
+	names := make([]string, 0)
+	for name, entry := range table.table {
+		if !entry.used && !entry.isConst && !entry.predefined && !entry.unreachable {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := table.table[names[i]], table.table[names[j]]
+		return a.line < b.line || a.line == b.line && a.column < b.column
+	})
+	for _, name := range names {
+		entry := table.table[name]
+		analysis.warn(entry.line, entry.column, "'%v' is declared but never used", name)
+	}
+}
+
+// checkForwardGotos rejects a goto, that jumps forward over a declaration of the label's block. The name is still
+// visible at the label, but its value was never set.
+func checkForwardGotos(block Block, declares []bool, gotos []forwardGoto, analysis *Analysis) {
+	for _, g := range gotos {
+		target := g.from
+		for i, s := range block.statements {
+			if l, ok := s.(Label); ok && l.id == g.jump.id {
+				target = i
+			}
+		}
+		for i := g.from + 1; i < target; i++ {
+			if declares[i] {
+				row, col := block.statements[i].startPos()
+				analysis.errs = append(analysis.errs, errorAt(
+					ErrCritical, g.jump.line, g.jump.column,
+					"'goto %v' jumps over the declaration at [%v:%v]", g.jump.label, row, col,
+				))
+				break
+			}
+		}
+	}
+}
+
+// declaresName checks, if the statement would add a name to the current block
+func declaresName(statement Statement, scope *Scope) bool {
+	switch s := statement.(type) {
+	case Assignment:
+		for _, v := range s.variables {
+			if _, ok := scope.resolve(v.vName); v.vName != blankIdentifier && (v.vShadow || !ok) {
+				return true
+			}
+		}
+	case ConstDeclaration, StructDeclaration:
+		return true
+	}
+	return false
+}
+
+// diagnostics returns all errors of the analysis. err is the error, that stopped it, if any.
+func (a *Analysis) diagnostics(err error) Diagnostics {
+	if err != nil && !errors.Is(err, errReported) {
+		a.errs = append(a.errs, err)
+	}
+	if len(a.errs) == 0 {
+		return nil
+	}
+	diagnostics := make(Diagnostics, 0, len(a.errs))
+	for _, e := range a.errs {
+		diagnostics = append(diagnostics, newDiagnostic(e))
+	}
+	return diagnostics
+}
+
+// removeUnreachableStatements drops all statements following a 'break', 'continue' or 'goto' in the same block.
+// They can never be executed, so a warning is given for the first one. A label makes the code reachable again.
+func removeUnreachableStatements(block *Block, analysis *Analysis) {
+	statements := make([]Statement, 0, len(block.statements))
+	// The last jump, that makes the following statements unreachable
+	var jump Statement
+	warned := false
+
+	for _, s := range block.statements {
+		if _, ok := s.(Label); ok {
+			jump = nil
+		}
+		if jump != nil {
+			// Warn only once for every unreachable section
+			if !warned {
+				row, col := s.startPos()
+				analysis.warn(row, col, "unreachable code after '%v'", jump)
+				warned = true
+			}
+			continue
+		}
+		statements = append(statements, s)
+
+		switch s.(type) {
+		case Break, Continue, Goto:
+			jump = s
+			warned = false
+		}
+	}
+	block.statements = statements
+}
+
 // analyzeTypes traverses the tree and analyzes variables with their corresponding type recursively from expressions!
 // returns an error if we have a type missmatch anywhere!
-func semanticAnalysis(ast AST) (AST, error) {
+func semanticAnalysis(ast AST, options Options) (AST, Diagnostics) {
 
 	ast.globalSymbolTable = SymbolTable{
 		make(map[string]SymbolEntry, 0),
 		nil,
 	}
 
-	// TODO: Possibly fill global symbol table with something?
-	// Right now it will stay empty just because the block we parse will create its own symbol table.
+	// The number of program arguments (including the program name) is a predefined variable
+	ast.globalSymbolTable.table["argc"] = SymbolEntry{sType: TYPE_INT, predefined: true}
 
-	block, err := analyzeTypeBlock(ast.block, &ast.globalSymbolTable, nil)
-	if err != nil {
+	var scope Scope
+	scope.push(&ast.globalSymbolTable)
+
+	analysis := newAnalysis()
+	analysis.wshadow = options.wshadow
+	analysis.wunused = options.wunused
+	analysis.trapv = options.trapv
+	block, err := analyzeTypeBlock(ast.block, &scope, nil, analysis)
+	ast.warnings = analysis.warnings
+	if diagnostics := analysis.diagnostics(err); diagnostics != nil {
 		ast.globalSymbolTable = SymbolTable{}
-		return ast, err
+		return ast, diagnostics
 	}
 	ast.block = block
 
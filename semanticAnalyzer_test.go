@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// analyzeCode runs the lexer, parser and semantic analysis on the given code
+func analyzeCode(code []byte) (AST, error) {
+	tokenChan, lexerErr, stop := lex(code)
+	defer stop()
+
+	ast, diagnostics := parse(tokenChan)
+	select {
+	case e := <-lexerErr:
+		return ast, e
+	default:
+	}
+	if diagnostics != nil {
+		return ast, diagnostics
+	}
+	ast, diagnostics = semanticAnalysis(ast, Options{})
+	if diagnostics != nil {
+		return ast, diagnostics
+	}
+	return ast, nil
+}
+
+func testSemantic(code []byte, t *testing.T) AST {
+	ast, err := analyzeCode(code)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	return ast
+}
+
+// testSemanticError expects the analysis to fail with an error message containing the expected string
+func testSemanticError(code []byte, expected string, t *testing.T) {
+	_, err := analyzeCode(code)
+	if err == nil {
+		t.Errorf("Expected error containing '%v', got none", expected)
+		return
+	}
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing '%v', got: %v", expected, err)
+	}
+}
+
+func TestSemanticErrorKinds(t *testing.T) {
+
+	cases := []struct {
+		code     string
+		expected error
+	}{
+		{"a = 1 + 2.0", ErrTypeMismatch},
+		{"a = b", ErrUndeclared},
+		{"goto end", ErrUndeclared},
+		{"a, b = 1", ErrArityMismatch},
+		{"a = len(\"a\", \"b\")", ErrArityMismatch},
+		{"const a = 1\nconst a = 2", ErrRedeclared},
+	}
+
+	for _, c := range cases {
+		_, err := analyzeCode([]byte(c.code))
+		if !errors.Is(err, c.expected) {
+			t.Errorf("Expected '%v' to fail with the matching error kind, got: %v", c.code, err)
+		}
+		if !errors.Is(err, ErrCritical) {
+			t.Errorf("Expected '%v' to fail with a critical error, got: %v", c.code, err)
+		}
+	}
+
+	// The kinds are distinct
+	if _, err := analyzeCode([]byte("a = b")); errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected an undeclared variable to be no type mismatch")
+	}
+
+	// Every kind has a text of its own. A positioned error still starts with its position.
+	for _, kind := range []error{ErrUndeclared, ErrRedeclared, ErrTypeMismatch, ErrArityMismatch, errReported} {
+		if kind.Error() == "" {
+			t.Errorf("Expected a text for every error kind")
+		}
+	}
+	if err := errorAt(ErrTypeMismatch, 1, 2, "Index must be int"); err.Error() != "[1:2] - Index must be int" {
+		t.Errorf("Expected '[1:2] - Index must be int', got: %v", err)
+	}
+}
+
+func TestSemanticMultipleErrors(t *testing.T) {
+
+	// Errors in nested blocks don't stop the analysis either
+	_, err := analyzeCode([]byte("a = 1\na = true\nif a > 0 {\n\tprint(1.5)\n}\nexit(a + 0.5)"))
+	var diagnostics Diagnostics
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 3 {
+		t.Fatalf("Expected 3 errors, got: %v", err)
+	}
+
+	// A declaration, that failed, stops the analysis. Every use of 'b' would be an error as well.
+	_, err = analyzeCode([]byte("b = 1 + \"s\"\nc = b\nd = b"))
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 1 {
+		t.Errorf("Expected only the first error, got: %v", err)
+	}
+
+	// The names of a loop header and the labels of a block are gone after them, even if they had an error
+	_, err = analyzeCode([]byte("for i = 0; x; i = i + 1 {\n}\nc = i"))
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 ||
+		!strings.Contains(diagnostics[1].Error(), "[2:4] - Variable 'i' referenced before declaration") {
+		t.Errorf("Expected errors for 'x' and 'i', got: %v", err)
+	}
+	_, err = analyzeCode([]byte("if true {\n\tl:\n\ta = 1 + \"s\"\n\tgoto l\n}\ngoto l"))
+	if !errors.As(err, &diagnostics) || len(diagnostics) != 2 ||
+		!strings.Contains(diagnostics[1].Error(), "[5:0] - Label 'l' is not defined in this or any surrounding block") {
+		t.Errorf("Expected errors for 'a' and the label, got: %v", err)
+	}
+}
+
+func TestSemanticShadowUsesOuterVariable(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	if true {
+		shadow a = a == 1
+	}
+	`)
+
+	ast := testSemantic(code, t)
+
+	assignment := ast.block.statements[1].(Condition).block.statements[0].(Assignment)
+	if assignment.variables[0].vType != TYPE_BOOL {
+		t.Errorf("Expected shadowing variable to be bool, got: %v", assignment.variables[0].vType)
+	}
+	if v := assignment.expressions[0].(BinaryOp).leftExpr.(Variable); v.vType != TYPE_INT {
+		t.Errorf("Expected right side to refer to the outer int variable, got: %v", v.vType)
+	}
+}
+
+func TestSemanticShadowWithoutOuterVariable(t *testing.T) {
+
+	var code []byte = []byte(`
+	if true {
+		shadow a = a + 1
+	}
+	`)
+
+	testSemanticError(code, "[2:13] - Variable 'a' referenced before declaration", t)
+}
+
+func TestSemanticConst(t *testing.T) {
+
+	var code []byte = []byte(`
+	const a = 2 * 3
+	b = a + 1
+	`)
+
+	ast := testSemantic(code, t)
+
+	constDecl := ast.block.statements[0].(ConstDeclaration)
+	if c, ok := constDecl.expression.(Constant); !ok || c.cValue != "6" {
+		t.Errorf("Expected constant to be folded to 6, got: %v", constDecl.expression)
+	}
+	assignment := ast.block.statements[1].(Assignment)
+	if c, ok := assignment.expressions[0].(Constant); !ok || c.cType != TYPE_INT || c.cValue != "7" {
+		t.Errorf("Expected constant to be substituted and folded to 7, got: %v", assignment.expressions[0])
+	}
+}
+
+func TestSemanticConstReassignment(t *testing.T) {
+
+	var code []byte = []byte(`
+	const a = 1
+	a = 2
+	`)
+
+	_, err := analyzeCode(code)
+	if !errors.Is(err, ErrNormal) {
+		t.Fatalf("Expected normal error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "[2:1] - Cannot assign to constant 'a'") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
+
+func TestSemanticConstNotConstant(t *testing.T) {
+
+	var code []byte = []byte(`
+	b = 1
+	const a = b + 1
+	`)
+
+	testSemanticError(code, "[2:11] - Constant 'a' needs a constant expression", t)
+}
+
+// testWarnings expects exactly the given warnings (as strings) in the given order
+func testWarnings(code []byte, expected []string, t *testing.T) {
+	ast := testSemantic(code, t)
+
+	if len(ast.warnings) != len(expected) {
+		t.Fatalf("Expected %v warnings, got: %v", len(expected), ast.warnings)
+	}
+	for i, w := range ast.warnings {
+		if w.Error() != expected[i] {
+			t.Errorf("Expected warning '%v', got: '%v'", expected[i], w)
+		}
+	}
+}
+
+func TestSemanticWarnConditionAlwaysTrue(t *testing.T) {
+
+	var code []byte = []byte(`
+	if true {
+	}
+	`)
+
+	testWarnings(code, []string{"[1:4] - warning - condition is always true"}, t)
+}
+
+func TestSemanticWarnFloatEquality(t *testing.T) {
+
+	var code []byte = []byte(`
+	x = 0.1
+	a = x == 2.0
+	b = x != 0.3
+	c = 1 == 2
+	d = (x - 0.3 < 0.001) && (0.3 - x < 0.001)
+	e = 1.0 == 2.0
+	`)
+
+	testWarnings(code, []string{
+		"[2:5] - warning - floating-point equality comparison may be unreliable",
+		"[3:5] - warning - floating-point equality comparison may be unreliable",
+		"[6:5] - warning - floating-point equality comparison may be unreliable",
+	}, t)
+}
+
+func TestSemanticWarnConditionAlwaysFalse(t *testing.T) {
+
+	var code []byte = []byte(`
+	const a = 5
+	if a < 3 {
+	}
+	for i = 0; false; i = i+1 {
+	}
+	`)
+
+	testWarnings(code, []string{
+		"[2:4] - warning - condition is always false",
+		"[4:12] - warning - condition is always false",
+	}, t)
+}
+
+func TestSemanticNoWarningForVariableCondition(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 5
+	if a < 3 {
+	}
+	`)
+
+	testWarnings(code, []string{}, t)
+}
+
+func TestSemanticUnreachableAfterBreak(t *testing.T) {
+
+	var code []byte = []byte(`
+	for i = 0; i < 5; i = i+1 {
+		break
+		a = i
+	}
+	`)
+
+	testWarnings(code, []string{"[3:2] - warning - unreachable code after 'break'"}, t)
+
+	ast := testSemantic(code, t)
+	loop := ast.block.statements[0].(Loop)
+	if len(loop.block.statements) != 1 {
+		t.Errorf("Expected unreachable statements to be removed, got: %v", loop.block.statements)
+	}
+}
+
+// A name declared after a jump has no value after the next label. Its declaration is removed.
+func TestSemanticUnreachableDeclaration(t *testing.T) {
+
+	testSemanticError([]byte("goto end\na = 1\nend:\nb = a"), "[3:4] - Variable 'a' is only declared in unreachable code at [1:0]", t)
+	testSemanticError([]byte("goto end\nstruct p { x: int }\nend:\nb = p.x"), "[3:4] - Variable 'p' is only declared in unreachable code at [1:7]", t)
+	testSemanticError([]byte("for ;; {\n\tbreak\n\ta = 1\n\tend:\n\tprint(a)\n}"), "[4:7] - Variable 'a' is only declared in unreachable code", t)
+
+	// Uses within the unreachable code are removed as well. A reachable assignment in the same block declares it again.
+	var code []byte = []byte(`
+	goto end
+	a = 1
+	b = a + 1
+	end:
+	a = 2
+	print(a)
+	`)
+	testSemantic(code, t)
+	testExecution(code, "2\n2\n", t)
+
+	testSemanticError([]byte("goto end\na = 1\nend:\nif true {\n\ta = 2\n}\nprint(a)"), "[6:6] - Variable 'a' is only declared in unreachable code", t)
+}
+
+func TestSemanticGotoOverDeclaration(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 0
+	if a == 0 {
+		goto end
+	}
+	b = 1
+	end:
+	print(b)
+	`)
+	testSemanticError(code, "[3:2] - 'goto end' jumps over the declaration at [5:1]", t)
+
+	testSemanticError([]byte("a = 1\nif a == 1 {\n\tgoto end\n}\nstruct p { x: int }\nend:\nprint(a)"), "[2:1] - 'goto end' jumps over the declaration at [4:0]", t)
+
+	// Assigning a known name or jumping backward is fine
+	code = []byte(`
+	a = 0
+	goto end
+	end:
+	b = 1
+	if b < 3 {
+		a = 2
+		goto end
+	}
+	print(a)
+	`)
+	testSemantic(code, t)
+}
+
+func TestSemanticBreakOutsideLoop(t *testing.T) {
+
+	var code []byte = []byte(`
+	if true {
+		continue
+	}
+	`)
+
+	testSemanticError(code, "[2:2] - 'continue' is only allowed inside a loop", t)
+}
+
+func TestSemanticModuloNeedsNumber(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = true % false
+	`)
+
+	testSemanticError(code, "[1:5] - BinaryOp '%' needs int/uint/float, got: 'bool'", t)
+}
+
+func TestSemanticGotoIntoLoop(t *testing.T) {
+
+	var code []byte = []byte(`
+	goto inner
+	for i = 0; i < 5; i = i+1 {
+		inner:
+		a = i
+	}
+	`)
+
+	testSemanticError(code, "[1:1] - Label 'inner' is not defined in this or any surrounding block", t)
+}
+
+func TestSemanticLabelDefinedTwice(t *testing.T) {
+
+	var code []byte = []byte(`
+	a:
+	if true {
+		a:
+	}
+	`)
+
+	testSemanticError(code, "[3:2] - Label 'a' is already defined", t)
+}
+
+func TestSemanticUnusedLabel(t *testing.T) {
+
+	var code []byte = []byte(`
+	used:
+	unused:
+	a = 1
+	if a == 2 {
+		goto used
+	}
+	`)
+
+	testWarnings(code, []string{"[2:1] - warning - label 'unused' is never used"}, t)
+}
+
+func TestSemanticLoopAssignmentCount(t *testing.T) {
+
+	testSemanticError(
+		[]byte(`for i, j = 0; i < 5; i = i+1 {}`),
+		"[0:4] - Assignment ?(i), ?(j) = int(0) - variables and expression count need to match", t,
+	)
+	testSemanticError(
+		[]byte(`for i, j = 0, 5; i < j; i, j = i+1 {}`),
+		"[0:24] - Assignment ?(i), ?(j) = ?(i) + int(1) - variables and expression count need to match", t,
+	)
+}
+
+func TestSemanticStringComparisonFolding(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = "a!" < "a"
+	b = "ab" > "a"
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []string{"false", "true"} {
+		c, ok := ast.block.statements[i].(Assignment).expressions[0].(Constant)
+		if !ok || c.cValue != expected {
+			t.Errorf("Expected comparison to be folded to %v, got: %v", expected, ast.block.statements[i])
+		}
+	}
+}
+
+func TestSemanticIncrementType(t *testing.T) {
+
+	testSemanticError([]byte("s = \"s\"\ns++"), "[1:0] - '++' needs an int, uint or float variable, got: string", t)
+	testSemanticError([]byte("b = true\nb--"), "[1:0] - '--' needs an int, uint or float variable, got: bool", t)
+
+	ast := testSemantic([]byte("f = 1.5\nf++"), t)
+	if e := ast.block.statements[1].(Assignment).expressions[0]; e.getExpressionType() != TYPE_FLOAT {
+		t.Errorf("Expected float increment, got: %v", e)
+	}
+}
+
+func TestSemanticUnknownConstant(t *testing.T) {
+
+	// The lexer never produces such a constant, so the AST is built by hand
+	bad := Constant{TYPE_UNKNOWN, "12abc", 3, 7}
+	ast := newAST(newBlock([]Statement{
+		Assignment{[]Variable{Variable{TYPE_UNKNOWN, "a", false, 3, 3}}, []Expression{bad}, "", 3, 3},
+	}))
+
+	_, diagnostics := semanticAnalysis(ast, Options{})
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic, got: %v", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.line != 3 || d.column != 7 || d.message != "Internal error - Unknown type for constant <<12abc>>" {
+		t.Errorf("Unexpected diagnostic: %v:%v %v", d.line, d.column, d.message)
+	}
+}
+
+func TestSemanticSymbolTables(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	if a < 2 {
+		b = "s"
+		shadow a = true
+	}
+	`)
+
+	ast := testSemantic(code, t)
+
+	expected := newAST(newBlock([]Statement{
+		newAssignment([]Variable{newVar(TYPE_INT, "a", false)}, []Expression{newConst(TYPE_INT, "1")}),
+		newCondition(
+			newBinary(OP_LESS, newVar(TYPE_INT, "a", false), newConst(TYPE_INT, "2"), TYPE_BOOL, false),
+			newBlock(
+				[]Statement{
+					newAssignment([]Variable{newVar(TYPE_STRING, "b", false)}, []Expression{newConst(TYPE_STRING, "\"s\"")}),
+					newAssignment([]Variable{newVar(TYPE_BOOL, "a", true)}, []Expression{newConst(TYPE_BOOL, "true")}),
+				},
+				newVar(TYPE_STRING, "b", false), newVar(TYPE_BOOL, "a", false),
+			),
+			newBlock(nil),
+		),
+	}, newVar(TYPE_INT, "a", false)))
+
+	if b, e := compareASTs(ast, expected); !b {
+		t.Errorf("Trees don't match: %v", e)
+	}
+
+	// A wrong type in the symbol table has to be found
+	expected.block.symbolTable.table["a"] = SymbolEntry{sType: TYPE_FLOAT}
+	if b, _ := compareASTs(ast, expected); b {
+		t.Errorf("Expected symbol tables to be different")
+	}
+}
+
+func TestSemanticFunctionCall(t *testing.T) {
+
+	ast := testSemantic([]byte(`print(len("abc"))`), t)
+
+	call := ast.block.statements[0].(ExprStatement).expression.(FunctionCall)
+	if call.fType != TYPE_VOID || call.args[0].getExpressionType() != TYPE_INT {
+		t.Errorf("Expected print(int) without result, got: %v %v", call.fType, call.args[0].getExpressionType())
+	}
+
+	testSemanticError([]byte(`foo(1)`), "[0:0] - Unknown function 'foo'", t)
+	testSemanticError([]byte(`len(1)`), "[0:4] - Function 'len' can not be called with 'int'", t)
+	testSemanticError([]byte(`a = print(1)`), "[0:0] - Cannot assign print(int(1)) to 'a'", t)
+}
+
+func TestSemanticWarnExpressionStatementWithoutEffect(t *testing.T) {
+
+	var code []byte = []byte(`
+	len("abc")
+	print("abc")
+	`)
+
+	testWarnings(code, []string{`[1:1] - warning - result of 'len(string("abc"))' is not used and has no effect`}, t)
+
+	// Escape sequences are written as such, so the warning stays on one line
+	testWarnings([]byte(`len("a\n\"b\"")`), []string{`[0:0] - warning - result of 'len(string("a\n\"b\""))' is not used and has no effect`}, t)
+}
+
+func TestSemanticChainedComparison(t *testing.T) {
+
+	testSemanticError(
+		[]byte(`a = 5 < false <= 8`),
+		"[0:4] - Comparisons can not be chained: '<' and '<='. Use parentheses to compare a bool result", t,
+	)
+	testSemantic([]byte(`a = (5 < 6) == true`), t)
+}
+
+func TestSemanticUnsignedFolding(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 18446744073709551615u > 1u
+	b = -1 > 1
+	c = 0u - 1u
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []string{"true", "false", "18446744073709551615u"} {
+		c := ast.block.statements[i].(Assignment).expressions[0].(Constant)
+		if c.cValue != expected {
+			t.Errorf("Expected statement %v to fold into %v, got: %v", i, expected, c)
+		}
+	}
+
+	testSemanticError([]byte(`a = 1u < 2`), "[0:4] - BinaryOp '<' expected same type, got: 'uint', 'int'", t)
+	testSemanticError([]byte("a = 1u\nb = -a"), "Unary '-' expression must be float or int", t)
+}
+
+func TestSemanticPowerFolding(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 2 ** 10 == 1024
+	b = 2 ** 3 ** 2
+	c = 2 * 3 ** 2
+	d = 2 ** -1
+	e = (-1) ** -3
+	f = 2.0 ** 0.5
+	g = -(2) ** 2
+	h = -(3) + 5
+	i = 5.5 % -2.0
+	j = -2 ** 2
+	k = (-2) ** 2
+	l = -2.0 ** 2.0
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []string{"true", "512", "18", "0", "-1", "1.4142135623730951", "-4", "2", "1.5", "-4", "4", "-4.0"} {
+		c := ast.block.statements[i].(Assignment).expressions[0].(Constant)
+		if c.cValue != expected {
+			t.Errorf("Expected statement %v to fold into %v, got: %v", i, expected, c)
+		}
+	}
+
+	testSemanticError([]byte(`a = "a" ** 2`), "[0:4] - BinaryOp '**' expected same type, got: 'string', 'int'", t)
+	testSemanticError([]byte(`a = 0 ** -1`), "[0:4] - Division by zero", t)
+}
+
+func TestSemanticScope(t *testing.T) {
+
+	var scope Scope
+	outer := scope.push(nil)
+	scope.define("a", SymbolEntry{sType: TYPE_INT})
+
+	middle := scope.push(nil)
+	if err := scope.bind(Variable{vName: "b", vShadow: false}, TYPE_FLOAT); err != nil {
+		t.Fatalf("Expected b to be defined, got: %v", err)
+	}
+
+	scope.push(nil)
+	if err := scope.bind(Variable{vName: "a", vShadow: true}, TYPE_STRING); err != nil {
+		t.Fatalf("Expected a to be shadowed, got: %v", err)
+	}
+	if err := scope.bind(Variable{vName: "a", vShadow: true}, TYPE_BOOL); err == nil || !errors.Is(err, ErrCritical) {
+		t.Errorf("Expected a critical error when shadowing twice in the same scope, got: %v", err)
+	}
+
+	for name, expected := range map[string]Type{"a": TYPE_STRING, "b": TYPE_FLOAT} {
+		if entry, ok := scope.resolve(name); !ok || entry.sType != expected {
+			t.Errorf("Expected %v to resolve to %v in the inner scope, got: %v, %v", name, expected, entry.sType, ok)
+		}
+	}
+	if _, ok := scope.resolveLocal("b"); ok {
+		t.Errorf("Expected b not to be local to the inner scope")
+	}
+
+	scope.pop()
+	if p := scope.pop(); p != middle {
+		t.Errorf("Expected to pop the middle scope")
+	}
+	if entry, ok := scope.resolve("a"); !ok || entry.sType != TYPE_INT {
+		t.Errorf("Expected a to resolve to the outer int again, got: %v, %v", entry.sType, ok)
+	}
+	if _, ok := scope.resolve("b"); ok {
+		t.Errorf("Expected b to be out of scope")
+	}
+	if scope.pop() != outer || scope.top != nil {
+		t.Errorf("Expected the scope stack to be empty")
+	}
+}
+
+func TestSemanticChainedAssignment(t *testing.T) {
+
+	ast := testSemantic([]byte(`a = b = 0`), t)
+	for _, name := range []string{"a", "b"} {
+		if entry, ok := ast.block.symbolTable.get(name); !ok || entry.sType != TYPE_INT {
+			t.Errorf("Expected %v to be an int, got: %v, %v", name, entry.sType, ok)
+		}
+	}
+
+	testSemanticError([]byte("a = 1.0\na = b = 0"), "[1:0] - Assignment type missmatch between variable ?(a) and expression int", t)
+}
+
+func TestSemanticWarnInfiniteLoop(t *testing.T) {
+
+	testWarnings([]byte(`
+	for ;; {
+	}
+	for ;; {
+		for ;; {
+			break
+		}
+	}
+	`), []string{
+		"[1:1] - warning - possibly infinite loop: no exit condition and no break",
+		"[3:1] - warning - possibly infinite loop: no exit condition and no break",
+	}, t)
+
+	testWarnings([]byte(`
+	a = 1
+	for ;; {
+		if a > 10 {
+			break
+		}
+		a++
+	}
+	for ;; {
+		goto end
+	}
+	end:
+	for i = 0; i < 3; i++ {
+	}
+	for ;; {
+		if a > 10 {
+			exit(0)
+		}
+		a++
+	}
+	`), []string{}, t)
+}
+
+func TestSemanticArgcExit(t *testing.T) {
+
+	testSemantic([]byte("a = argc + 1\nexit(a)"), t)
+
+	testSemanticError([]byte(`argc = "a"`), "Assignment type missmatch between variable ?(argc) and expression string", t)
+	testSemanticError([]byte(`exit(1.5)`), "[0:5] - Function 'exit' can not be called with 'float'", t)
+
+	// Constant exit codes are checked after folding
+	testSemantic([]byte("exit(0)\nexit(200 + 55)"), t)
+	testSemanticError([]byte(`exit(256)`), "[0:5] - Exit code 256 is out of range 0..255", t)
+	testSemanticError([]byte(`exit(-1)`), "[0:5] - Exit code -1 is out of range 0..255", t)
+	testSemanticError([]byte(`exit(2 ** 10)`), "[0:5] - Exit code 1024 is out of range 0..255", t)
+}
+
+func TestSemanticComparisonAssignment(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 3 < 5
+	x = 3
+	b = x < 5
+	`)
+
+	ast := testSemantic(code, t)
+
+	for _, i := range []int{0, 2} {
+		if v := ast.block.statements[i].(Assignment).variables[0]; v.vType != TYPE_BOOL {
+			t.Errorf("Expected '%v' to be bool, got: %v", v.vName, v.vType)
+		}
+	}
+}
+
+func TestSemanticIndex(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = "hello"[1]
+	s = "hello"
+	b = s[4 - 4]
+	`)
+
+	ast := testSemantic(code, t)
+
+	if e := ast.block.statements[0].(Assignment).expressions[0]; e != Expression(Constant{TYPE_INT, "101", 1, 5}) {
+		t.Errorf("Expected the index to be folded into 101, got: %v", e)
+	}
+	if e, ok := ast.block.statements[2].(Assignment).expressions[0].(Index); !ok || e.iType != TYPE_INT {
+		t.Errorf("Expected an index of type int, got: %v", ast.block.statements[2])
+	}
+
+	testSemanticError([]byte(`a = 1[0]`), "[0:4] - Only strings can be indexed, got 'int'", t)
+	testSemanticError([]byte(`a = "abc"[1u]`), "[0:10] - Index must be int, got 'uint'", t)
+	testSemanticError([]byte(`a = "abc"[3]`), "[0:10] - Index 3 is out of range for a string of length 3", t)
+	testSemanticError([]byte("s = \"abc\"\na = s[-1]"), "[1:6] - Index -1 is negative", t)
+	// Indices, that only become constant by folding, are checked the same way
+	testSemanticError([]byte(`a = "abc"[-1]`), "[0:10] - Index -1 is negative", t)
+	testSemanticError([]byte("s = \"abc\"\na = s[1 - 3]"), "[1:6] - Index -2 is negative", t)
+	testSemanticError([]byte("const k = -2\ns = \"abc\"\na = s[k + 1]"), "[2:6] - Index -1 is negative", t)
+}
+
+func TestSemanticLenFolding(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = len("hello")
+	s = "hi\n"
+	b = len(s)
+	const c = len("abc") * 2
+	`)
+
+	ast := testSemantic(code, t)
+
+	if e := ast.block.statements[0].(Assignment).expressions[0]; e != Expression(Constant{TYPE_INT, "5", 1, 5}) {
+		t.Errorf("Expected len to be folded into 5, got: %v", e)
+	}
+	if e, ok := ast.block.statements[2].(Assignment).expressions[0].(FunctionCall); !ok || e.fType != TYPE_INT {
+		t.Errorf("Expected len of a variable at run time, got: %v", ast.block.statements[2])
+	}
+	if c := ast.block.statements[3].(ConstDeclaration).expression; c != Expression(Constant{TYPE_INT, "6", 4, 11}) {
+		t.Errorf("Expected the constant to be 6, got: %v", c)
+	}
+}
+
+func TestSemanticConditionType(t *testing.T) {
+
+	var code []byte = []byte(`
+	flag = argc > 1
+	if flag {
+		print(1)
+	} else if !flag {
+		print(2)
+	}
+	`)
+
+	ast := testSemantic(code, t)
+
+	if e, ok := ast.block.statements[1].(Condition).expression.(Variable); !ok || e.vType != TYPE_BOOL {
+		t.Errorf("Expected the condition to be the bool variable 'flag', got: %v", ast.block.statements[1].(Condition).expression)
+	}
+
+	testSemanticError([]byte(`if 5 {}`), "[0:3] - If expression expected boolean, got: int", t)
+	testSemanticError([]byte(`if "x" {}`), "[0:3] - If expression expected boolean, got: string", t)
+	testSemanticError([]byte("a = 1.5\nif a {}"), "[1:3] - If expression expected boolean, got: float", t)
+	testSemanticError([]byte("if true {} else if len(\"x\") {}"), "[0:19] - If expression expected boolean, got: int", t)
+}
+
+func TestSemanticMinMax(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = min(argc, 2)
+	b = max(1.5, 2.5 * 2.0)
+	c = min(1u, 2u)
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []Type{TYPE_INT, TYPE_FLOAT, TYPE_UINT} {
+		if v := ast.block.statements[i].(Assignment).variables[0]; v.vType != expected {
+			t.Errorf("Expected '%v' to be %v, got: %v", v.vName, expected, v.vType)
+		}
+	}
+
+	testSemanticError([]byte(`a = min(1, 2.5)`), "[0:11] - Function 'min' expects arguments of the same type, got 'int' and 'float'", t)
+	testSemanticError([]byte(`a = max("a", "b")`), "[0:8] - Function 'max' can not be called with 'string'", t)
+	testSemanticError([]byte(`a = max(1)`), "[0:4] - Function 'max' expects 2 arguments, got 1", t)
+}
+
+func TestSemanticBlankIdentifier(t *testing.T) {
+
+	var code []byte = []byte(`
+	_, b = 1, 2
+	_ = len("abc")
+	`)
+
+	ast := testSemantic(code, t)
+
+	if _, ok := ast.block.symbolTable.table["_"]; ok {
+		t.Errorf("Expected no symbol for '_'")
+	}
+	if entry, ok := ast.block.symbolTable.table["b"]; !ok || entry.sType != TYPE_INT {
+		t.Errorf("Expected 'b' to be bound as int, got: %v", entry)
+	}
+
+	testSemanticError([]byte("_ = 1\na = _"), "[1:4] - '_' can only be assigned to. It has no value", t)
+	testSemanticError([]byte("_ = 1\n_++"), "[1:0] - '_' can only be assigned to. It has no value", t)
+	testSemanticError([]byte("_ = print(1)"), "[0:0] - Cannot assign print(int(1)) to '_'", t)
+}
+
+func TestSemanticAssert(t *testing.T) {
+
+	testSemantic([]byte("a = 1\nassert(a < 2)"), t)
+	testSemanticError([]byte("assert(1)"), "[0:7] - Function 'assert' can not be called with 'int'", t)
+	testSemanticError([]byte("a = assert(true)"), "[0:0] - Cannot assign assert(bool(true)) to 'a'", t)
+}
+
+func TestSemanticConversion(t *testing.T) {
+
+	var code []byte = []byte(`
+	i = 3
+	a = float(i)
+	b = int(-2.7)
+	c = int(true)
+	d = bool(0u)
+	e = uint(-1)
+	f = float(3) / 2.0
+	`)
+
+	ast := testSemantic(code, t)
+
+	if v := ast.block.statements[1].(Assignment).variables[0]; v.vType != TYPE_FLOAT {
+		t.Errorf("Expected 'a' to be float, got: %v", v.vType)
+	}
+	for i, expected := range []string{"-2", "1", "false", "18446744073709551615u", "1.5"} {
+		c, ok := ast.block.statements[i+2].(Assignment).expressions[0].(Constant)
+		if !ok || c.cValue != expected {
+			t.Errorf("Expected statement %v to fold into %v, got: %v", i+2, expected, ast.block.statements[i+2])
+		}
+	}
+
+	testSemanticError([]byte(`a = int("s")`), "[0:4] - Cannot convert 'string' to 'int'", t)
+	testSemanticError([]byte("a = bool(1.5)"), "[0:4] - Cannot convert 'float' to 'bool'", t)
+	testSemanticError([]byte("a = float(print(1))"), "[0:4] - Cannot convert 'void' to 'float'", t)
+}
+
+func TestSemanticStruct(t *testing.T) {
+
+	var code []byte = []byte(`
+	struct p { x: int; f: float; s: string }
+	p.f = 1.5
+	a = p.s
+	`)
+
+	ast := testSemantic(code, t)
+
+	decl := ast.block.statements[0].(StructDeclaration)
+	for i, offset := range []int{0, 8, 16} {
+		if decl.fields[i].offset != offset {
+			t.Errorf("Expected field '%v' at offset %v, got: %v", decl.fields[i].name, offset, decl.fields[i].offset)
+		}
+	}
+	if f := ast.block.statements[1].(FieldAssignment).field; f.fType != TYPE_FLOAT || f.offset != 8 {
+		t.Errorf("Expected float field at offset 8, got: %v at %v", f.fType, f.offset)
+	}
+	if v := ast.block.statements[2].(Assignment).variables[0]; v.vType != TYPE_STRING {
+		t.Errorf("Expected 'a' to be string, got: %v", v.vType)
+	}
+
+	for code, expected := range map[string]string{
+		"struct p { x: int }\np.x = 1.5": "[1:0] - Assignment type missmatch between field p.x ('int') and expression 'float'",
+		"struct p { x: int }\na = p.y":   "[1:4] - Struct 'p' has no field 'y'",
+		"struct p { x: int }\na = p":     "[1:4] - Struct 'p' can only be used through its fields",
+		"struct p { x: int }\np = 1":     "[1:0] - Assignment type missmatch between variable",
+		"struct p { x: int; x: bool }":   "[0:19] - Field 'x' is already declared in struct 'p'",
+		"struct p {}":                    "[0:7] - Struct 'p' needs at least one field",
+		"p = 1\nstruct p { x: int }":     "[1:7] - Struct 'p' is already declared",
+		"a = 1\nb = a.x":                 "[1:4] - Variable 'a' is no struct, got 'int'",
+		"a = q.x":                        "[0:4] - Variable 'q' referenced before declaration",
+	} {
+		testSemanticError([]byte(code), expected, t)
+	}
+}
+
+func TestSemanticTypeAnnotation(t *testing.T) {
+
+	var code []byte = []byte(`
+	a float, b uint = 1.5, 2u
+	const c int = 3
+	for i int = 0; i < c; i++ {
+		shadow a float = 2.5
+	}
+	`)
+
+	ast := testSemantic(code, t)
+
+	if v := ast.block.statements[0].(Assignment).variables; v[0].vType != TYPE_FLOAT || v[1].vType != TYPE_UINT {
+		t.Errorf("Expected float and uint, got: %v", v)
+	}
+
+	for code, expected := range map[string]string{
+		"a int = 1.5":            "[0:0] - Variable 'a' is declared as 'int', got 'float'",
+		"a, b bool = 1, 2":       "[0:3] - Variable 'b' is declared as 'bool', got 'int'",
+		"const c string = 1":     "[0:6] - Constant 'c' is declared as 'string', got 'int'",
+		"a = 1\na float = 2.0":   "[1:0] - Assignment type missmatch between variable",
+		"for i uint = 0; ; {\n}": "[0:4] - Variable 'i' is declared as 'uint', got 'int'",
+	} {
+		testSemanticError([]byte(code), expected, t)
+	}
+}
+
+func TestSemanticShortCircuitTypes(t *testing.T) {
+	// The right side is checked, even if it is never evaluated at run time
+	testSemanticError([]byte(`a = true || (5 + "x")`), "[0:13] - BinaryOp '+' expected same type, got: 'int', 'string'", t)
+	testSemanticError([]byte(`a = false && !5`), "[0:13] - Unary '!' expression must be bool", t)
+	testSemanticError([]byte(`a = (1 < 2) || 3`), "[0:5] - BinaryOp '||' expected same type, got: 'bool', 'int'", t)
+}
+
+func TestSemanticWarnShadow(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	const c = 2
+	if argc > 0 {
+		shadow a = 2
+		shadow b = 3
+		shadow c = 4
+		shadow argc = 0
+	}
+	`)
+
+	// Only with the flag
+	testWarnings(code, []string{}, t)
+
+	ast, err := analyze(code, Options{wshadow: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"[4:9] - warning - 'a' shadows the declaration at [1:1]",
+		"[6:9] - warning - 'c' shadows the declaration at [2:7]",
+		"[7:9] - warning - 'argc' shadows the predefined variable",
+	}
+	if len(ast.warnings) != len(expected) {
+		t.Fatalf("Expected %v warnings, got: %v", len(expected), ast.warnings)
+	}
+	for i, w := range ast.warnings {
+		if w.Error() != expected[i] {
+			t.Errorf("Expected warning '%v', got: '%v'", expected[i], w)
+		}
+	}
+}
+
+func TestSemanticUnusedWarning(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 1
+	b = a
+	struct p { x: int }
+	struct q { y: int }
+	q.y = 2
+	const c = 2
+	if argc > 0 {
+		d = 3
+		d = 4
+	}
+	for i = 0; i < 2; i++ {}
+	`)
+
+	// Only with the flag
+	testWarnings(code, []string{}, t)
+
+	ast, err := analyze(code, Options{wunused: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A block reports its names at its end, so 'd' comes first. A struct is used through any of its fields.
+	expected := []string{
+		"[8:2] - warning - 'd' is declared but never used",
+		"[2:1] - warning - 'b' is declared but never used",
+		"[3:8] - warning - 'p' is declared but never used",
+	}
+	if len(ast.warnings) != len(expected) {
+		t.Fatalf("Expected %v warnings, got: %v", len(expected), ast.warnings)
+	}
+	for i, w := range ast.warnings {
+		if w.Error() != expected[i] {
+			t.Errorf("Expected warning '%v', got: '%v'", expected[i], w)
+		}
+	}
+}
+
+func TestSemanticBitNot(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = ~0
+	b = ~5u
+	c = ~argc
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []string{"-1", "18446744073709551610u"} {
+		c, ok := ast.block.statements[i].(Assignment).expressions[0].(Constant)
+		if !ok || c.cValue != expected {
+			t.Errorf("Expected statement %v to fold into %v, got: %v", i, expected, ast.block.statements[i])
+		}
+	}
+	if v := ast.block.statements[2].(Assignment).variables[0]; v.vType != TYPE_INT {
+		t.Errorf("Expected 'c' to be int, got: %v", v.vType)
+	}
+
+	testSemanticError([]byte("a = ~true"), "[0:4] - Unary '~' expression must be int or uint, but is: ~(bool(true))", t)
+	testSemanticError([]byte("a = ~1.5"), "[0:4] - Unary '~' expression must be int or uint", t)
+}
+
+func TestSemanticFloatConstant(t *testing.T) {
+
+	var code []byte = []byte(`
+	a = 10000.1234
+	b = 007.50
+	c = 1_000.000_1
+	d = -0.0
+	e = 100000000000000000000.0
+	`)
+
+	ast := testSemantic(code, t)
+
+	for i, expected := range []string{"10000.1234", "7.5", "1000.0001", "-0.0", "100000000000000000000.0"} {
+		c, ok := ast.block.statements[i].(Assignment).expressions[0].(Constant)
+		if !ok || c.cType != TYPE_FLOAT || c.cValue != expected {
+			t.Errorf("Expected statement %v to be the float %v, got: %v", i, expected, ast.block.statements[i])
+		}
+	}
+
+	// The largest float is about 1.8e308
+	huge := strings.Repeat("9", 400) + ".0"
+	testSemanticError([]byte("a = "+huge), "[0:4] - Float constant is out of range", t)
+	testSemanticError([]byte("a = 1.5\nb = a + -"+huge), "[1:8] - Float constant is out of range", t)
+}
+
+// An int literal, that does not fit into 64 bit, must not be folded to 0.
+func TestSemanticIntConstantRange(t *testing.T) {
+
+	testSemantic([]byte("a = 9223372036854775807\nb = -9223372036854775808\nc = 18446744073709551615u"), t)
+
+	testSemanticError([]byte("a = 9223372036854775808"), "[0:4] - Constant 9223372036854775808 is out of range", t)
+	testSemanticError([]byte("a = 1 + -9223372036854775809"), "[0:8] - Constant -9223372036854775809 is out of range", t)
+	testSemanticError([]byte("a = 18446744073709551616u"), "[0:4] - Constant 18446744073709551616u is out of range", t)
+	testSemanticError([]byte("const a = 99999999999999999999 * 0"), "Constant 99999999999999999999 is out of range", t)
+}
+
+// With trapv, a constant expression must not wrap around silently. The program would abort at run time.
+func TestSemanticTrapvFolding(t *testing.T) {
+
+	for code, expected := range map[string]string{
+		"a = 9223372036854775807 + 1":              "[0:4] - Integer overflow in '+'",
+		"a = -9223372036854775807 - 2":             "[0:4] - Integer overflow in '-'",
+		"a = 4611686018427387904 * 2":              "[0:4] - Integer overflow in '*'",
+		"a = -1 * -9223372036854775808":            "[0:4] - Integer overflow in '*'",
+		"a = -(-9223372036854775808)":              "[0:4] - Integer overflow in '-'",
+		"const c = 9223372036854775807\na = c + 1": "[1:4] - Integer overflow in '+'",
+	} {
+		_, _, err := compile([]byte(code), Options{trapv: true, check: true})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing '%v' for %q, got: %v", expected, code, err)
+		}
+		// Without trapv, the value wraps around like at run time
+		if _, _, err := compile([]byte(code), Options{check: true}); err != nil {
+			t.Errorf("Expected no error without trapv for %q, got: %v", code, err)
+		}
+	}
+
+	for _, code := range []string{"a = 9223372036854775806 + 1", "a = -9223372036854775807 - 1", "a = 3037000499 * 3037000499", "a = -9223372036854775808 * 1"} {
+		if _, _, err := compile([]byte(code), Options{trapv: true, check: true}); err != nil {
+			t.Errorf("Expected no overflow for %q, got: %v", code, err)
+		}
+	}
+}